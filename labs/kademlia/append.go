@@ -0,0 +1,231 @@
+package kademlia
+
+// append.go: append keys, which collect a set of distinct values instead of
+// holding one (opt-in, see WithAppendKeys).
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrAppendDisabled is returned by PutAppend on a node without WithAppendKeys.
+var ErrAppendDisabled = errors.New("append keys disabled")
+
+// maxAppendValues caps how many values one append key collects. The FIND_VALUE
+// reply carries the whole set, so its total size is also kept to chunkSize.
+const maxAppendValues = 64
+
+// maxAppendKeys caps how many append keys a node holds sets for, so peers
+// can't grow appendStore without bound by appending under fresh keys.
+const maxAppendKeys = 1024
+
+// appendSet is one key's collected values, guarded by Kademlia.storeMu.
+type appendSet struct {
+	values  [][]byte
+	size    int       // total bytes of values
+	expires time.Time // zero = never (storeTTL <= 0)
+}
+
+// expired reports whether the set has outlived its deadline by now.
+func (set *appendSet) expired(now time.Time) bool {
+	return !set.expires.IsZero() && now.After(set.expires)
+}
+
+// PutAppend adds data to the set of values under keyHex, here and on the
+// nodes the replication strategy picks for the key; a value already in the
+// set is not added twice. Unlike Put, the values needn't hash to the key.
+// Append sets live in memory only and aren't republished: a set expires
+// storeTTL after its last append, and its bytes count toward the storage
+// quota. Returns an error if the local set is full, or if every target
+// refused. A client-mode node appends only to the replicas.
+func (kademlia *Kademlia) PutAppend(keyHex string, data []byte) error {
+	if !kademlia.appendKeys {
+		return ErrAppendDisabled
+	}
+	keyHex, err := canonicalKey(keyHex)
+	if err != nil {
+		return err
+	}
+	id, err := parseKeyHex(keyHex)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("empty value")
+	}
+	if len(data) > chunkSize {
+		return fmt.Errorf("%w: %d bytes, PutAppend stores at most %d", ErrValueTooLarge, len(data), chunkSize)
+	}
+	if kademlia.valueTooLarge(len(data)) {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrValueTooLarge, len(data), kademlia.maxValueBytes.Load())
+	}
+	if !kademlia.clientMode { // a client keeps no set of its own
+		if _, reason := kademlia.appendLocal(keyHex, data); reason != "" {
+			return fmt.Errorf("append to %s: %s", keyHex, reason)
+		}
+	}
+
+	ctx := context.Background()
+	acked := 0
+	var refusals []error
+	for _, c := range kademlia.placement(ctx, id, nil) {
+		err := kademlia.network.sendAppendToCtx(ctx, &c, keyHex, data, kademlia.timeoutRPC, nil)
+		if err == nil {
+			acked++
+		} else if errors.Is(err, ErrStoreRejected) {
+			refusals = append(refusals, err)
+		}
+	}
+	kademlia.debugf("[APPEND] key=%s acked=%d refused=%d", keyHex, acked, len(refusals))
+	if acked == 0 && len(refusals) > 0 {
+		return errors.Join(refusals...)
+	}
+	if acked == 0 && kademlia.clientMode {
+		return errors.New("client mode: no peer stored the value")
+	}
+	return nil
+}
+
+// GetAll returns every distinct value appended under keyHex that this node
+// or the key's replica nodes hold, ours first, then in the order the
+// replicas list them. Values are never cached from the network.
+func (kademlia *Kademlia) GetAll(keyHex string) ([][]byte, error) {
+	keyHex, err := canonicalKey(keyHex)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseKeyHex(keyHex)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	targets := kademlia.placement(ctx, id, nil)
+	sets := make([][][]byte, len(targets))
+	var wg sync.WaitGroup
+	for i := range targets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sets[i], _ = kademlia.network.sendFindAllToCtx(ctx, &targets[i], keyHex, kademlia.timeoutRPC, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	var out [][]byte
+	seen := make(map[string]struct{})
+	for _, set := range append([][][]byte{kademlia.loadAppended(keyHex)}, sets...) {
+		for _, v := range set {
+			if _, dup := seen[string(v)]; !dup {
+				seen[string(v)] = struct{}{}
+				out = append(out, v)
+			}
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("not found")
+	}
+	return out, nil
+}
+
+// appendLocal adds value to keyHex's local set, reporting whether it was
+// new, and restarts the set's storeTTL. A non-empty reason (a STORE_ERR
+// reason) means the node can't take it: the set or the number of append keys
+// is at its cap, or the value is too large or would take the store past its
+// quota.
+func (kademlia *Kademlia) appendLocal(keyHex string, value []byte) (added bool, reason string) {
+	if kademlia.valueTooLarge(len(value)) {
+		return false, rejectTooLarge
+	}
+	now := time.Now()
+	kademlia.storeMu.Lock()
+	defer kademlia.storeMu.Unlock()
+	set := kademlia.appendStore[keyHex]
+	if set != nil && set.expired(now) {
+		kademlia.dropAppendLocked(keyHex)
+		set = nil
+	}
+	if set != nil {
+		for _, v := range set.values {
+			if bytes.Equal(v, value) {
+				kademlia.setAppendExpiryLocked(set, now)
+				return false, ""
+			}
+		}
+		if len(set.values) >= maxAppendValues {
+			return false, rejectFull
+		}
+		if set.size+len(value) > chunkSize {
+			return false, rejectTooLarge
+		}
+	} else if len(kademlia.appendStore) >= maxAppendKeys {
+		return false, rejectFull
+	}
+	if quota := kademlia.storageQuota.Load(); quota > 0 && kademlia.storedBytes+kademlia.appendBytes+int64(len(value)) > quota {
+		return false, rejectFull
+	}
+	if set == nil {
+		if kademlia.appendStore == nil {
+			kademlia.appendStore = make(map[string]*appendSet)
+		}
+		set = &appendSet{}
+		kademlia.appendStore[keyHex] = set
+	}
+	set.values = append(set.values, append([]byte(nil), value...))
+	set.size += len(value)
+	kademlia.appendBytes += int64(len(value))
+	kademlia.setAppendExpiryLocked(set, now)
+	return true, ""
+}
+
+// setAppendExpiryLocked gives set a deadline storeTTL after now, or none.
+// Callers hold storeMu for writing.
+func (kademlia *Kademlia) setAppendExpiryLocked(set *appendSet, now time.Time) {
+	set.expires = time.Time{}
+	if kademlia.storeTTL > 0 {
+		set.expires = now.Add(kademlia.storeTTL)
+	}
+}
+
+// dropAppendLocked removes keyHex's set. Callers hold storeMu for writing.
+func (kademlia *Kademlia) dropAppendLocked(keyHex string) {
+	if set, ok := kademlia.appendStore[keyHex]; ok {
+		kademlia.appendBytes -= int64(set.size)
+		delete(kademlia.appendStore, keyHex)
+	}
+}
+
+// sweepAppendSets drops every append set past its deadline and returns how
+// many it dropped.
+func (kademlia *Kademlia) sweepAppendSets() int {
+	now := time.Now()
+	kademlia.storeMu.Lock()
+	defer kademlia.storeMu.Unlock()
+	n := 0
+	for k, set := range kademlia.appendStore {
+		if set.expired(now) {
+			kademlia.dropAppendLocked(k)
+			n++
+		}
+	}
+	return n
+}
+
+// loadAppended returns a copy of keyHex's local set (nil if it has none or
+// it has expired).
+func (kademlia *Kademlia) loadAppended(keyHex string) [][]byte {
+	kademlia.storeMu.RLock()
+	defer kademlia.storeMu.RUnlock()
+	set := kademlia.appendStore[keyHex]
+	if set == nil || len(set.values) == 0 || set.expired(time.Now()) {
+		return nil
+	}
+	out := make([][]byte, len(set.values))
+	for i, v := range set.values {
+		out[i] = append([]byte(nil), v...)
+	}
+	return out
+}
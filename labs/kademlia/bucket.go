@@ -2,6 +2,8 @@ package kademlia
 
 import (
 	"container/list"
+	"sync/atomic"
+	"time"
 )
 
 // bucket definition
@@ -12,6 +14,9 @@ type bucket struct {
 	// Promoted into the bucket if a slot opens later.
 	repl    []Contact
 	replCap int
+	// Unix nanos of the last add/refresh or lookup that touched this bucket.
+	// Atomic so readers holding only the table's RLock can bump it.
+	lastActivity atomic.Int64
 }
 
 // newBucket returns a new instance of a bucket
@@ -35,15 +40,40 @@ func (bucket *bucket) AddContact(contact Contact) {
 		}
 	}
 
+	contact.LastSeen = time.Now()
 	if element == nil {
 		if bucket.Len() < bucketSize {
 			bucket.list.PushFront(contact)
 		}
 	} else {
+		seen := element.Value.(Contact)
+		seen.LastSeen = contact.LastSeen
+		element.Value = seen
 		bucket.list.MoveToFront(element)
 	}
 }
 
+// oldest returns the entry seen longest ago, the one to probe and maybe
+// evict when the bucket is full (ties go to the one nearer the back), or
+// nil if the bucket is empty.
+func (bucket *bucket) oldest() *list.Element {
+	var old *list.Element
+	for e := bucket.list.Back(); e != nil; e = e.Prev() {
+		if old == nil || e.Value.(Contact).LastSeen.Before(old.Value.(Contact).LastSeen) {
+			old = e
+		}
+	}
+	return old
+}
+
+// seen stamps e's contact with t and moves it to the front.
+func (bucket *bucket) seen(e *list.Element, t time.Time) {
+	c := e.Value.(Contact)
+	c.LastSeen = t
+	e.Value = c
+	bucket.list.MoveToFront(e)
+}
+
 // GetContactAndCalcDistance returns an array of Contacts where
 // the distance has already been calculated
 func (bucket *bucket) GetContactAndCalcDistance(target *KademliaID) []Contact {
@@ -58,6 +88,20 @@ func (bucket *bucket) GetContactAndCalcDistance(target *KademliaID) []Contact {
 	return contacts
 }
 
+// touch records activity on the bucket at t.
+func (bucket *bucket) touch(t time.Time) {
+	bucket.lastActivity.Store(t.UnixNano())
+}
+
+// lastTouched returns the last activity time (zero if never touched).
+func (bucket *bucket) lastTouched() time.Time {
+	n := bucket.lastActivity.Load()
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
 // Len return the size of the bucket
 func (bucket *bucket) Len() int {
 	return bucket.list.Len()
@@ -89,3 +133,30 @@ func (bucket *bucket) popReplacement() (Contact, bool) {
 	bucket.repl = bucket.repl[:n-1]
 	return c, true
 }
+
+// remove deletes the contact with id from the bucket. If it was there, the most
+// recent replacement (if any) is promoted into the freed slot.
+func (bucket *bucket) remove(id *KademliaID) (Contact, bool) {
+	for e := bucket.list.Front(); e != nil; e = e.Next() {
+		c := e.Value.(Contact)
+		if c.ID.Equals(id) {
+			bucket.list.Remove(e)
+			if r, ok := bucket.popReplacement(); ok {
+				bucket.list.PushFront(r)
+			}
+			return c, true
+		}
+	}
+	return Contact{}, false
+}
+
+// removeReplacement drops id from the replacement cache.
+func (bucket *bucket) removeReplacement(id *KademliaID) bool {
+	for i := range bucket.repl {
+		if bucket.repl[i].ID.Equals(id) {
+			bucket.repl = append(bucket.repl[:i], bucket.repl[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package kademlia
+
+// chunk.go: values too big for one datagram, stored as chunks plus a manifest.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// chunkSize is the largest value Put stores as is. A STORE carries the value
+// base64-encoded inside JSON, so 32 KiB leaves room under the 64 KiB
+// datagram (and read buffer) limit. Larger values are split into chunkSize
+// pieces, each stored under its own SHA-1 like any other value, and a
+// manifest listing them is stored under the SHA-1 of the whole value.
+const chunkSize = 32 * 1024
+
+// manifestMagic starts every manifest so get can tell one from a value.
+var manifestMagic = []byte("\x00kademlia-manifest/1\n")
+
+// manifest is what a chunked value's key maps to.
+type manifest struct {
+	Size   int      `json:"size"`   // length of the reassembled value
+	Chunks []string `json:"chunks"` // chunk keys, in order
+}
+
+func encodeManifest(m manifest) []byte {
+	b, _ := json.Marshal(m) // strings and an int: cannot fail
+	return append(append([]byte(nil), manifestMagic...), b...)
+}
+
+// decodeManifest parses v as a manifest; ok is false for ordinary values.
+func decodeManifest(v []byte) (m manifest, ok bool) {
+	if !bytes.HasPrefix(v, manifestMagic) {
+		return manifest{}, false
+	}
+	if err := json.Unmarshal(v[len(manifestMagic):], &m); err != nil || len(m.Chunks) == 0 {
+		return manifest{}, false
+	}
+	for _, c := range m.Chunks {
+		if _, err := parseKeyHex(c); err != nil {
+			return manifest{}, false
+		}
+	}
+	return m, true
+}
+
+// putChunked stores each chunk of data with putValue (at most alpha at a
+// time), then the manifest under sha1(data). The result is the manifest's,
+// with State and Acked lowered to the weakest any chunk reached, Refused
+// raised to the most, and the first chunk's refusal if the manifest had none.
+func (kademlia *Kademlia) putChunked(ctx context.Context, data []byte, op *opAccount) (PutResult, error) {
+	keyHex, keyID := kademlia.keyFromData(data)
+	var pieces [][]byte
+	for off := 0; off < len(data); off += chunkSize {
+		pieces = append(pieces, data[off:min(off+chunkSize, len(data))])
+	}
+	kademlia.debugf("[PUT] key=%s size=%d chunks=%d", keyHex, len(data), len(pieces))
+
+	m := manifest{Size: len(data), Chunks: make([]string, len(pieces))}
+	results := make([]PutResult, len(pieces))
+	errs := make([]error, len(pieces))
+	sem := make(chan struct{}, kademlia.alpha.Load())
+	var wg sync.WaitGroup
+	for i, p := range pieces {
+		chunkHex, chunkID := kademlia.keyFromData(p)
+		m.Chunks[i] = chunkHex
+		wg.Add(1)
+		go func(i int, p []byte) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i], errs[i] = kademlia.putValue(ctx, chunkHex, chunkID, p, op)
+		}(i, p)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return PutResult{Key: kademlia.displayKey(keyHex)}, err
+	}
+	res, err := kademlia.putValue(ctx, keyHex, keyID, encodeManifest(m), op)
+	for i, r := range results {
+		res.State = min(res.State, r.State)
+		res.Acked = min(res.Acked, r.Acked)
+		res.Refused = max(res.Refused, r.Refused)
+		if err == nil && errors.Is(errs[i], ErrStoreRejected) {
+			err = errs[i]
+		}
+	}
+	return res, err
+}
+
+// assemble fetches a manifest's chunks (at most alpha at a time, each cached
+// per cache like any Get) and returns the value they make up, checked
+// against keyHex. from is where the manifest came from; with cache, a
+// manifest from another node is kept once the check passes.
+func (kademlia *Kademlia) assemble(ctx context.Context, keyHex string, m manifest, from *Contact, cache bool, op *opAccount) ([]byte, *Contact, error) {
+	parts := make([][]byte, len(m.Chunks))
+	errs := make([]error, len(m.Chunks))
+	sem := make(chan struct{}, kademlia.alpha.Load())
+	var wg sync.WaitGroup
+	for i, c := range m.Chunks {
+		wg.Add(1)
+		go func(i int, c string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			v, _, err := kademlia.getValue(ctx, c, cache, op, nil)
+			if err == nil && !contentMatchesKey(c, v) {
+				err = errors.New("content does not match key")
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("chunk %d/%d %s: %w", i+1, len(m.Chunks), c, err)
+			}
+			parts[i] = v
+		}(i, c)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	out := bytes.Join(parts, nil)
+	if len(out) != m.Size || !contentMatchesKey(keyHex, out) {
+		return nil, nil, fmt.Errorf("reassembled %d bytes do not match key %s", len(out), keyHex)
+	}
+	if cache && from != nil && from.Address != kademlia.me.Address {
+		kademlia.storeLocalFrom(keyHex, encodeManifest(m), kademlia.storeTTL, Provenance{Source: Fetched, Peer: *from})
+	}
+	return out, from, nil
+}
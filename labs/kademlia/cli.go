@@ -2,6 +2,7 @@ package kademlia
 
 import (
 	"bufio"
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -9,6 +10,10 @@ import (
 	"strings"
 )
 
+// cliCommands lists the commands RunLine understands, in help order.
+// Keep it in sync with the switch in RunLine.
+var cliCommands = []string{"put", "get", "peek", "has", "closest", "keys", "cache", "forget", "pin", "unpin", "rebalance", "info", "exit"}
+
 // CLI is a thin command layer over a running Kademlia node.
 // It does not own the node's lifecycle; it only issues commands to it.
 type CLI struct {
@@ -31,7 +36,17 @@ func NewCLI(k *Kademlia, in io.Reader, out io.Writer, quit func()) *CLI {
 // Expected commands:
 //
 //	put <content>      -> prints 40-char sha1 hex
+//	put -v <content>   -> also prints one "replica <addr> <id>" line per target,
+//	                      then "state <local_only|pending|complete>"
 //	get <key-hex>      -> prints the content and a "from <addr>" line
+//	peek <key-hex>     -> like get, but never caches the value locally
+//	has <key-hex>      -> prints "YES" if the value is in the local store, else "NO"
+//	closest <key-hex>  -> dry-run put: one "<addr> <id>" line per replica target
+//	keys               -> prints every locally stored key, one per line, sorted
+//	cache clear        -> drops cached (non-origin, unpinned) values, prints "cleared <n>"
+//	forget <key-hex>   -> drops the value and stops republishing it, prints "OK" or "NOTFOUND"
+//	pin|unpin <key>    -> exempts a key from eviction (or stops), prints "pinned <key>"
+//	info               -> prints "id <hex>", "addr <ip:port>", "contacts <n>", "keys <n>"
 //	exit               -> calls quit() and returns io.EOF
 //
 // On error, it prints a line containing "ERR" (or "NOTFOUND" for misses)
@@ -47,31 +62,46 @@ func (cli *CLI) RunLine(line string) error {
 	switch strings.ToLower(cmd) {
 	case "put":
 		content := strings.TrimSpace(arg)
+		verbose := false
+		if flag, rest := splitOnce(content); flag == "-v" {
+			verbose, content = true, strings.TrimSpace(rest)
+		}
 		if content == "" {
 			fmt.Fprintln(cli.out, "ERR missing argument")
 			return errors.New("put: missing argument")
 		}
-		keyHex, err := cli.k.Put([]byte(content))
+		res, err := cli.k.PutWithResult([]byte(content))
 		if err != nil {
 			fmt.Fprintf(cli.out, "ERR %v\n", err)
 			return err
 		}
 		// Print ONLY the key (tests expect a clean 40-hex line)
-		fmt.Fprintln(cli.out, keyHex)
+		fmt.Fprintln(cli.out, res.Key)
+		if verbose {
+			for _, c := range res.Targets {
+				fmt.Fprintf(cli.out, "replica %s %s\n", c.Address, c.ID.String())
+			}
+			fmt.Fprintf(cli.out, "state %s\n", res.State)
+		}
 		return nil
 
-	case "get":
+	case "get", "peek":
+		name := strings.ToLower(cmd)
 		keyHex := strings.TrimSpace(arg)
 		if keyHex == "" {
 			fmt.Fprintln(cli.out, "ERR missing argument")
-			return errors.New("get: missing argument")
+			return errors.New(name + ": missing argument")
 		}
-		// Basic validation: 20-byte hex (40 chars) and valid hex digits
-		if len(keyHex) != 40 || !isValidHex(keyHex) {
+		// Basic validation: 20-byte hex (40 chars, optionally scheme-prefixed)
+		if bare, err := canonicalKey(keyHex); err != nil || len(bare) != 40 || !isValidHex(bare) {
 			fmt.Fprintln(cli.out, "ERR invalid key")
-			return errors.New("get: invalid key")
+			return errors.New(name + ": invalid key")
 		}
-		val, from, err := cli.k.Get(keyHex)
+		get := cli.k.Get
+		if name == "peek" {
+			get = cli.k.GetNoCache // look, but don't become a replica
+		}
+		val, from, err := get(keyHex)
 		if err != nil || val == nil {
 			fmt.Fprintln(cli.out, "NOTFOUND")
 			if err == nil {
@@ -83,12 +113,89 @@ func (cli *CLI) RunLine(line string) error {
 		fmt.Fprintf(cli.out, "%s\nfrom %s\n", string(val), from.Address)
 		return nil
 
+	case "has":
+		keyHex := strings.TrimSpace(arg)
+		if _, err := parseKeyHex(keyHex); err != nil {
+			fmt.Fprintln(cli.out, "ERR invalid key")
+			return errors.New("has: invalid key")
+		}
+		if cli.k.HasLocal(keyHex) {
+			fmt.Fprintln(cli.out, "YES")
+		} else {
+			fmt.Fprintln(cli.out, "NO")
+		}
+		return nil
+
+	case "closest":
+		targets, err := cli.k.PlacementFor(strings.TrimSpace(arg))
+		if err != nil {
+			fmt.Fprintf(cli.out, "ERR %v\n", err)
+			return err
+		}
+		for _, c := range targets {
+			fmt.Fprintf(cli.out, "%s %s\n", c.Address, c.ID.String())
+		}
+		return nil
+
+	case "keys":
+		for _, k := range cli.k.LocalKeys() {
+			fmt.Fprintln(cli.out, k)
+		}
+		return nil
+
+	case "cache":
+		if sub := strings.ToLower(strings.TrimSpace(arg)); sub != "clear" {
+			fmt.Fprintln(cli.out, "ERR usage: cache clear")
+			return errors.New("cache: unknown subcommand")
+		}
+		fmt.Fprintf(cli.out, "cleared %d\n", cli.k.ClearCache())
+		return nil
+
+	case "forget":
+		keyHex := strings.TrimSpace(arg)
+		if _, err := parseKeyHex(keyHex); err != nil {
+			fmt.Fprintln(cli.out, "ERR invalid key")
+			return errors.New("forget: invalid key")
+		}
+		if !cli.k.Forget(keyHex) {
+			fmt.Fprintln(cli.out, "NOTFOUND")
+			return errors.New("not found")
+		}
+		fmt.Fprintln(cli.out, "OK")
+		return nil
+
+	case "pin", "unpin":
+		name := strings.ToLower(cmd)
+		keyHex := strings.TrimSpace(arg)
+		set := cli.k.Pin
+		if name == "unpin" {
+			set = cli.k.Unpin
+		}
+		if err := set(keyHex); err != nil {
+			fmt.Fprintf(cli.out, "ERR %v\n", err)
+			return err
+		}
+		fmt.Fprintf(cli.out, "%sned %s\n", name, keyHex)
+		return nil
+
+	case "rebalance":
+		fmt.Fprintf(cli.out, "repaired %d\n", cli.k.Rebalance())
+		return nil
+
+	case "info":
+		fmt.Fprintf(cli.out, "id %s\naddr %s\ncontacts %d\nkeys %d\n",
+			cli.k.me.ID.String(), cli.k.me.Address, cli.k.routingTable.Size(), cli.k.storedCount())
+		return nil
+
 	case "exit":
 		cli.quit()
 		return io.EOF
 
 	default:
-		fmt.Fprintln(cli.out, "ERR unknown command")
+		fmt.Fprintf(cli.out, "ERR unknown command (commands: %s)\n", strings.Join(cliCommands, ", "))
+		if s := suggestCommand(strings.ToLower(cmd)); s != "" {
+			fmt.Fprintf(cli.out, "did you mean %q?\n", s)
+		}
 		return errors.New("unknown command")
 	}
 }
@@ -98,13 +205,40 @@ func (cli *CLI) RunLine(line string) error {
 // Not required by tests, but useful for manual runs.
 // It ignores blank lines and prints minimal errors (consistent with RunLine).
 func (cli *CLI) Run() error {
-	sc := bufio.NewScanner(cli.in)
-	for sc.Scan() {
-		if err := cli.RunLine(sc.Text()); err == io.EOF {
-			return nil
+	return cli.RunContext(context.Background())
+}
+
+// RunContext is Run that also returns ctx.Err() once ctx is cancelled.
+// Lines are read on a separate goroutine so cancellation doesn't wait for
+// input; that goroutine exits at the next line or EOF. Commands still run
+// one at a time on the calling goroutine.
+func (cli *CLI) RunContext(ctx context.Context) error {
+	lines := make(chan string)
+	scanErr := make(chan error, 1)
+	go func() {
+		sc := bufio.NewScanner(cli.in)
+		for sc.Scan() {
+			select {
+			case lines <- sc.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		scanErr <- sc.Err()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-scanErr:
+			return err
+		case line := <-lines:
+			if err := cli.RunLine(line); err == io.EOF {
+				return nil
+			}
 		}
 	}
-	return sc.Err()
 }
 
 // --- helpers ---
@@ -135,6 +269,39 @@ func splitOnce(s string) (head, tail string) {
 	return s[:i], s[j:]
 }
 
+// suggestCommand returns the known command closest to cmd by edit distance,
+// or "" if nothing is within two edits (and closer than retyping it).
+func suggestCommand(cmd string) string {
+	best, bestDist := "", 3
+	for _, c := range cliCommands {
+		if d := editDistance(cmd, c); d < bestDist && d < len(c) {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
 func isValidHex(h string) bool {
 	_, err := hex.DecodeString(h)
 	return err == nil
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha1"
 	"encoding/hex"
@@ -8,9 +9,10 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
-	"time"
+	"syscall"
 
 	"d7024e/kademlia"
 )
@@ -65,9 +67,11 @@ func sha1Hex(s string) string {
 }
 
 func main() {
-	addr := flag.String("addr", "127.0.0.1:9001", "UDP listen address for this node, e.g. 127.0.0.1:9001")
-	bootstrap := flag.String("bootstrap", "", "optional bootstrap <host:port> to join")
+	addr := flag.String("addr", "127.0.0.1:9001", "UDP listen address for this node, e.g. 127.0.0.1:9001 or [::1]:9001")
+	bootstrap := flag.String("bootstrap", "", "optional bootstrap <host:port>[,<host:port>...] to join; tried in order")
 	idhex := flag.String("id", "", "optional 40-hex node ID (default: random)")
+	logLevel := flag.String("log", "quiet", "node diagnostics on stderr: quiet, info or debug")
+	client := flag.Bool("client", false, "run as a pure client: store nothing locally and stay out of peers' routing tables")
 	flag.Parse()
 
 	// --- Build our identity (Contact) ---
@@ -82,7 +86,11 @@ func main() {
 	} else {
 		id = randomID()
 	}
-	me := kademlia.NewContact(id, *addr) // Keep your Contact constructor/signature.
+	me, err := kademlia.NewContactValidated(id, *addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERR parsing -addr:", err)
+		os.Exit(2)
+	}
 
 	// --- Parse listen address into ip + port for constructors ---
 	ip, port, err := splitHostPort(*addr)
@@ -92,18 +100,38 @@ func main() {
 	}
 
 	// --- Bring up Kademlia (your NewKademlia binds ip:port and starts network) ---
-	k, err := kademlia.NewKademlia(me, ip, port)
+	levels := map[string]kademlia.LogLevel{"quiet": kademlia.LogQuiet, "info": kademlia.LogInfo, "debug": kademlia.LogDebug}
+	level, ok := levels[*logLevel]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "ERR: -log must be quiet, info or debug")
+		os.Exit(2)
+	}
+	k, err := kademlia.NewKademlia(me, ip, port,
+		kademlia.WithLogger(kademlia.NewLogger(os.Stderr, level)),
+		kademlia.WithClientMode(*client))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "ERR starting node:", err)
 		os.Exit(2)
 	}
 
-	// --- Optionally join a bootstrap peer ---
-	if s := strings.TrimSpace(*bootstrap); s != "" && s != *addr {
-		boot := kademlia.NewContact(randomID(), s) // ID will be learned on ping.
-		// Small delay helps on localhost to ensure sockets are up before join.
-		time.Sleep(150 * time.Millisecond)
-		if err := k.Join(&boot); err != nil {
+	// --- Optionally join via the first bootstrap peer that answers ---
+	var boots []*kademlia.Contact
+	for _, s := range strings.Split(*bootstrap, ",") {
+		if s = strings.TrimSpace(s); s == "" || s == *addr {
+			continue
+		}
+		boot, err := kademlia.NewContactValidated(randomID(), s) // placeholder ID until its PONG says who it is
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERR parsing -bootstrap:", err)
+			os.Exit(2)
+		}
+		boots = append(boots, &boot)
+	}
+	if len(boots) > 0 {
+		// NewKademlia returns once we are serving, and JoinAny retries the
+		// bootstraps with backoff while they come up, so no startup sleep
+		// is needed here.
+		if err := k.JoinAny(boots); err != nil {
 			fmt.Fprintln(os.Stderr, "WARN: join failed:", err)
 		}
 	}
@@ -116,10 +144,18 @@ func main() {
 	if *bootstrap != "" {
 		fmt.Printf("bootstrapped to %s\n", *bootstrap)
 	}
-	fmt.Println("commands: put <text> | get <40-hex-key> | exit")
+	fmt.Println("commands: put [-v] <text> | get <40-hex-key> | peek <40-hex-key> | has <40-hex-key> | closest <40-hex-key> | keys | cache clear | forget <40-hex-key> | pin|unpin <40-hex-key> | rebalance | info | exit")
 
-	if err := cli.Run(); err != nil && err.Error() != "EOF" {
+	// SIGINT/SIGTERM stop the REPL and shut the node down cleanly.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := cli.RunContext(ctx); err != nil && err != context.Canceled {
 		fmt.Fprintln(os.Stderr, "ERR:", err)
 	}
-	<-quit
+	// Stdin EOF leaves the node serving until "exit" or a signal.
+	select {
+	case <-quit:
+	case <-ctx.Done():
+	}
+	_ = k.Close()
 }
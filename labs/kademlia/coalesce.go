@@ -0,0 +1,89 @@
+package kademlia
+
+// coalesce.go: singleflight-style de-duplication of concurrent work.
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// flightGroup collapses concurrent calls that share a key into one execution.
+// Later callers block until the in-flight call finishes and reuse its result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done    chan struct{} // closed once val is set
+	val     any
+	waiters int                // callers still waiting for val
+	cancel  context.CancelFunc // stops fn once nobody waits
+}
+
+// do runs fn once per in-flight key. shared reports whether this caller
+// piggybacked on somebody else's execution instead of running fn itself.
+func (g *flightGroup) do(key string, fn func() any) (val any, shared bool) {
+	val, shared, _ = g.doCtx(context.Background(), key, func(context.Context) any { return fn() })
+	return val, shared
+}
+
+// doCtx is do for work that can be abandoned: fn runs under a context of its
+// own, which is cancelled once every caller waiting for it has had its ctx
+// done (each of those gets ctx.Err()). One caller giving up doesn't stop
+// the work for the others.
+func (g *flightGroup) doCtx(ctx context.Context, key string, fn func(context.Context) any) (val any, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	c, shared := g.calls[key]
+	if !shared {
+		runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &flightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		go func() {
+			c.val = fn(runCtx)
+			g.mu.Lock()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			cancel()
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, shared, nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		if c.waiters--; c.waiters == 0 {
+			c.cancel()
+			if g.calls[key] == c {
+				delete(g.calls, key) // later callers start afresh
+			}
+		}
+		g.mu.Unlock()
+		return nil, shared, ctx.Err()
+	}
+}
+
+// regionKey names the ID-space region made of all IDs that share the first
+// `bits` bits with id. Keys in the same region map to the same string.
+func regionKey(id *KademliaID, bits int) string {
+	if bits > IDLength*8 {
+		bits = IDLength * 8
+	}
+	var masked KademliaID
+	full := bits / 8
+	copy(masked[:full], id[:full])
+	if rem := bits % 8; rem != 0 {
+		masked[full] = id[full] & (0xff << (8 - rem))
+	}
+	return fmt.Sprintf("%d/%s", bits, masked.String())
+}
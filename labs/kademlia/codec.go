@@ -0,0 +1,228 @@
+package kademlia
+
+// codec.go: how envelopes are encoded on the wire (JSON or compact binary).
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+// Codec encodes envelopes for the wire. A node sends with the codec chosen
+// by WithCodec (JSONCodec by default) but reads either: binary datagrams
+// start with binaryMagic, which JSON never does, so mixed networks work.
+type Codec interface {
+	marshal(env envelope) ([]byte, error)
+	unmarshal(b []byte, env *envelope) error
+}
+
+// JSONCodec is the readable encoding, handy when watching traffic. Values
+// travel base64-encoded, a third larger than they are.
+type JSONCodec struct{}
+
+func (JSONCodec) marshal(env envelope) ([]byte, error)    { return json.Marshal(env) }
+func (JSONCodec) unmarshal(b []byte, env *envelope) error { return json.Unmarshal(b, env) }
+
+// BinaryCodec is a compact encoding: fields in a fixed order, integers as
+// varints, strings and bytes length-prefixed, and node IDs as their 20 raw
+// bytes instead of 40 hex characters.
+type BinaryCodec struct{}
+
+// binaryMagic opens every BinaryCodec datagram (JSON ones start with '{').
+const binaryMagic = 0xd7
+
+var errShortEnvelope = errors.New("truncated binary envelope")
+
+func (BinaryCodec) marshal(env envelope) ([]byte, error) {
+	b := []byte{binaryMagic}
+	b = binary.AppendUvarint(b, uint64(env.Version))
+	b = appendString(b, string(env.Type))
+	b = appendContact(b, env.From)
+	b = appendString(b, env.MsgID)
+	b = binary.AppendUvarint(b, env.Seq)
+	b = appendID(b, env.TargetID)
+	b = binary.AppendUvarint(b, uint64(len(env.Contacts)))
+	for _, c := range env.Contacts {
+		b = appendContact(b, c)
+	}
+	b = appendID(b, env.KeyHex)
+	b = appendBytes(b, env.Value)
+	b = binary.AppendUvarint(b, uint64(env.Caps))
+	found := byte(0)
+	if env.Found {
+		found = 1
+	}
+	b = append(b, found)
+	b = binary.AppendUvarint(b, uint64(env.Size))
+	b = appendBytes(b, env.Sig)
+	compressed := byte(0)
+	if env.Compressed {
+		compressed = 1
+	}
+	b = append(b, compressed)
+	b = appendString(b, env.Reason)
+	withContacts := byte(0)
+	if env.WithContacts {
+		withContacts = 1
+	}
+	b = append(b, withContacts)
+	keyed := byte(0)
+	if env.Keyed {
+		keyed = 1
+	}
+	b = append(b, keyed)
+	b = binary.AppendUvarint(b, uint64(env.Count))
+	appendSet := byte(0)
+	if env.Append {
+		appendSet = 1
+	}
+	b = append(b, appendSet)
+	b = binary.AppendUvarint(b, uint64(len(env.Values)))
+	for _, v := range env.Values {
+		b = appendBytes(b, v)
+	}
+	client := byte(0)
+	if env.Client {
+		client = 1
+	}
+	b = append(b, client)
+	return b, nil
+}
+
+func (BinaryCodec) unmarshal(b []byte, env *envelope) error {
+	if len(b) == 0 || b[0] != binaryMagic {
+		return errors.New("not a binary envelope")
+	}
+	r := &binReader{b: b[1:]}
+	*env = envelope{}
+	env.Version = int(r.uvarint())
+	env.Type = msgType(r.string())
+	env.From = r.contact()
+	env.MsgID = r.string()
+	env.Seq = r.uvarint()
+	env.TargetID = r.id()
+	if n := r.uvarint(); n > 0 && n <= uint64(len(r.b)) { // each contact takes >1 byte
+		env.Contacts = make([]wireContact, n)
+		for i := range env.Contacts {
+			env.Contacts[i] = r.contact()
+		}
+	} else if n > 0 {
+		r.err = errShortEnvelope
+	}
+	env.KeyHex = r.id()
+	env.Value = r.bytes()
+	env.Caps = Capabilities(r.uvarint())
+	env.Found = r.byte() == 1
+	env.Size = int(r.uvarint())
+	env.Sig = r.bytes()
+	env.Compressed = r.byte() == 1
+	env.Reason = r.string()
+	env.WithContacts = r.byte() == 1
+	env.Keyed = r.byte() == 1
+	env.Count = int(r.uvarint())
+	env.Append = r.byte() == 1
+	if n := r.uvarint(); n > 0 && n <= uint64(len(r.b)) { // each value takes >= 1 byte
+		env.Values = make([][]byte, n)
+		for i := range env.Values {
+			env.Values[i] = r.bytes()
+		}
+	} else if n > 0 {
+		r.err = errShortEnvelope
+	}
+	env.Client = r.byte() == 1
+	return r.err
+}
+
+// decodeEnvelope reads b with whichever codec produced it.
+func decodeEnvelope(b []byte, env *envelope) error {
+	if len(b) > 0 && b[0] == binaryMagic {
+		return BinaryCodec{}.unmarshal(b, env)
+	}
+	return JSONCodec{}.unmarshal(b, env)
+}
+
+func appendBytes(b, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendString(b []byte, s string) []byte { return appendBytes(b, []byte(s)) }
+
+// appendID writes a hex ID field: tag 1 and the raw bytes when it is a
+// canonical (lower-case) 40-hex ID, else tag 0 and the string as is, so
+// malformed IDs survive for the receiver to reject.
+func appendID(b []byte, idHex string) []byte {
+	if raw, err := hex.DecodeString(idHex); err == nil && len(raw) == IDLength && hex.EncodeToString(raw) == idHex {
+		return append(append(b, 1), raw...)
+	}
+	return appendString(append(b, 0), idHex)
+}
+
+func appendContact(b []byte, c wireContact) []byte {
+	b = appendID(b, c.IDHex)
+	b = appendString(b, c.Address)
+	return appendBytes(b, c.PubKey)
+}
+
+// binReader consumes a binary envelope, remembering the first error so
+// callers can decode every field and check once at the end.
+type binReader struct {
+	b   []byte
+	err error
+}
+
+func (r *binReader) take(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.b)) {
+		r.err = errShortEnvelope
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *binReader) byte() byte {
+	if v := r.take(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (r *binReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = errShortEnvelope
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+// bytes returns a copy (nil when empty, as JSON decodes an absent field).
+func (r *binReader) bytes() []byte {
+	v := r.take(r.uvarint())
+	if len(v) == 0 {
+		return nil
+	}
+	return append([]byte(nil), v...)
+}
+
+func (r *binReader) string() string { return string(r.take(r.uvarint())) }
+
+func (r *binReader) id() string {
+	if r.byte() == 1 {
+		return hex.EncodeToString(r.take(IDLength))
+	}
+	return r.string()
+}
+
+func (r *binReader) contact() wireContact {
+	return wireContact{IDHex: r.id(), Address: r.string(), PubKey: r.bytes()}
+}
@@ -0,0 +1,53 @@
+package kademlia
+
+// compress.go: optional gzip of large envelope values (see WithCompression).
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+)
+
+// maxInflated bounds what a compressed value may expand to, so a tiny
+// datagram can't make us allocate without limit.
+const maxInflated = 16 << 20
+
+// compressValue gzips env.Value in place when it is longer than threshold
+// (0 = never) and the result is actually smaller.
+func compressValue(env *envelope, threshold int) {
+	if threshold <= 0 || len(env.Value) <= threshold || env.Compressed {
+		return
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(env.Value); err != nil {
+		return
+	}
+	if err := zw.Close(); err != nil || buf.Len() >= len(env.Value) {
+		return
+	}
+	env.Value, env.Compressed = buf.Bytes(), true
+}
+
+// decompressValue restores a value compressValue packed; a no-op for
+// envelopes without the flag. Every node understands compressed envelopes,
+// whether or not it sends them.
+func decompressValue(env *envelope) error {
+	if !env.Compressed {
+		return nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(env.Value))
+	if err != nil {
+		return err
+	}
+	v, err := io.ReadAll(io.LimitReader(zr, maxInflated+1))
+	if err != nil {
+		return err
+	}
+	if len(v) > maxInflated {
+		return errors.New("compressed value too large")
+	}
+	env.Value, env.Compressed = v, false
+	return nil
+}
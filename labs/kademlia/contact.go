@@ -1,8 +1,12 @@
 package kademlia
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"sort"
+	"strconv"
+	"time"
 )
 
 // Contact definition
@@ -11,11 +15,34 @@ type Contact struct {
 	ID       *KademliaID
 	Address  string
 	distance *KademliaID
+	// When the routing table last added or heard from this contact; set on
+	// the copies it hands out (Dump, FindClosestContacts), zero elsewhere.
+	LastSeen time.Time
 }
 
 // NewContact returns a new instance of a Contact
 func NewContact(id *KademliaID, address string) Contact {
-	return Contact{id, address, nil}
+	return Contact{id, address, nil, time.Time{}}
+}
+
+// ErrInvalidAddress is returned for contact addresses that aren't host:port.
+var ErrInvalidAddress = errors.New("invalid address: want host:port")
+
+// NewContactValidated is NewContact that rejects a nil ID and an address
+// that isn't a non-empty host and a port in 1-65535, instead of leaving the
+// mistake to fail at the first send. Host names aren't resolved.
+func NewContactValidated(id *KademliaID, address string) (Contact, error) {
+	if id == nil {
+		return Contact{}, fmt.Errorf("contact %q: nil id", address)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return Contact{}, fmt.Errorf("%w: %q: %v", ErrInvalidAddress, address, err)
+	}
+	if n, err := strconv.Atoi(port); host == "" || err != nil || n < 1 || n > 65535 {
+		return Contact{}, fmt.Errorf("%w: %q", ErrInvalidAddress, address)
+	}
+	return NewContact(id, address), nil
 }
 
 // CalcDistance calculates the distance to the target and 
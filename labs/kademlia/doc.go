@@ -12,9 +12,16 @@
 //
 // M2: Object distribution (values)
 //   - Put(data): compute SHA-1 key; store locally immediately; replicate to the
-//     K closest nodes to the key (K = bucketSize). Transport uses STORE / STORE_OK.
+//     K closest nodes to the key (K = bucketSize; see SetReplicationFactor).
+//     Transport uses STORE / STORE_OK; a peer that refuses (value not matching
+//     the key, store full) answers STORE_ERR, and Put fails if nobody accepted.
+//   - PutAt(keyHex, data) / GetAt(keyHex): opt-in (WithKeyedRecords) records
+//     under a key the caller chose, kept apart from content keys; peers
+//     check each record against the key it names.
 //   - Get(keyHex): check local store; otherwise iterative FIND_VALUE with early
 //     exit on first value. On success, cache value locally.
+//   - PutAppend(keyHex, data) / GetAll(keyHex): opt-in (WithAppendKeys) keys
+//     that collect distinct values; GetAll merges the replicas' sets.
 //
 // M3: Minimal CLI (cmd/cli)
 //   - put <bytes>       -> prints content hash (40-hex SHA-1).
@@ -22,15 +29,37 @@
 //   - exit              -> terminates the node.
 //   - Flags:
 //     --addr       <ip:port>   (required)
-//     --bootstrap  <ip:port>   (optional; causes Join to that node)
+//     --bootstrap  <ip:port>[,<ip:port>...]  (optional; JoinAny: the first that answers)
+//     --client                 (optional; WithClientMode: hold no values)
 //
 // Repository layout (relevant bits)
 // ---------------------------------
 //
 //	kademlia/                 This package
 //	  kademlia.go             Node state + Join + LookupContact + Put/Get
-//	  network.go              UDP transport + PING/FIND_NODE/STORE/FIND_VALUE
+//	  options.go              Functional options for NewKademlia
+//	  coalesce.go             Singleflight helper for de-duplicating lookups
+//	  store.go                Local value store + eviction observer
+//	  chunk.go                Values over one datagram: chunks + manifest
+//	  append.go               Append keys: PutAppend/GetAll value sets
+//	  keyed.go                Keyed records: PutAt/GetAt
+//	  retention.go            Store capacity (LRU), value expiry, pinned keys
+//	  persist.go              Pluggable durable Store (MemoryStore included)
+//	  state.go                Contacts + values snapshot to a data directory
+//	  replication.go          Pluggable replica placement (K closest default)
+//	  ratelimit.go            Token bucket for outbound pacing
+//	  routingtrie.go          Optional prefix-tree routing table layout
+//	  latency.go              Per-RPC-type latency histograms
+//	  trace.go                Optional per-round lookup convergence trace
+//	  opstats.go              Per-call traffic accounting (Put/Get/LookupContact WithStats)
+//	  warmup.go               Post-join grace period for replica STOREs
+//	  refresh.go              Background refresh of stale buckets
+//	  network.go              Request/response + PING/FIND_NODE/STORE/FIND_VALUE
+//	  transport.go            Datagram Transport: UDP sockets or in-memory MemNet
 //	  wire.go                 On-wire message types & (un)marshaling
+//	  codec.go                Envelope encodings: JSON and compact binary
+//	  compress.go             Optional gzip of large values on the wire
+//	  sign.go                 Optional Ed25519-signed envelopes
 //	  bucket.go               LRU buckets
 //	  routingtable.go         Routing table, FindClosestContacts
 //	  kademliaid.go           ID type & XOR distance
@@ -66,7 +95,9 @@
 //	  get <that-40-hex-key>
 //	  # -> prints: value=<bytes> from=127.0.0.1:<port>
 //
-//	Type 'exit' in each terminal to quit.
+//	Type 'exit' in each terminal to quit. Nodes are silent by default; add
+//	--log info (refusals, drops, repairs) or --log debug (every message) to
+//	see what they do, on stderr.
 //
 // 3) One-shot, single test under the debugger (Delve):
 //
@@ -4,11 +4,18 @@ package kademlia
 // NOTE: variable names preserved: "routingTable" and "candidates".
 
 import (
-	"crypto/sha1"
+	"bytes"
+	"context"
+	"crypto/ed25519"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,189 +24,593 @@ type Kademlia struct {
 	routingTable *RoutingTable
 	network      *Network
 
-	alpha      int
+	alpha      atomic.Int32 // lookup parallelism; SetAlpha may change it between rounds
 	timeoutRPC time.Duration
 
 	// M2 local store
-	storeMu    sync.RWMutex
-	valueStore map[string][]byte // keyHex -> value
+	storeMu       sync.RWMutex
+	valueStore    map[string][]byte     // keyHex -> value
+	appendStore   map[string]*appendSet // keyHex -> append set (WithAppendKeys)
+	expires       map[string]time.Time  // keyHex -> deadline; absent = never (origin values)
+	provenance    map[string]Provenance // keyHex -> how we came to hold it (see ValueProvenance)
+	storeTTL      time.Duration         // default expiry for values stored for others (see WithStoreTTL)
+	onEvict       func(key string, reason EvictReason)
+	evictions     [3]atomic.Int64 // per EvictReason
+	persist       Store           // optional durable copy (see WithStore)
+	dataDir       string          // snapshot directory, loaded at start and saved on Close (see WithDataDir)
+	maxValues     int             // cap on stored values, 0 = unlimited (see WithStoreCapacity)
+	maxValueBytes atomic.Int64    // largest value Put or STORE accepts, 0 = unlimited (see SetMaxValueSize)
+	storageQuota  atomic.Int64    // cap on total value bytes, 0 = unlimited (see SetStorageQuota)
+	storedBytes   int64           // total bytes in valueStore, under storeMu
+	appendBytes   int64           // total bytes in appendStore, under storeMu
+	lruMu         sync.Mutex
+	retain        retention // LRU order and pins (retention.go)
 
 	// ---- M2.5+: maintenance ----
 	// Track keys we ORIGINATED via Put(); only those are periodically republished.
 	originMu   sync.RWMutex
 	originKeys map[string]struct{}
-	// Cooperative stop for the republisher goroutine.
+	// Origin keys placed with PutTo: the caller chose the replicas, so the
+	// republisher leaves them alone.
+	fixedPlacement map[string]struct{}
+	// Per origin key, the replica set the last republish round got every
+	// ack from, so a round finding the same set probes instead of STOREing.
+	republished map[string]republishRecord
+	// Cooperative stop for the republisher, expiry sweeper and bucket
+	// refresher (nil when none runs).
+	republishEnabled  bool
 	republishStop     chan struct{}
-	republishInterval time.Duration
+	republishInterval atomic.Int64  // a time.Duration; SetRepublishInterval may change it
+	republishKick     chan struct{} // wakes the republisher to pick up a new interval
+	republishJitter   float64       // each interval is stretched or shrunk by up to this fraction
+	sweepInterval     time.Duration // how often expired values are dropped
+	refreshInterval   time.Duration // how often stale buckets are looked for (0: never)
+	refreshStale      time.Duration // bucket idle time that triggers a refresh
+	closeOnce         sync.Once
+	stopOnce          sync.Once
+	background        sync.WaitGroup // republisher, sweeper and refresher
+
+	// Concurrent Puts whose keys share the first coalesceBits bits share one
+	// region lookup (0, the default, disables coalescing).
+	lookups      flightGroup
+	coalesceBits int
+	// Concurrent Gets for the same key share one lookup.
+	gets         flightGroup
+	valueLookups atomic.Int64 // iterative FIND_VALUE lookups started
+
+	// Join retries the self-lookup until the table holds joinMinPeers
+	// contacts, at most joinAttempts times, backing off from joinBackoff.
+	joinAttempts int
+	joinMinPeers int
+	joinBackoff  time.Duration
+	// Bootstraps remembered from Join and JoinAny, which the rejoin watchdog
+	// tries again once the table holds fewer than rejoinMinPeers contacts,
+	// checking every rejoinInterval (0: never) and backing off while it fails.
+	bootMu         sync.Mutex
+	bootstraps     []Contact
+	rejoinInterval time.Duration
+	rejoinMinPeers int
+
+	// When set, values must hash (SHA-1) to their key before we accept them.
+	verifyContent bool
+	// Hold and publish keyed records (PutAt; see WithKeyedRecords).
+	keyedRecords bool
+
+	// Get keeps fetched values locally and path-caches them (default on).
+	getCaching bool
+
+	// Chooses replica targets for Put and the republisher.
+	replication ReplicationStrategy
+
+	// Replication factor K handed to the strategy; bucketSize unless changed
+	// with SetReplicationFactor (atomic: the republisher reads it too).
+	replicationK atomic.Int32
+
+	// Node-wide cap on concurrent outbound FIND_NODE/FIND_VALUE/STORE RPCs,
+	// shared by every lookup (0 = unbounded; α still applies per lookup).
+	rpcBudget int
+
+	// Outbound message pacing (see WithOutboundRate); 0 = unlimited.
+	outboundRate  float64
+	outboundBurst int
+
+	// Extra "host:port" sockets served alongside me.Address (WithListenAddrs).
+	listenAddrs []string
+	// Opens each listen address (WithTransport); nil = UDP sockets.
+	transport func(addr string) (Transport, error)
+
+	// Wire encoding for outgoing envelopes (WithCodec); nil = JSON.
+	codec Codec
+	// Values longer than this are sent gzipped (WithCompression); 0 = never.
+	compressAbove int
+
+	// Sign outgoing envelopes and drop unsigned or forged incoming ones
+	// (WithSignedEnvelopes). signKey is nil while signing is off.
+	signEnvelopes bool
+	signKey       ed25519.PrivateKey
+	// me.ID is sha1(public key), and peers must prove the same (WithKeyDerivedID).
+	keyDerivedID bool
+
+	// Use the prefix-tree routing table instead of the 160-bucket array.
+	trieRouting bool
+
+	// Accept append STOREs and PutAppend (WithAppendKeys).
+	appendKeys bool
+
+	// Hold no values and stay out of peers' tables (WithClientMode).
+	clientMode bool
+
+	// Put returns right after the local store and replicates in the background.
+	asyncReplication bool
+
+	// Per-round lookup trace destination (WithLookupTrace); nil = off.
+	traceOut io.Writer
+	traceMu  sync.Mutex
+	onLookup atomic.Pointer[func(LookupStats)] // OnLookup hook; nil = none
+
+	// Diagnostics sink (SetLogger, WithLogger); unset = silent.
+	logger atomic.Pointer[logHolder]
+
+	// Return keys with the KeySchemeSHA1 prefix (see WithPrefixedKeys).
+	prefixedKeys bool
+
+	// Re-putting an unchanged value runs full replication instead of the
+	// probe-and-repair pass (see WithForceReplicate).
+	forceReplicate bool
+
+	// Join warmup (see WithJoinWarmup): replica STOREs are held in provisional
+	// until the table has warmupMinPeers contacts or warmupFor has passed.
+	warmupFor      time.Duration
+	warmupMinPeers int
+	warmupUntil    atomic.Int64 // unix nanos; 0 = not warming up
+	provMu         sync.Mutex
+	provisional    map[string]provisionalReplica
+	provBytes      int64 // sum of provisional value sizes
 }
 
 // NewKademlia creates a node bound to ip:port. Keep your Contact constructor.
-func NewKademlia(me Contact, ip string, port int) (*Kademlia, error) {
+// Options (see options.go) adjust the defaults below.
+func NewKademlia(me Contact, ip string, port int, opts ...Option) (*Kademlia, error) {
 	kademlia := &Kademlia{
-		me:            me,
-		alpha:         3,
-		timeoutRPC:    800 * time.Millisecond,
-		originKeys:    make(map[string]struct{}),
-		republishStop: make(chan struct{}),
-		// NOTE: Kademlia paper uses ~24h; for lab/demo you can shorten.
-		republishInterval: 15 * time.Minute,
+		me:               me,
+		timeoutRPC:       800 * time.Millisecond,
+		originKeys:       make(map[string]struct{}),
+		republishEnabled: true,
+		getCaching:       true,
+		storeTTL:         24 * time.Hour,
+		sweepInterval:    time.Minute,
+		refreshInterval:  10 * time.Minute,
+		refreshStale:     time.Hour,
+		joinAttempts:     4,
+		joinMinPeers:     1,
+		joinBackoff:      50 * time.Millisecond,
+		rejoinInterval:   30 * time.Second,
+		rejoinMinPeers:   1,
+		replication:      KClosest{},
+	}
+	kademlia.alpha.Store(3)
+	// NOTE: Kademlia paper uses ~24h; for lab/demo you can shorten.
+	kademlia.republishInterval.Store(int64(15 * time.Minute))
+	kademlia.republishJitter = 0.1
+	kademlia.replicationK.Store(bucketSize)
+	kademlia.maxValueBytes.Store(defaultMaxValueBytes)
+	for _, opt := range opts {
+		opt(kademlia)
+	}
+	if kademlia.signEnvelopes && kademlia.signKey == nil {
+		_, key, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return nil, fmt.Errorf("signing key: %w", err)
+		}
+		kademlia.signKey = key
+	}
+	if kademlia.keyDerivedID {
+		id := keyID(kademlia.signKey.Public().(ed25519.PublicKey))
+		kademlia.me = NewContact(&id, me.Address)
+	}
+	if kademlia.trieRouting {
+		kademlia.routingTable = NewTrieRoutingTable(kademlia.me)
+	} else {
+		kademlia.routingTable = NewRoutingTable(kademlia.me)
+	}
+
+	if err := kademlia.restore(); err != nil {
+		return nil, err
 	}
-	kademlia.routingTable = NewRoutingTable(me)
 
 	netw, err := NewNetwork(kademlia, ip, port)
 	if err != nil {
 		return nil, err
 	}
-	// Start background republisher AFTER network is ready.
-	go kademlia.republisher()
 	kademlia.network = netw
+	// Start background republisher AFTER network is ready.
+	if kademlia.republishEnabled || kademlia.storeTTL > 0 || kademlia.refreshInterval > 0 || kademlia.rejoinInterval > 0 {
+		kademlia.republishStop = make(chan struct{})
+	}
+	if kademlia.republishEnabled {
+		kademlia.republishKick = make(chan struct{}, 1)
+		kademlia.background.Add(1)
+		go kademlia.republisher()
+	}
+	if kademlia.storeTTL > 0 {
+		kademlia.background.Add(1)
+		go kademlia.sweeper()
+	}
+	if kademlia.refreshInterval > 0 {
+		kademlia.background.Add(1)
+		go kademlia.bucketRefresher()
+	}
+	if kademlia.rejoinInterval > 0 {
+		kademlia.background.Add(1)
+		go kademlia.rejoinWatchdog()
+	}
 	// Wire LRU-eviction liveness probe: ping with the same timeout used elsewhere.
 	kademlia.routingTable.SetPingFunc(func(c Contact) bool {
 		return kademlia.network.PingWait(&c, kademlia.timeoutRPC)
 	})
+	if kademlia.dataDir != "" {
+		if err := kademlia.LoadState(kademlia.dataDir); err != nil {
+			// Not Close: that would save over the snapshot we couldn't read.
+			kademlia.signalStop()
+			netw.Close()
+			return nil, err
+		}
+	}
 	return kademlia, nil
 }
 
+// Close stops background work and the network. Safe to call more than once.
 func (kademlia *Kademlia) Close() error {
-	// Stop the republisher loop.
-	if kademlia.republishStop != nil {
-		close(kademlia.republishStop)
+	var err error
+	kademlia.closeOnce.Do(func() {
+		// Signal only: waiting here would stall Close for up to an RPC timeout
+		// whenever a republish is mid-flight.
+		kademlia.signalStop()
+		if kademlia.dataDir != "" {
+			err = kademlia.SaveState(kademlia.dataDir)
+		}
+		if kademlia.network != nil {
+			err = errors.Join(err, kademlia.network.Close())
+		}
+	})
+	return err
+}
+
+// Leave hands the values this node stores off to the K closest other nodes,
+// then closes it. Keys it originated are always handed off, since nobody
+// else republishes them; with handOffCached so are the ones it holds for
+// others (CachedKeys), which would otherwise be one replica short until their
+// origin republishes. Returns how many keys at least one peer acknowledged,
+// and Close's error.
+func (kademlia *Kademlia) Leave(handOffCached bool) (int, error) {
+	kademlia.originMu.RLock()
+	keys := make([]string, 0, len(kademlia.originKeys))
+	for k := range kademlia.originKeys {
+		keys = append(keys, k)
 	}
-	if kademlia.network != nil {
-		return kademlia.network.Close()
+	kademlia.originMu.RUnlock()
+	if handOffCached {
+		keys = append(keys, kademlia.CachedKeys()...)
 	}
-	return nil
+
+	var handedOff atomic.Int64
+	sem := make(chan struct{}, kademlia.alpha.Load())
+	var wg sync.WaitGroup
+	for _, keyHex := range keys {
+		v, ok := kademlia.loadLocal(keyHex)
+		keyID, err := parseKeyHex(keyHex)
+		if !ok || err != nil {
+			continue // expired or evicted meanwhile
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if _, acked, _ := kademlia.replicateToClosest(context.Background(), keyHex, keyID, v, nil); acked > 0 {
+				handedOff.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	kademlia.infof("[LEAVE] handed off %d of %d key(s)", handedOff.Load(), len(keys))
+	return int(handedOff.Load()), kademlia.Close()
+}
+
+// Ping PINGs contact and returns the responder's real contact from its PONG;
+// contact may carry a placeholder ID. See Network.Ping.
+func (kademlia *Kademlia) Ping(contact *Contact) (Contact, error) {
+	return kademlia.network.Ping(contact)
 }
 
 // Join the network via a known bootstrap node.
-// 1) PING the bootstrap
+// 1) PING the bootstrap; once it answers, seed the table from it (FIND_NODE for maxFindNodeCount)
 // 2) Iterative lookup for our own ID to populate routing table
+// Step 2 is retried with jittered backoff until the table reaches joinMinPeers,
+// so callers don't need to sleep while the bootstrap comes up. Returns an
+// error if we still know nobody once the attempts are used up. The bootstrap
+// is remembered for the rejoin watchdog (see WithRejoin).
 func (kademlia *Kademlia) Join(bootstrap *Contact) error {
 	if bootstrap == nil || bootstrap.ID == nil || bootstrap.Address == "" {
 		return fmt.Errorf("invalid bootstrap")
 	}
-	kademlia.network.SendPingMessage(bootstrap)
-
-	// Then the canonical join step: lookup our own ID
+	kademlia.rememberBootstraps(*bootstrap)
+	kademlia.beginWarmup()
 	self := Contact{ID: kademlia.me.ID}
-	kademlia.LookupContact(&self)
+	backoff := kademlia.joinBackoff
+	for attempt := 0; attempt < kademlia.joinAttempts; attempt++ {
+		if attempt > 0 {
+			joinPause(backoff)
+			backoff *= 2
+		}
+		// (Re-)PING while the table is empty: the bootstrap may not be reading yet.
+		if kademlia.routingTable.Size() == 0 {
+			kademlia.network.SendPingMessage(bootstrap)
+			if kademlia.routingTable.Size() > 0 {
+				// It answered: take a wide slice of its table to seed ours,
+				// rather than just the bucketSize the lookup will ask for.
+				_, _ = kademlia.network.sendFindNodeCountCtx(context.Background(), bootstrap, &self, maxFindNodeCount, nil)
+			}
+		}
+		// Then the canonical join step: lookup our own ID
+		kademlia.LookupContact(&self)
+		if kademlia.routingTable.Size() >= kademlia.joinMinPeers {
+			return nil
+		}
+	}
+	if kademlia.routingTable.Size() == 0 {
+		return fmt.Errorf("join: no peers learned via bootstrap %s", bootstrap.Address)
+	}
 	return nil
 }
 
-// LookupContact performs an iterative node lookup for target.ID.
-// It updates routingTable; get results via routingTable.FindClosestContacts(target.ID, n).
-func (kademlia *Kademlia) LookupContact(target *Contact) {
+// joinPause sleeps somewhere in [backoff/2, 3*backoff/2) so joiners don't
+// sync up.
+func joinPause(backoff time.Duration) {
+	time.Sleep(backoff/2 + time.Duration(rand.Int63n(int64(backoff)+1)))
+}
+
+// JoinAny joins via the first of bootstraps that answers a PING, trying them
+// in order, so one dead bootstrap doesn't keep the node out. Like Join, it
+// goes round the list up to joinAttempts times with jittered backoff, so a
+// bootstrap still starting up is waited for. Bootstrap IDs may be
+// placeholders: the PONG says who the node really is. Returns an error
+// naming every bootstrap tried if none answers. All of them are remembered
+// for the rejoin watchdog, the dead ones included.
+func (kademlia *Kademlia) JoinAny(bootstraps []*Contact) error {
+	var boots []*Contact
+	for _, b := range bootstraps {
+		if b == nil || b.Address == "" {
+			continue
+		}
+		kademlia.rememberBootstraps(*b)
+		boots = append(boots, b)
+	}
+	if len(boots) == 0 {
+		return fmt.Errorf("invalid bootstrap")
+	}
+	var errs []error
+	backoff := kademlia.joinBackoff
+	for attempt := 0; attempt < kademlia.joinAttempts; attempt++ {
+		if attempt > 0 {
+			joinPause(backoff)
+			backoff *= 2
+		}
+		errs = errs[:0]
+		for _, b := range boots {
+			real, err := kademlia.Ping(b)
+			if err != nil {
+				kademlia.infof("[JOIN] bootstrap %s (attempt %d): %v", b.Address, attempt+1, err)
+				errs = append(errs, fmt.Errorf("%s: %w", b.Address, err))
+				continue
+			}
+			return kademlia.Join(&real)
+		}
+	}
+	return fmt.Errorf("join: no bootstrap answered in %d attempts: %w", kademlia.joinAttempts, errors.Join(errs...))
+}
+
+// LookupContact performs an iterative node lookup for target.ID, updating
+// routingTable as it goes, and returns the (up to K) peers it converged on:
+// the closest of those that answered, nearest first, one entry per address.
+// Reading the table afterwards instead can pick up contacts added by other
+// traffic in the meantime.
+func (kademlia *Kademlia) LookupContact(target *Contact) []Contact {
 	if target == nil || target.ID == nil {
-		return
+		return nil
 	}
-	// Initial seed
-	candidates := kademlia.routingTable.FindClosestContacts(target.ID, bucketSize*3)
+	return closestTo(kademlia.lookupContact(context.Background(), target, 0, nil), target.ID, bucketSize)
+}
+
+// LookupContactWithStats is LookupContact, also reporting its traffic.
+func (kademlia *Kademlia) LookupContactWithStats(target *Contact) OpStats {
+	op := &opAccount{}
+	kademlia.lookupContact(context.Background(), target, 0, op)
+	return op.stats()
+}
+
+// LookupClosest is LookupContact that only finishes once the K closest live
+// nodes it knows of have all answered, so the result is the true K closest
+// rather than whoever the lookup happened to ask before converging.
+func (kademlia *Kademlia) LookupClosest(target *KademliaID) []Contact {
+	return closestTo(kademlia.lookupContact(context.Background(), &Contact{ID: target}, bucketSize, nil), target, bucketSize)
+}
 
+// lookupContact is the iterative FIND_NODE lookup. It stops once the best
+// live contact stops improving; with k > 0 it then also queries the k
+// closest live contacts it hasn't asked yet, until there are none. Returns
+// every peer that answered, in no particular order.
+func (kademlia *Kademlia) lookupContact(ctx context.Context, target *Contact, k int, op *opAccount) []Contact {
+	if target == nil || target.ID == nil {
+		return nil
+	}
 	visited := make(map[string]struct{})
+	// Peers that failed to answer during this lookup; they no longer take
+	// candidate slots or count as the "best" contact.
+	dead := make(map[string]struct{})
+	isDead := func(c Contact) bool { _, d := dead[c.Address]; return d }
+	skip := func(c Contact) bool {
+		_, seen := visited[c.Address]
+		return seen || c.Address == "" || isDead(c)
+	}
 
-	// Select next α unvisited peers closest to target
+	// Select next α unvisited, live peers closest to target
 	nextBatch := func() []Contact {
-		candidates = kademlia.routingTable.FindClosestContacts(target.ID, 1024)
-		batch := make([]Contact, 0, kademlia.alpha)
+		candidates := kademlia.routingTable.FindClosestContactsExcluding(target.ID, int(kademlia.alpha.Load()), skip)
 		for _, contact := range candidates {
-			if len(batch) >= kademlia.alpha {
-				break
-			}
-			if contact.Address == "" {
-				continue
-			}
-			if _, seen := visited[contact.Address]; seen {
-				continue
-			}
 			visited[contact.Address] = struct{}{}
-			batch = append(batch, contact)
 		}
-		return batch
+		return candidates
 	}
 
-	var lastBest *KademliaID
-
-	for {
-		batch := nextBatch()
-		if len(batch) == 0 {
-			break
+	var responded []Contact
+	query := func(batch []Contact) {
+		type result struct {
+			peer Contact
+			err  error
 		}
-
-		type result struct{}
 		results := make(chan result, len(batch))
 
 		for i := range batch {
 			peer := batch[i]
 			go func() {
 				// Ask "peer" for contacts close to "target"
-				_, _ = kademlia.network.SendFindContactMessageTo(&peer, target)
-				results <- result{}
+				_, err := kademlia.network.sendFindNodeToCtx(ctx, &peer, target, op)
+				results <- result{peer: peer, err: err}
 			}()
 		}
 
 		for i := 0; i < len(batch); i++ {
-			<-results
+			if r := <-results; r.err != nil {
+				dead[r.peer.Address] = struct{}{}
+			} else {
+				responded = append(responded, r.peer)
+			}
 		}
+	}
 
-		// Convergence check: if best known contact didn't improve, stop
-		closestNow := kademlia.routingTable.FindClosestContacts(target.ID, 1)
+	var lastBest *KademliaID
+	trace := kademlia.newTrace("lookup", target.ID)
+	defer trace.done()
+
+	for ctx.Err() == nil {
+		batch := nextBatch()
+		if len(batch) == 0 {
+			trace.stop(stopEmptyBatch)
+			break
+		}
+		trace.batch(batch)
+		query(batch)
+
+		// Convergence check: if best known live contact didn't improve, stop
+		closestNow := kademlia.routingTable.FindClosestContactsExcluding(target.ID, 1, isDead)
 		if len(closestNow) == 0 {
+			trace.stop(stopNoLive)
 			break
 		}
 		best := closestNow[0].ID
+		trace.best(lastBest, best)
 		if lastBest != nil && !best.CalcDistance(target.ID).Less(lastBest.CalcDistance(target.ID)) {
+			trace.stop(stopConverged)
 			break
 		}
 		lastBest = best
 	}
 
-	// Optional: stable ordering for determinism in tests/demos
-	final := kademlia.routingTable.FindClosestContacts(target.ID, bucketSize)
-	sort.SliceStable(final, func(i, j int) bool {
-		return final[i].ID.CalcDistance(target.ID).Less(final[j].ID.CalcDistance(target.ID))
+	// Confirm the k closest: ask whichever of them we haven't heard from,
+	// α at a time, until every one has answered or failed.
+	for k > 0 && ctx.Err() == nil {
+		var batch []Contact
+		alpha := int(kademlia.alpha.Load())
+		for _, c := range kademlia.routingTable.FindClosestContactsExcluding(target.ID, k, skip) {
+			if len(batch) == alpha {
+				break
+			}
+			visited[c.Address] = struct{}{}
+			batch = append(batch, c)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		trace.confirm(batch)
+		query(batch)
+	}
+	return responded
+}
+
+// closestTo returns the (at most) k contacts nearest to target, nearest first.
+func closestTo(contacts []Contact, target *KademliaID, k int) []Contact {
+	sorted := append([]Contact(nil), contacts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ID.CalcDistance(target).Less(sorted[j].ID.CalcDistance(target))
 	})
+	if len(sorted) > k {
+		sorted = sorted[:k]
+	}
+	return sorted
 }
 
-// ClosestContacts returns up to 'count' closest contacts to 'target' from this node's view.
-func (kademlia *Kademlia) ClosestContacts(target *KademliaID, count int) []Contact {
-	return kademlia.routingTable.FindClosestContacts(target, count)
+// signalStop tells the maintenance goroutines to exit without waiting.
+func (kademlia *Kademlia) signalStop() {
+	kademlia.stopOnce.Do(func() {
+		if kademlia.republishStop != nil {
+			close(kademlia.republishStop)
+		}
+	})
 }
 
-// ---- M2: local store helpers ----
+// Ready is closed once the node is listening and answering on every socket.
+// NewKademlia only returns after that point, so Join may follow immediately;
+// the channel is for code that's handed a node and wants to be sure.
+func (kademlia *Kademlia) Ready() <-chan struct{} {
+	return kademlia.network.ready
+}
 
-func (kademlia *Kademlia) keyFromData(data []byte) (keyHex string, id *KademliaID) {
-	sum := sha1.Sum(data) // 20 bytes
-	keyHex = hex.EncodeToString(sum[:])
-	var kid KademliaID
-	copy(kid[:], sum[:])
-	return keyHex, &kid
+// stopBackground stops the maintenance goroutines and waits for them to exit,
+// leaving the socket open so in-flight replies can still be answered.
+func (kademlia *Kademlia) stopBackground() {
+	kademlia.signalStop()
+	kademlia.background.Wait()
 }
 
-func (kademlia *Kademlia) storeLocal(keyHex string, value []byte) {
-	kademlia.storeMu.Lock()
-	if kademlia.valueStore == nil { // lazy init to avoid nil map panics
-		kademlia.valueStore = make(map[string][]byte)
-	}
-	v := make([]byte, len(value)) // copy to avoid aliasing
-	copy(v, value)
-	kademlia.valueStore[keyHex] = v
-	kademlia.storeMu.Unlock()
+// ForgetPeers empties the routing table, as if the node had just started.
+// Stored values and origin keys are untouched; Join again to reconnect.
+func (kademlia *Kademlia) ForgetPeers() {
+	kademlia.routingTable.Reset()
 }
 
-func (kademlia *Kademlia) loadLocal(keyHex string) ([]byte, bool) {
-	kademlia.storeMu.RLock()
-	if kademlia.valueStore == nil { // handle nil map safely
-		kademlia.storeMu.RUnlock()
-		return nil, false
+// SetAlpha sets α, how many peers each lookup round (LookupContact, Get,
+// ProbeKey) queries in parallel. Lookups already running pick it up at their
+// next round. n must be at least 1.
+func (kademlia *Kademlia) SetAlpha(n int) error {
+	if n < 1 {
+		return fmt.Errorf("alpha %d must be at least 1", n)
 	}
-	v, ok := kademlia.valueStore[keyHex]
-	kademlia.storeMu.RUnlock()
-	if !ok {
-		return nil, false
+	kademlia.alpha.Store(int32(n))
+	return nil
+}
+
+// SetReplicationFactor sets K, the number of peers Put, the republisher and
+// Rebalance place a value on, independently of the routing bucket size.
+// k must be between 1 and bucketSize.
+func (kademlia *Kademlia) SetReplicationFactor(k int) error {
+	if k < 1 || k > bucketSize {
+		return fmt.Errorf("replication factor %d out of range [1, %d]", k, bucketSize)
 	}
-	out := make([]byte, len(v)) // return a copy
-	copy(out, v)
-	return out, true
+	kademlia.replicationK.Store(int32(k))
+	return nil
+}
+
+// SetMaxValueSize sets the largest value, in bytes, that Put accepts and
+// that the node stores for others; bigger STOREs are refused with STORE_ERR.
+// Defaults to 1 MiB; n <= 0 removes the limit.
+func (kademlia *Kademlia) SetMaxValueSize(n int) {
+	kademlia.maxValueBytes.Store(int64(max(n, 0)))
+}
+
+// ClosestContacts returns up to 'count' closest contacts to 'target' from this node's view.
+func (kademlia *Kademlia) ClosestContacts(target *KademliaID, count int) []Contact {
+	return kademlia.routingTable.FindClosestContacts(target, count)
 }
 
 // ---- M2: public API ----
@@ -209,13 +620,132 @@ func (kademlia *Kademlia) Store(data []byte) {
 	_, _ = kademlia.Put(data) // delegate to returning variant; ignore key here
 }
 
+// ReplicationState says what a Put guarantees about remote copies at the
+// moment it returns.
+type ReplicationState int
+
+const (
+	// LocalOnly: only this node is known to hold the value (no peers, or no
+	// peer acknowledged the STORE).
+	LocalOnly ReplicationState = iota
+	// Pending: replication runs in the background (WithAsyncReplication);
+	// remote copies may not exist yet.
+	Pending
+	// Complete: replication finished and at least one peer acknowledged.
+	Complete
+)
+
+func (s ReplicationState) String() string {
+	switch s {
+	case LocalOnly:
+		return "local_only"
+	case Pending:
+		return "pending"
+	case Complete:
+		return "complete"
+	default:
+		return "unknown"
+	}
+}
+
+// PutResult describes the outcome of a Put.
+type PutResult struct {
+	Key     string           // hex SHA-1 of the data (1114-prefixed under WithPrefixedKeys)
+	Targets []Contact        // K closest peers the value was sent to (excludes self); nil while Pending
+	Acked   int              // how many Targets acknowledged the STORE (or already held the value)
+	Refused int              // how many Targets answered STORE_ERR
+	State   ReplicationState // what was guaranteed when Put returned
+}
+
 // Put returns the key (hex) and any error; use this in tests/CLI.
 func (kademlia *Kademlia) Put(data []byte) (string, error) {
+	res, err := kademlia.PutWithResult(data)
+	return res.Key, err
+}
+
+// PutContext is Put that stops waiting on the network once ctx is done and
+// then returns ctx.Err(). The value is stored locally and republished as
+// usual either way; only the initial replication may be cut short.
+func (kademlia *Kademlia) PutContext(ctx context.Context, data []byte) (string, error) {
+	res, err := kademlia.put(ctx, data, nil)
+	return res.Key, err
+}
+
+// PutWithResult is Put, but also reports where the value was replicated and
+// how many of those peers acknowledged it.
+func (kademlia *Kademlia) PutWithResult(data []byte) (PutResult, error) {
+	return kademlia.put(context.Background(), data, nil)
+}
+
+// PutWithStats is PutWithResult, also reporting the traffic of the lookup
+// and STOREs. With WithAsyncReplication the stats only cover what happened
+// before Put returned (nothing, usually). A Put that rides on a concurrent
+// Put's coalesced region lookup isn't charged for that lookup.
+func (kademlia *Kademlia) PutWithStats(data []byte) (PutResult, OpStats, error) {
+	op := &opAccount{}
+	res, err := kademlia.put(context.Background(), data, op)
+	return res, op.stats(), err
+}
+
+func (kademlia *Kademlia) put(ctx context.Context, data []byte, op *opAccount) (PutResult, error) {
+	if kademlia.valueTooLarge(len(data)) {
+		return PutResult{}, fmt.Errorf("%w: %d bytes, limit %d", ErrValueTooLarge, len(data), kademlia.maxValueBytes.Load())
+	}
+	if len(data) > chunkSize {
+		return kademlia.putChunked(ctx, data, op) // too big for one datagram
+	}
 	keyHex, keyID := kademlia.keyFromData(data)
+	return kademlia.putValue(ctx, keyHex, keyID, data, op)
+}
 
+// putValue stores data under keyHex locally, marks it ours and replicates it.
+func (kademlia *Kademlia) putValue(ctx context.Context, keyHex string, keyID *KademliaID, data []byte, op *opAccount) (PutResult, error) {
+	replicate := kademlia.stageValue(keyHex, keyID, data)
+
+	// Initial placement to CURRENT K-closest (lookup embeds table refresh).
+	if kademlia.asyncReplication {
+		if kademlia.routingTable.Size() == 0 {
+			return PutResult{Key: kademlia.displayKey(keyHex), State: LocalOnly}, nil
+		}
+		value := append([]byte(nil), data...) // caller may reuse data after we return
+		// Background replication outlives the caller, and so its ctx.
+		go replicate(context.Background(), keyHex, keyID, value, nil)
+		return PutResult{Key: kademlia.displayKey(keyHex), State: Pending}, nil
+	}
+	return kademlia.replicateResult(ctx, keyHex, replicate, keyID, data, op)
+}
+
+// replicateFunc places a value on its replicas: replicateToClosest, or a
+// repair pass for a value they should already hold.
+type replicateFunc func(ctx context.Context, keyHex string, keyID *KademliaID, value []byte, op *opAccount) (targets []Contact, acked int, refusals []error)
+
+// stageValue is the local half of putValue: it stores data under keyHex,
+// marks the key ours, and returns how to replicate it.
+func (kademlia *Kademlia) stageValue(keyHex string, keyID *KademliaID, data []byte) replicateFunc {
+	// Re-putting bytes we already hold changes nothing, so rather than a full
+	// replication round only targets missing the key get a STORE.
+	replicate := replicateFunc(kademlia.replicateToClosest)
+	if prev, ok := kademlia.loadLocal(keyHex); ok && bytes.Equal(prev, data) && !kademlia.forceReplicate {
+		kademlia.debugf("[PUT] key=%s unchanged, checking replicas only", keyHex)
+		replicate = func(ctx context.Context, k string, id *KademliaID, v []byte, op *opAccount) ([]Contact, int, []error) {
+			targets, held, _ := kademlia.repairPlacement(ctx, k, id, v, op)
+			return targets, held, nil
+		}
+	}
+
+	if kademlia.clientMode {
+		// Nothing kept, so nothing to republish: the replicas are all there is.
+		kademlia.debugf("[PUT] key=%s client mode, replicating only", keyHex)
+		return replicate
+	}
 	// Always store at the origin immediately.
-	kademlia.storeLocal(keyHex, data)
-	fmt.Printf("[PUT] key=%s me=%s stored_local\n", keyHex, kademlia.me.Address)
+	kademlia.storeLocalFrom(keyHex, data, 0, Provenance{Source: Published})
+	kademlia.debugf("[PUT] key=%s me=%s stored_local", keyHex, kademlia.me.Address)
+	if keyID.Equals(kademlia.me.ID) {
+		// We are trivially the closest node; placement below already skips us
+		// and replicates to our nearest neighbours.
+		kademlia.debugf("[PUT] key=%s equals our own ID", keyHex)
+	}
 	// Find K closest nodes to the key (iterative lookup).
 	//target := Contact{ID: keyID}
 	//kademlia.LookupContact(&target)
@@ -236,30 +766,205 @@ func (kademlia *Kademlia) Put(data []byte) (string, error) {
 	// Mark as an origin key so the republisher will maintain it over time.
 	kademlia.originMu.Lock()
 	kademlia.originKeys[keyHex] = struct{}{}
+	delete(kademlia.fixedPlacement, keyHex)
+	delete(kademlia.republished, keyHex) // the value may have changed (PutAt)
 	kademlia.originMu.Unlock()
+	kademlia.persistOrigin(keyHex)
+	return replicate
+}
 
-	// Initial placement to CURRENT K-closest (lookup embeds table refresh).
-	kademlia.replicateToClosest(keyHex, keyID, data)
+// replicateResult runs replicate and reports the outcome as Put does.
+func (kademlia *Kademlia) replicateResult(ctx context.Context, keyHex string, replicate replicateFunc, keyID *KademliaID, data []byte, op *opAccount) (PutResult, error) {
+	targets, acked, refusals := replicate(ctx, keyHex, keyID, data, op)
+	res := PutResult{Key: kademlia.displayKey(keyHex), Targets: targets, Acked: acked, Refused: len(refusals), State: LocalOnly}
+	if err := ctx.Err(); err != nil {
+		// Stored locally and ours; replicas may be partial until republish.
+		return res, err
+	}
+	if acked > 0 {
+		res.State = Complete
+	} else if len(refusals) > 0 {
+		// Nobody took it, and not for lack of trying: say who refused and why.
+		return res, errors.Join(refusals...)
+	} else if kademlia.clientMode {
+		return res, errors.New("client mode: no peer stored the value")
+	}
+	return res, nil
+}
+
+// PutAsync stores data locally and returns its key at once, like Put with
+// WithAsyncReplication, but also reports how the background replication
+// went: done receives the error Put would have returned (nil on success)
+// and is then closed. A value over chunkSize is split, stored and
+// replicated entirely in the background.
+func (kademlia *Kademlia) PutAsync(data []byte) (string, <-chan error) {
+	done := make(chan error, 1)
+	if kademlia.valueTooLarge(len(data)) {
+		done <- fmt.Errorf("%w: %d bytes, limit %d", ErrValueTooLarge, len(data), kademlia.maxValueBytes.Load())
+		close(done)
+		return "", done
+	}
+	value := append([]byte(nil), data...) // caller may reuse data after we return
+	keyHex, keyID := kademlia.keyFromData(value)
+	work := func() error {
+		_, err := kademlia.putChunked(context.Background(), value, nil)
+		return err
+	}
+	if len(value) <= chunkSize {
+		replicate := kademlia.stageValue(keyHex, keyID, value)
+		work = func() error {
+			_, err := kademlia.replicateResult(context.Background(), keyHex, replicate, keyID, value, nil)
+			return err
+		}
+	}
+	go func() {
+		done <- work()
+		close(done)
+	}()
+	return kademlia.displayKey(keyHex), done
+}
 
-	return keyHex, nil
+// PutTo stores data locally and replicates it to exactly targets, skipping
+// the lookup and the replication strategy. Meant for tests and experiments
+// that need placement independent of topology timing. The key is still
+// sha1(data); the value counts as ours (origin short-circuit, ClearCache
+// keeps it) but the republisher never moves it to the K closest.
+func (kademlia *Kademlia) PutTo(data []byte, targets []Contact) (string, error) {
+	keyHex, _ := kademlia.keyFromData(data)
+	kademlia.storeLocalFrom(keyHex, data, 0, Provenance{Source: Published})
+
+	kademlia.originMu.Lock()
+	kademlia.originKeys[keyHex] = struct{}{}
+	if kademlia.fixedPlacement == nil {
+		kademlia.fixedPlacement = make(map[string]struct{})
+	}
+	kademlia.fixedPlacement[keyHex] = struct{}{}
+	kademlia.originMu.Unlock()
+	kademlia.persistOrigin(keyHex)
+
+	var failed []string
+	for _, c := range targets {
+		if c.Address == kademlia.me.Address {
+			continue
+		}
+		if err := kademlia.network.sendStoreTo(&c, keyHex, data, kademlia.timeoutRPC, nil); err != nil {
+			failed = append(failed, c.Address)
+		}
+	}
+	if len(failed) > 0 {
+		return kademlia.displayKey(keyHex), fmt.Errorf("put-to: no STORE_OK from %s", strings.Join(failed, ", "))
+	}
+	return kademlia.displayKey(keyHex), nil
 }
 
-// LookupData per skeleton (no return). Wrapper over Get.
+// LookupData per skeleton (no return). Wrapper over LookupDataResult that
+// logs the outcome instead of dropping it.
 func (kademlia *Kademlia) LookupData(hash string) {
-	_, _, _ = kademlia.Get(hash)
+	val, from, err := kademlia.LookupDataResult(hash)
+	if err != nil {
+		kademlia.infof("[LOOKUP_DATA] key=%s err=%v", hash, err)
+		return
+	}
+	kademlia.infof("[LOOKUP_DATA] key=%s found len=%d from=%s", hash, len(val), from.Address)
+}
+
+// LookupDataResult is the skeleton's LookupData with results: the value, the
+// contact that served it, and an error if the key is malformed or not found.
+func (kademlia *Kademlia) LookupDataResult(hash string) (value []byte, from *Contact, err error) {
+	return kademlia.Get(hash)
 }
 
 // Get performs FIND_VALUE iterative lookup.
 // Returns the value (if found), and the contact that returned it.
 func (kademlia *Kademlia) Get(keyHex string) ([]byte, *Contact, error) {
+	return kademlia.get(context.Background(), keyHex, kademlia.getCaching, nil)
+}
 
-	fmt.Printf("[GET] key=%s me=%s\n", keyHex, kademlia.me.Address)
+// GetContext is Get that gives up with ctx.Err() as soon as ctx is done,
+// instead of waiting for outstanding RPCs to time out.
+func (kademlia *Kademlia) GetContext(ctx context.Context, keyHex string) ([]byte, *Contact, error) {
+	return kademlia.get(ctx, keyHex, kademlia.getCaching, nil)
+}
 
-	// quick local check
-	if v, ok := kademlia.loadLocal(keyHex); ok {
-		me := kademlia.me
-		fmt.Printf("[GET] local_hit=%v\n", ok)
-		return v, &me, nil
+// GetWithStats is Get, also reporting the traffic of the lookup (and of the
+// path-caching STORE, if any). A local hit costs nothing, and neither does a
+// Get that shares a concurrent Get's lookup for the same key.
+func (kademlia *Kademlia) GetWithStats(keyHex string) ([]byte, *Contact, OpStats, error) {
+	op := &opAccount{}
+	val, from, err := kademlia.get(context.Background(), keyHex, kademlia.getCaching, op)
+	return val, from, op.stats(), err
+}
+
+// GetNoCache is Get that never caches: the value is neither stored locally
+// nor path-cached, whatever WithGetCaching says. For inspecting data from a
+// node that shouldn't become a replica of it.
+func (kademlia *Kademlia) GetNoCache(keyHex string) ([]byte, *Contact, error) {
+	return kademlia.get(context.Background(), keyHex, false, nil)
+}
+
+// GetVerbose is Get that also returns every peer the lookup queried, once
+// each, with what it answered; on failure the hops show where the lookup
+// went. Peers of the last round still unanswered when the value arrived are
+// listed with Err context.Canceled. A local hit has no hops. It runs its own
+// lookup rather than sharing a concurrent Get's, and for a chunked value only
+// the manifest's lookup is listed.
+func (kademlia *Kademlia) GetVerbose(keyHex string) ([]byte, *Contact, []GetHop, error) {
+	keyHex, err := canonicalKey(keyHex)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var hops hopList
+	val, from, err := kademlia.getOnce(context.Background(), keyHex, kademlia.getCaching, nil, &hops)
+	return val, from, hops, err
+}
+
+// get looks keyHex up and, if what it finds is a chunk manifest, fetches
+// the chunks and returns the reassembled value. Concurrent calls for the
+// same key (and caching mode) share one execution, which keeps running while
+// any of them still waits and is cancelled once all of them have given up.
+func (kademlia *Kademlia) get(ctx context.Context, keyHex string, cache bool, op *opAccount) ([]byte, *Contact, error) {
+	keyHex, err := canonicalKey(keyHex)
+	if err != nil {
+		return nil, nil, err
+	}
+	type result struct {
+		val  []byte
+		from *Contact
+		err  error
+	}
+	v, shared, err := kademlia.gets.doCtx(ctx, fmt.Sprintf("%s/%t", keyHex, cache), func(ctx context.Context) any {
+		val, from, err := kademlia.getOnce(ctx, keyHex, cache, op, nil)
+		return result{val, from, err}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	r := v.(result)
+	if shared {
+		r.val = append([]byte(nil), r.val...) // every caller gets its own copy
+	}
+	return r.val, r.from, r.err
+}
+
+// getOnce is one execution of get for a canonical key.
+func (kademlia *Kademlia) getOnce(ctx context.Context, keyHex string, cache bool, op *opAccount, hops *hopList) ([]byte, *Contact, error) {
+	val, from, err := kademlia.getValue(ctx, keyHex, cache, op, hops)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m, ok := decodeManifest(val); ok {
+		return kademlia.assemble(ctx, keyHex, m, from, cache, op)
+	}
+	return val, from, nil
+}
+
+// getValue is the single-value lookup behind get: local copy first, then an
+// iterative FIND_VALUE. keyHex is already canonical. Each peer's answer is
+// appended to hops unless it is nil.
+func (kademlia *Kademlia) getValue(ctx context.Context, keyHex string, cache bool, op *opAccount, hops *hopList) ([]byte, *Contact, error) {
+	kademlia.debugf("[GET] key=%s me=%s", keyHex, kademlia.me.Address)
+	if v, me, ok := kademlia.localHit(keyHex); ok {
+		return v, me, nil
 	}
 
 	// Treat key as an ID for distance/candidate selection
@@ -272,46 +977,41 @@ func (kademlia *Kademlia) Get(keyHex string) ([]byte, *Contact, error) {
 	}
 	var keyID KademliaID
 	copy(keyID[:], b)
+	kademlia.valueLookups.Add(1)
 
-	// Seed candidates
-	candidates := kademlia.routingTable.FindClosestContacts(&keyID, bucketSize*3)
 	visited := make(map[string]struct{})
+	dead := make(map[string]struct{}) // peers that failed during this lookup
+	isDead := func(c Contact) bool { _, d := dead[c.Address]; return d }
+	skip := func(c Contact) bool {
+		_, seen := visited[c.Address]
+		return seen || c.Address == "" || isDead(c)
+	}
 	// Track which peers we actually queried (for path caching later).
 	queried := make([]Contact, 0, 64)
 	nextBatch := func() []Contact {
-		// refresh view from table each round
-		candidates = kademlia.routingTable.FindClosestContacts(&keyID, 1024)
-		batch := make([]Contact, 0, kademlia.alpha)
+		// refresh view from table each round, fresh candidates only
+		candidates := kademlia.routingTable.FindClosestContactsExcluding(&keyID, int(kademlia.alpha.Load()), skip)
 		for _, contact := range candidates {
-			if len(batch) >= kademlia.alpha {
-				break
-			}
-			if contact.Address == "" {
-				continue
-			}
-			if _, seen := visited[contact.Address]; seen {
-				continue
-			}
 			visited[contact.Address] = struct{}{}
-			batch = append(batch, contact)
 		}
-		return batch
+		return candidates
 	}
 
 	var lastBest *KademliaID
+	trace := kademlia.newTrace("get", &keyID)
+	defer trace.done()
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err // cancelled by the caller
+		}
 
 		batch := nextBatch()
 		if len(batch) == 0 {
+			trace.stop(stopEmptyBatch)
 			break
 		}
-
-		fmt.Printf("[GET] batch size=%d candidates querying:\n", len(batch))
-		for _, c := range batch {
-			d := c.ID.CalcDistance(&keyID)
-			fmt.Printf("  -> %s dist=%s\n", c.Address, d.String())
-		}
+		trace.batch(batch)
 
 		// Record which nodes we're querying this round (for path caching).
 		queried = append(queried, batch...)
@@ -322,15 +1022,20 @@ func (kademlia *Kademlia) Get(keyHex string) ([]byte, *Contact, error) {
 			from     *Contact
 			err      error
 		}
+		// ch has room for every reply and batchCtx is cancelled once we stop
+		// listening, so stragglers behind a hit finish at once and never block.
 		ch := make(chan res, len(batch))
+		batchCtx, cancelBatch := context.WithCancel(ctx)
 
 		for i := range batch {
 			peer := batch[i]
 			go func(p Contact) {
-				val, cons, e := kademlia.network.sendFindValueTo(&p, keyHex, kademlia.timeoutRPC)
+				// A hit also brings the responder's closest contacts when we
+				// will path-cache: candidates for where to put the copy.
+				val, cons, e := kademlia.network.sendFindValueToCtx(batchCtx, &p, keyHex, cache, kademlia.timeoutRPC, op)
 				if e == nil && len(val) > 0 {
 					// Early success
-					ch <- res{value: val, from: &p}
+					ch <- res{value: val, contacts: cons, from: &p}
 					return
 				}
 				ch <- res{contacts: cons, from: &p, err: e}
@@ -340,24 +1045,61 @@ func (kademlia *Kademlia) Get(keyHex string) ([]byte, *Contact, error) {
 		gotValue := false
 		var val []byte
 		var src *Contact
+		var near []Contact // the source's closest contacts to the key
 
-		for i := 0; i < len(batch); i++ {
+		// The first acceptable value ends the round: slower peers in the
+		// batch aren't waited for.
+		answered := make(map[string]bool, len(batch))
+		for i := 0; i < len(batch) && !gotValue; i++ {
 			r := <-ch
+			answered[r.from.Address] = true
+			if r.err != nil {
+				dead[r.from.Address] = struct{}{}
+			}
+			hop := GetHop{Peer: *r.from, Contacts: len(r.contacts), Err: r.err}
 			if r.err == nil && len(r.value) > 0 {
+				if !kademlia.acceptValue(keyHex, r.value) {
+					// Corrupt/malicious replica: ignore it and keep looking.
+					kademlia.infof("[GET] REJECT value from=%s: content does not match key", r.from.Address)
+					hop.Rejected = true
+					hops.add(hop)
+					continue
+				}
+				hop.HadValue = true
 				val = r.value
 				src = r.from
+				near = r.contacts
 				gotValue = true
 			}
+			hops.add(hop)
 			// network.sendFindValueTo already learned contacts into the table
 		}
+		cancelBatch()
+		for _, p := range batch {
+			if hops != nil && !answered[p.Address] {
+				hops.add(GetHop{Peer: p, Err: context.Canceled}) // cut off by the hit
+			}
+		}
 		if gotValue {
-			// cache locally
-			kademlia.storeLocal(keyHex, val)
+			trace.stop(stopFound)
+			kademlia.debugf("[GET] GOT value from=%s len=%d", src.Address, len(val))
+			if !cache || !contentMatchesKey(keyHex, val) {
+				// Pure consumer: hand the value back without keeping or seeding it.
+				// Same for a value that doesn't hash to its key: peers would
+				// refuse it, and we shouldn't serve it on. A manifest is kept
+				// by assemble once what it lists checks out.
+				return val, src, nil
+			}
+			// cache locally (a cached copy expires like a replica)
+			kademlia.storeLocalFrom(keyHex, val, kademlia.storeTTL, Provenance{Source: Fetched, Peer: *src})
 
 			// -------- PATH CACHING --------
 			// Also STORE the value at the *closest* node that we actually contacted
-			// (excluding the source that had the value and ourselves).
-			// This helps seed the correct region even if the publisher hasn't republished yet.
+			// or that the source named as closest to the key (excluding the source
+			// that had the value and ourselves). This helps seed the correct region
+			// even if the publisher hasn't republished yet, and still works when
+			// the source is the only node we asked.
+			queried = append(queried, near...)
 			bestIdx := -1
 			for i := range queried {
 				q := queried[i]
@@ -376,110 +1118,723 @@ func (kademlia *Kademlia) Get(keyHex string) ([]byte, *Contact, error) {
 					bestIdx = i
 				}
 			}
+			// In tiny networks the source may be the only peer anyone knows.
 			if bestIdx >= 0 {
-				_ = kademlia.network.sendStoreTo(&queried[bestIdx], keyHex, val, kademlia.timeoutRPC)
+				_ = kademlia.network.sendStoreToCtx(ctx, &queried[bestIdx], keyHex, val, kademlia.timeoutRPC, op)
+				kademlia.debugf("[GET] PATH-CACHE store to %s", queried[bestIdx].Address)
 			}
 
-			fmt.Printf("[GET] GOT value from=%s len=%d\n", src.Address, len(val))
-			fmt.Printf("[GET] PATH-CACHE store to %s\n", queried[bestIdx].Address)
-
 			return val, src, nil
 		}
 
-		// Convergence: stop when best contact doesn't improve
-		closestNow := kademlia.routingTable.FindClosestContacts(&keyID, 1)
+		// Convergence: stop when best live contact doesn't improve
+		closestNow := kademlia.routingTable.FindClosestContactsExcluding(&keyID, 1, isDead)
 		if len(closestNow) == 0 {
+			trace.stop(stopNoLive)
 			break
 		}
 		best := closestNow[0].ID
+		trace.best(lastBest, best)
 		if lastBest != nil && !best.CalcDistance(&keyID).Less(lastBest.CalcDistance(&keyID)) {
+			trace.stop(stopConverged)
 			break
 		}
 		lastBest = best
 	}
 
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err // the RPCs above were cut short, not answered
+	}
 	return nil, nil, fmt.Errorf("not found")
 }
 
-// replicateToClosest finds the CURRENT K closest nodes to keyID and sends STORE.
+// localHit returns our own copy of keyHex, if we hold an acceptable one,
+// with ourselves as the source.
+func (kademlia *Kademlia) localHit(keyHex string) ([]byte, *Contact, bool) {
+	// Origin short-circuit: our own Put data never needs the network.
+	if v, ok := kademlia.originLocal(keyHex); ok {
+		me := kademlia.me
+		kademlia.debugf("[GET] origin_hit=true")
+		return v, &me, true
+	}
+
+	// quick local check
+	if v, ok := kademlia.loadLocal(keyHex); ok && kademlia.acceptValue(keyHex, v) {
+		me := kademlia.me
+		kademlia.debugf("[GET] local_hit=%v", ok)
+		return v, &me, true
+	}
+	return nil, nil, false
+}
+
+// GetFast is a best-effort Get that skips the iterative lookup: it sends
+// FIND_VALUE to all K closest peers in the routing table at once and returns
+// the first acceptable value, cancelling the rest. A value held only by
+// peers we don't know yet is reported as not found; fall back to Get for
+// that. Caching follows WithGetCaching, without path caching. Chunks of a
+// large value are still fetched with the normal lookup.
+func (kademlia *Kademlia) GetFast(keyHex string) ([]byte, *Contact, error) {
+	keyHex, err := canonicalKey(keyHex)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx := context.Background()
+	val, from, err := kademlia.getValueFast(ctx, keyHex, kademlia.getCaching)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m, ok := decodeManifest(val); ok {
+		return kademlia.assemble(ctx, keyHex, m, from, kademlia.getCaching, nil)
+	}
+	return val, from, nil
+}
+
+// getValueFast is getValue with a single round as wide as K.
+func (kademlia *Kademlia) getValueFast(ctx context.Context, keyHex string, cache bool) ([]byte, *Contact, error) {
+	kademlia.debugf("[GET] fast key=%s me=%s", keyHex, kademlia.me.Address)
+	if v, me, ok := kademlia.localHit(keyHex); ok {
+		return v, me, nil
+	}
+	keyID, err := parseKeyHex(keyHex)
+	if err != nil {
+		return nil, nil, err
+	}
+	peers := kademlia.routingTable.FindClosestContacts(keyID, int(kademlia.replicationK.Load()))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // the first value wins; stop waiting on everyone else
+	type res struct {
+		value []byte
+		from  *Contact
+	}
+	ch := make(chan res, len(peers))
+	for i := range peers {
+		go func(p Contact) {
+			val, _, e := kademlia.network.sendFindValueToCtx(ctx, &p, keyHex, false, kademlia.timeoutRPC, nil)
+			if e != nil {
+				val = nil
+			}
+			ch <- res{value: val, from: &p}
+		}(peers[i])
+	}
+	for range peers {
+		r := <-ch
+		if len(r.value) == 0 {
+			continue
+		}
+		if !kademlia.acceptValue(keyHex, r.value) {
+			kademlia.infof("[GET] REJECT value from=%s: content does not match key", r.from.Address)
+			continue
+		}
+		kademlia.debugf("[GET] fast GOT value from=%s len=%d", r.from.Address, len(r.value))
+		if cache && contentMatchesKey(keyHex, r.value) {
+			kademlia.storeLocalFrom(keyHex, r.value, kademlia.storeTTL, Provenance{Source: Fetched, Peer: *r.from})
+		}
+		return r.value, r.from, nil
+	}
+	return nil, nil, fmt.Errorf("not found")
+}
+
+// InflightCount is the number of budgeted RPCs (FIND_NODE, FIND_VALUE[_META],
+// STORE) currently awaiting a reply across all of this node's lookups.
+func (kademlia *Kademlia) InflightCount() int {
+	return int(kademlia.network.active.Load())
+}
+
+// RoutingSnapshot returns a copy of the routing table's contacts, one slice
+// per bucket index (see RoutingTable.Dump). Safe to call while the node runs.
+func (kademlia *Kademlia) RoutingSnapshot() [][]Contact {
+	return kademlia.routingTable.Dump()
+}
+
+// PeerCapabilities returns the capabilities a peer advertised in its last
+// PONG to us; ok is false if we've never had a PONG from it.
+func (kademlia *Kademlia) PeerCapabilities(id *KademliaID) (caps Capabilities, ok bool) {
+	if id == nil {
+		return 0, false
+	}
+	return kademlia.network.peerCapabilities(id)
+}
+
+// LastRTT returns how long the last answered PING to addr (a bucket liveness
+// probe, LoadState's re-check, Ping) took from send to PONG; ok is false if
+// we never had one.
+func (kademlia *Kademlia) LastRTT(addr string) (rtt time.Duration, ok bool) {
+	return kademlia.network.lastRTT(addr)
+}
+
+// LateResponses counts replies that arrived with no one waiting but answer
+// one of our recently completed requests (by MsgID): duplicates or replies
+// after the caller timed out.
+func (kademlia *Kademlia) LateResponses() int64 { return kademlia.network.late.Load() }
+
+// UnexpectedResponses counts replies matching no request we recently sent.
+func (kademlia *Kademlia) UnexpectedResponses() int64 { return kademlia.network.unexpected.Load() }
+
+// VersionDrops counts incoming messages ignored for an unknown protocol version.
+func (kademlia *Kademlia) VersionDrops() int64 { return kademlia.network.badVersion.Load() }
+
+// SignatureDrops counts incoming messages ignored for a missing or invalid
+// sender signature (only checked with WithSignedEnvelopes).
+func (kademlia *Kademlia) SignatureDrops() int64 { return kademlia.network.badSig.Load() }
+
+// RPCStats returns the node's counters of RPCs sent, received and timed out.
+func (kademlia *Kademlia) RPCStats() RPCStats { return kademlia.network.Stats() }
+
+// OutboundThrottled is how many sends the outbound rate limiter has delayed.
+func (kademlia *Kademlia) OutboundThrottled() int64 {
+	return kademlia.network.throttled.Load()
+}
+
+// ProbeKey reports whether keyHex is stored somewhere and how large the value
+// is, using FIND_VALUE_META so the bytes themselves are never transferred.
+// A key nobody holds is (false, 0, nil, nil); err is for malformed keys.
+func (kademlia *Kademlia) ProbeKey(keyHex string) (exists bool, size int, from *Contact, err error) {
+	if keyHex, err = canonicalKey(keyHex); err != nil {
+		return false, 0, nil, err
+	}
+	if v, ok := kademlia.loadLocal(keyHex); ok {
+		me := kademlia.me
+		return true, len(v), &me, nil
+	}
+	if len(keyHex) != 40 {
+		return false, 0, nil, fmt.Errorf("invalid key hex length")
+	}
+	b, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	var keyID KademliaID
+	copy(keyID[:], b)
+
+	visited := make(map[string]struct{})
+	dead := make(map[string]struct{})
+	isDead := func(c Contact) bool { _, d := dead[c.Address]; return d }
+	skip := func(c Contact) bool {
+		_, seen := visited[c.Address]
+		return seen || c.Address == "" || isDead(c)
+	}
+	var lastBest *KademliaID
+	for {
+		batch := kademlia.routingTable.FindClosestContactsExcluding(&keyID, int(kademlia.alpha.Load()), skip)
+		for _, c := range batch {
+			visited[c.Address] = struct{}{}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		type res struct {
+			found bool
+			size  int
+			from  Contact
+			err   error
+		}
+		ch := make(chan res, len(batch))
+		for _, peer := range batch {
+			go func(p Contact) {
+				found, n, _, e := kademlia.network.sendFindMetaTo(&p, keyHex, kademlia.timeoutRPC, nil)
+				ch <- res{found: e == nil && found, size: n, from: p, err: e}
+			}(peer)
+		}
+		var hit *res
+		for range batch {
+			r := <-ch
+			if r.err != nil {
+				dead[r.from.Address] = struct{}{}
+			}
+			if r.found && hit == nil {
+				hit = &r
+			}
+		}
+		if hit != nil {
+			kademlia.debugf("[PROBE] key=%s found at %s size=%d", keyHex, hit.from.Address, hit.size)
+			return true, hit.size, &hit.from, nil
+		}
+
+		// sendFindMetaTo learned the returned contacts; stop once the best
+		// known live contact stops improving.
+		closestNow := kademlia.routingTable.FindClosestContactsExcluding(&keyID, 1, isDead)
+		if len(closestNow) == 0 {
+			break
+		}
+		best := closestNow[0].ID
+		if lastBest != nil && !best.CalcDistance(&keyID).Less(lastBest.CalcDistance(&keyID)) {
+			break
+		}
+		lastBest = best
+	}
+	return false, 0, nil, nil
+}
+
+// replicateToClosest refreshes the region around keyID, asks the replication
+// strategy (K closest by default) for targets, and sends them STORE.
 // Shared by Put() (initial placement) and the periodic republisher.
-func (kademlia *Kademlia) replicateToClosest(keyHex string, keyID *KademliaID, value []byte) {
+// Returns the peers a STORE was sent to, how many acknowledged it, and the
+// StoreRejectedErrors of those that refused it.
+func (kademlia *Kademlia) replicateToClosest(ctx context.Context, keyHex string, keyID *KademliaID, value []byte, op *opAccount) (targets []Contact, acked int, refusals []error) {
 	// High-level trace so you can correlate init vs republish calls.
-	fmt.Printf("[REPLICATE] key=%s me=%s start\n", keyHex, kademlia.me.Address)
+	kademlia.debugf("[REPLICATE] key=%s me=%s start", keyHex, kademlia.me.Address)
 	if keyID == nil || len(keyHex) != 40 || len(value) == 0 {
-		return
+		return nil, 0, nil
 	}
-	// Refresh view of the network around this key to avoid stale placement.
-	target := Contact{ID: keyID}
-	kademlia.LookupContact(&target)
+	return kademlia.storeToTargets(ctx, keyHex, keyID, value, kademlia.placement(ctx, keyID, op), op)
+}
 
-	contacts := kademlia.routingTable.FindClosestContacts(keyID, bucketSize)
-	sort.SliceStable(contacts, func(i, j int) bool {
-		return contacts[i].ID.CalcDistance(keyID).Less(contacts[j].ID.CalcDistance(keyID))
-	})
+// storeToTargets is the STORE half of replicateToClosest, for contacts the
+// caller already placed.
+func (kademlia *Kademlia) storeToTargets(ctx context.Context, keyHex string, keyID *KademliaID, value []byte, contacts []Contact, op *opAccount) (targets []Contact, acked int, refusals []error) {
 	// Optional: show the first few candidates + their XOR distance to the key.
 	for i, c := range contacts {
 		if i >= 8 {
 			break
 		}
-		fmt.Printf("[REPLICATE] candidate[%d]=%s dist=%s\n",
+		kademlia.debugf("[REPLICATE] candidate[%d]=%s dist=%s",
 			i, c.Address, c.ID.CalcDistance(keyID).String())
 	}
+	targets = make([]Contact, 0, len(contacts))
 	for _, c := range contacts {
-		if c.Address == kademlia.me.Address {
-			continue // we already stored locally
-		}
 		// <-- this is the print you asked about, now in the right place
-		fmt.Printf("[REPLICATE] -> %s (closest to key)\n", c.Address)
-		// Fire-and-forget semantics are OK; we tolerate timeouts.
-		_ = kademlia.network.sendStoreTo(&c, keyHex, value, kademlia.timeoutRPC)
+		kademlia.debugf("[REPLICATE] -> %s (closest to key)", c.Address)
+		// We tolerate timeouts; they just don't count as an ack.
+		err := kademlia.network.sendStoreToCtx(ctx, &c, keyHex, value, kademlia.timeoutRPC, op)
+		if err == nil {
+			acked++
+		} else if errors.Is(err, ErrStoreRejected) {
+			refusals = append(refusals, err)
+		}
+		targets = append(targets, c)
 	}
+	return targets, acked, refusals
+}
+
+// placement refreshes the region around keyID and returns the replica targets
+// the strategy picks, minus ourselves (the origin stores locally). The
+// default strategy takes the K closest nodes the lookup heard from rather
+// than the table, which may still hold closer-looking contacts that are gone.
+func (kademlia *Kademlia) placement(ctx context.Context, keyID *KademliaID, op *opAccount) []Contact {
+	k := int(kademlia.replicationK.Load())
+	// Refresh view of the network around this key to avoid stale placement.
+	confirmed := kademlia.lookupRegion(ctx, keyID, k, op)
+
+	var contacts []Contact
+	if _, byDistance := kademlia.replication.(KClosest); byDistance {
+		contacts = closestTo(confirmed, keyID, k)
+	} else {
+		contacts = kademlia.replication.Targets(keyID, kademlia.routingTable, k)
+	}
+	out := contacts[:0:0]
+	for _, c := range contacts {
+		// By ID too: a key equal to our ID puts us at distance 0, and our
+		// address may be one of several (WithListenAddrs).
+		if c.Address == kademlia.me.Address || c.ID.Equals(kademlia.me.ID) {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// IsResponsibleFor reports whether this node is among the K nodes closest to
+// keyHex, judged from its own routing table. While fewer than K other nodes
+// are known, every node is responsible: the whole network is the replica set.
+func (kademlia *Kademlia) IsResponsibleFor(keyHex string) (bool, error) {
+	keyID, err := parseKeyHex(keyHex)
+	if err != nil {
+		return false, err
+	}
+	k := int(kademlia.replicationK.Load())
+	closest := kademlia.routingTable.FindClosestContacts(keyID, k)
+	if len(closest) < k {
+		return true, nil
+	}
+	farthest := closest[len(closest)-1].ID.CalcDistance(keyID)
+	return kademlia.me.ID.CalcDistance(keyID).Less(farthest), nil
+}
+
+// MeasureReplication looks up the region around keyHex and probes the K
+// closest nodes it confirmed concurrently with FIND_VALUE_META (so no value
+// bytes move), returning how many of them hold the value right now and who
+// they are. This node ranks alongside them and counts if it is among the K
+// and holds the value, so the count never exceeds K whoever asks. Peers that
+// time out count as not holding it.
+func (kademlia *Kademlia) MeasureReplication(keyHex string) (count int, peers []Contact, err error) {
+	if keyHex, err = canonicalKey(keyHex); err != nil {
+		return 0, nil, err
+	}
+	keyID, err := parseKeyHex(keyHex)
+	if err != nil {
+		return 0, nil, err
+	}
+	k := int(kademlia.replicationK.Load())
+	ranked := []Contact{kademlia.me}
+	for _, c := range kademlia.lookupRegion(context.Background(), keyID, k, nil) {
+		if c.Address != kademlia.me.Address && !c.ID.Equals(kademlia.me.ID) {
+			ranked = append(ranked, c)
+		}
+	}
+	closest := closestTo(ranked, keyID, k)
+
+	type res struct {
+		peer Contact
+		has  bool
+	}
+	ch := make(chan res, len(closest))
+	for _, c := range closest {
+		go func(p Contact) {
+			if p.ID.Equals(kademlia.me.ID) {
+				_, ok := kademlia.loadLocal(keyHex)
+				ch <- res{peer: p, has: ok}
+				return
+			}
+			found, _, _, e := kademlia.network.sendFindMetaTo(&p, keyHex, kademlia.timeoutRPC, nil)
+			ch <- res{peer: p, has: e == nil && found}
+		}(c)
+	}
+	for range closest {
+		if r := <-ch; r.has {
+			peers = append(peers, r.peer)
+		}
+	}
+	sort.SliceStable(peers, func(i, j int) bool {
+		return peers[i].ID.CalcDistance(keyID).Less(peers[j].ID.CalcDistance(keyID))
+	})
+	return len(peers), peers, nil
+}
+
+// PlacementFor is a dry-run Put: it runs the same region lookup and replica
+// selection as replicateToClosest and returns the targets, but sends no STORE.
+func (kademlia *Kademlia) PlacementFor(keyHex string) ([]Contact, error) {
+	keyID, err := parseKeyHex(keyHex)
+	if err != nil {
+		return nil, err
+	}
+	return kademlia.placement(context.Background(), keyID, nil), nil
+}
+
+// regionLookup is what a coalesced lookupRegion shares: whose lookup it was,
+// and the peers that answered.
+type regionLookup struct {
+	key       KademliaID
+	k         int
+	op        *opAccount
+	confirmed []Contact
+}
+
+// lookupRegion refreshes the routing table around keyID and returns the peers
+// that answered (see lookupContact for k). With coalescing on, concurrent
+// callers whose keys fall in the same prefix region ride along on a single
+// lookup instead of each sending their own FIND_NODE wave. Only a caller with
+// the same key, k and op as the one that ran it gets that lookup's peers as
+// they are; the others rank them against their own key and top them up (see
+// rideRegion). The shared lookup keeps going while any caller still waits
+// and is cancelled once all of them have given up.
+func (kademlia *Kademlia) lookupRegion(ctx context.Context, keyID *KademliaID, k int, op *opAccount) []Contact {
+	target := Contact{ID: keyID}
+	if kademlia.coalesceBits <= 0 {
+		return kademlia.lookupContact(ctx, &target, k, op)
+	}
+	v, _, err := kademlia.lookups.doCtx(ctx, regionKey(keyID, kademlia.coalesceBits), func(ctx context.Context) any {
+		return regionLookup{*keyID, k, op, kademlia.lookupContact(ctx, &target, k, op)}
+	})
+	if err != nil {
+		return nil
+	}
+	r := v.(regionLookup)
+	if r.key == *keyID && r.k == k && r.op == op {
+		return r.confirmed
+	}
+	return kademlia.rideRegion(ctx, keyID, k, op, r.confirmed)
+}
+
+// rideRegion turns the peers a shared lookup for a nearby key confirmed into
+// keyID's k closest. It ranks them, with the routing table's closest, against
+// keyID and sends one FIND_NODE round for keyID: to the α closest of those
+// and to any of the k closest the shared lookup didn't hear from. Peers a
+// round turns up among the k closest are asked in turn, each at most once,
+// until the k closest have all answered someone. Returns those that did.
+func (kademlia *Kademlia) rideRegion(ctx context.Context, keyID *KademliaID, k int, op *opAccount, shared []Contact) []Contact {
+	if k <= 0 {
+		return shared // nothing to confirm: the shared lookup refreshed the table
+	}
+	target := Contact{ID: keyID}
+	confirmed := make(map[string]bool, len(shared))
+	for _, c := range shared {
+		confirmed[c.Address] = true
+	}
+	asked := make(map[string]bool)
+	dead := make(map[string]bool)
+	pool := append(append([]Contact(nil), shared...), kademlia.routingTable.FindClosestContacts(keyID, k)...)
+	top := func() []Contact {
+		seen := make(map[string]bool, len(pool))
+		var live []Contact
+		for _, c := range pool {
+			if !seen[c.Address] && !dead[c.Address] && c.Address != kademlia.me.Address {
+				seen[c.Address] = true
+				live = append(live, c)
+			}
+		}
+		return closestTo(live, keyID, k)
+	}
+	query := func(batch []Contact) {
+		type result struct {
+			peer     Contact
+			contacts []Contact
+			err      error
+		}
+		results := make(chan result, len(batch))
+		for _, p := range batch {
+			asked[p.Address] = true
+			go func(p Contact) {
+				cs, err := kademlia.network.sendFindNodeToCtx(ctx, &p, &target, op)
+				results <- result{p, cs, err}
+			}(p)
+		}
+		for range batch {
+			r := <-results
+			if r.err != nil {
+				dead[r.peer.Address] = true
+				continue
+			}
+			confirmed[r.peer.Address] = true
+			pool = append(pool, r.contacts...)
+		}
+	}
+
+	alpha := int(kademlia.alpha.Load())
+	var batch []Contact
+	for i, c := range top() {
+		if i < alpha || !confirmed[c.Address] {
+			batch = append(batch, c)
+		}
+	}
+	for len(batch) > 0 && ctx.Err() == nil {
+		query(batch)
+		batch = batch[:0]
+		for _, c := range top() {
+			if !confirmed[c.Address] && !asked[c.Address] {
+				batch = append(batch, c)
+			}
+		}
+	}
+
+	var out []Contact
+	for _, c := range top() {
+		if confirmed[c.Address] {
+			out = append(out, c)
+		}
+	}
+	return out
 }
 
 // republisher ticks forever (until Close) and republishes *origin* keys
 // to the CURRENT K closest peers, ensuring newly joined closer nodes receive them.
 func (kademlia *Kademlia) republisher() {
-	ticker := time.NewTicker(kademlia.republishInterval)
-	defer ticker.Stop()
+	defer kademlia.background.Done()
+	timer := time.NewTimer(kademlia.nextRepublishDelay())
+	defer timer.Stop()
 	for {
 		select {
-		case <-ticker.C:
-			kademlia.republishOwnedKeys()
+		case <-timer.C:
+			kademlia.republishOwnedKeys(false)
+			timer.Reset(kademlia.nextRepublishDelay())
+		case <-kademlia.republishKick:
+			// The next round is one new interval from now.
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(kademlia.nextRepublishDelay())
 		case <-kademlia.republishStop:
 			return
 		}
 	}
 }
 
-func (kademlia *Kademlia) republishOwnedKeys() {
+// nextRepublishDelay is the republish interval moved by a random amount of
+// up to ±republishJitter of itself, drawn afresh for every round, so nodes
+// started together don't republish in lockstep.
+func (kademlia *Kademlia) nextRepublishDelay() time.Duration {
+	d := time.Duration(kademlia.republishInterval.Load())
+	if j := kademlia.republishJitter; j > 0 {
+		d += time.Duration((2*rand.Float64() - 1) * j * float64(d))
+	}
+	return d
+}
+
+// SetRepublishInterval changes how often origin keys are republished; the
+// running republisher restarts its timer, so the next round is d (give or
+// take the jitter, see WithRepublishJitter) from now.
+// d must be positive, and the republisher must be on (see WithRepublish).
+func (kademlia *Kademlia) SetRepublishInterval(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("republish interval must be positive, got %v", d)
+	}
+	if !kademlia.republishEnabled {
+		return errors.New("republishing is disabled")
+	}
+	kademlia.republishInterval.Store(int64(d))
+	select {
+	case kademlia.republishKick <- struct{}{}:
+	default: // a kick is already pending; it will read the new value
+	}
+	return nil
+}
+
+// RepublishNow republishes every origin key to its current K closest peers
+// before returning, independently of the republisher's schedule, and
+// returns how many keys it republished. Keys placed with PutTo are skipped,
+// as on a regular round; unlike a regular round it STOREs even to an
+// unchanged replica set.
+func (kademlia *Kademlia) RepublishNow() int {
+	return kademlia.republishOwnedKeys(true)
+}
+
+// republishRecord is the replica set a republish round fully placed a key
+// on, as sorted "id@addr" entries, and when.
+type republishRecord struct {
+	targets string
+	at      time.Time
+}
+
+// republishOwnedKeys STOREs each managed origin key to its current K closest
+// and returns how many keys it sent. Unless force, a key whose K closest are
+// the set the previous round fully placed it on is only probed: nothing
+// closer has appeared, so each target gets a FIND_VALUE_META and only the
+// ones that lost the value (evicted, restarted) get a STORE. It is sent to
+// all of them again once storeTTL/2 has passed since, so the replicas'
+// deadlines are refreshed before they expire.
+func (kademlia *Kademlia) republishOwnedKeys(force bool) int {
+	n := 0
+	for keyHex, v := range kademlia.managedOriginValues() {
+		// Decode hex -> KademliaID for distance calcs.
+		b, err := hex.DecodeString(keyHex)
+		if err != nil || len(b) != IDLength {
+			continue
+		}
+		var keyID KademliaID
+		copy(keyID[:], b)
+
+		targets := kademlia.placement(context.Background(), &keyID, nil)
+		set := replicaSetKey(targets)
+		kademlia.originMu.RLock()
+		last, seen := kademlia.republished[keyHex]
+		kademlia.originMu.RUnlock()
+		fresh := kademlia.storeTTL <= 0 || time.Since(last.at) < kademlia.storeTTL/2
+		if !force && seen && last.targets == set && fresh {
+			held, pushed := kademlia.repairTargets(context.Background(), keyHex, v, targets, nil)
+			kademlia.debugf("[REPUBLISH] key=%s replica set unchanged, re-stored to %d missing", keyHex, pushed)
+			if held < len(targets) {
+				kademlia.originMu.Lock()
+				delete(kademlia.republished, keyHex) // send in full next round
+				kademlia.originMu.Unlock()
+			}
+			if pushed > 0 {
+				n++
+			}
+			continue
+		}
+
+		_, acked, _ := kademlia.storeToTargets(context.Background(), keyHex, &keyID, v, targets, nil)
+		kademlia.originMu.Lock()
+		if _, mine := kademlia.originKeys[keyHex]; mine && acked == len(targets) {
+			if kademlia.republished == nil {
+				kademlia.republished = make(map[string]republishRecord)
+			}
+			kademlia.republished[keyHex] = republishRecord{targets: set, at: time.Now()}
+		} else {
+			delete(kademlia.republished, keyHex) // retry the stragglers next round
+		}
+		kademlia.originMu.Unlock()
+		n++
+	}
+	return n
+}
+
+// replicaSetKey identifies a set of contacts independently of their order.
+func replicaSetKey(contacts []Contact) string {
+	ids := make([]string, len(contacts))
+	for i, c := range contacts {
+		ids[i] = c.ID.String() + "@" + c.Address
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// managedOriginValues snapshots (copies of) the origin values whose placement
+// the node maintains itself, i.e. everything but PutTo keys.
+func (kademlia *Kademlia) managedOriginValues() map[string][]byte {
 	// Snapshot list of origin keys under lock; read values safely.
 	kademlia.originMu.RLock()
 	keys := make([]string, 0, len(kademlia.originKeys))
 	for k := range kademlia.originKeys {
+		if _, fixed := kademlia.fixedPlacement[k]; fixed {
+			continue // PutTo: the caller owns placement
+		}
 		keys = append(keys, k)
 	}
 	kademlia.originMu.RUnlock()
 
+	out := make(map[string][]byte, len(keys))
 	for _, keyHex := range keys {
-		// Read value (copy) under RLock.
-		kademlia.storeMu.RLock()
-		val, ok := kademlia.valueStore[keyHex]
-		if !ok || len(val) == 0 {
-			kademlia.storeMu.RUnlock()
-			continue
+		if v, ok := kademlia.loadLocal(keyHex); ok && len(v) > 0 {
+			out[keyHex] = v
 		}
-		v := make([]byte, len(val))
-		copy(v, val)
-		kademlia.storeMu.RUnlock()
+	}
+	return out
+}
 
-		// Decode hex -> KademliaID for distance calcs.
-		b, err := hex.DecodeString(keyHex)
-		if err != nil || len(b) != IDLength {
+// Rebalance is an on-demand repair pass over every origin key: it re-runs
+// placement, asks each target whether it holds the key (FIND_VALUE_META),
+// and STOREs only to the ones that don't. Unreachable targets are skipped.
+// Unlike a republish, replicas that are already in place cost one probe and
+// no value transfer. Returns how many keys got at least one new replica.
+func (kademlia *Kademlia) Rebalance() int {
+	repaired := 0
+	for keyHex, v := range kademlia.managedOriginValues() {
+		keyID, err := parseKeyHex(keyHex)
+		if err != nil {
 			continue
 		}
-		var keyID KademliaID
-		copy(keyID[:], b)
+		if _, _, pushed := kademlia.repairPlacement(context.Background(), keyHex, keyID, v, nil); pushed > 0 {
+			kademlia.infof("[REBALANCE] key=%s pushed to %d missing replica(s)", keyHex, pushed)
+			repaired++
+		}
+	}
+	return repaired
+}
+
+// repairPlacement is replicateToClosest for a value the targets may already
+// have: each target is probed with FIND_VALUE_META and only the ones missing
+// the key get a STORE. held counts targets that have it afterwards, pushed
+// the ones we just stored to.
+func (kademlia *Kademlia) repairPlacement(ctx context.Context, keyHex string, keyID *KademliaID, value []byte, op *opAccount) (targets []Contact, held, pushed int) {
+	targets = kademlia.placement(ctx, keyID, op)
+	held, pushed = kademlia.repairTargets(ctx, keyHex, value, targets, op)
+	return targets, held, pushed
+}
 
-		kademlia.replicateToClosest(keyHex, &keyID, v)
+// repairTargets is repairPlacement over an already chosen set of targets.
+func (kademlia *Kademlia) repairTargets(ctx context.Context, keyHex string, value []byte, targets []Contact, op *opAccount) (held, pushed int) {
+	type outcome struct{ had, stored bool }
+	results := make(chan outcome, len(targets))
+	for _, c := range targets {
+		go func(p Contact) {
+			found, _, _, err := kademlia.network.sendFindMetaToCtx(ctx, &p, keyHex, kademlia.timeoutRPC, op)
+			if err != nil || found {
+				results <- outcome{had: found}
+				return
+			}
+			results <- outcome{stored: kademlia.network.sendStoreToCtx(ctx, &p, keyHex, value, kademlia.timeoutRPC, op) == nil}
+		}(c)
+	}
+	for range targets {
+		r := <-results
+		if r.had || r.stored {
+			held++
+		}
+		if r.stored {
+			pushed++
+		}
 	}
+	return held, pushed
 }
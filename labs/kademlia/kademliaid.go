@@ -2,6 +2,7 @@ package kademlia
 
 import (
 	"encoding/hex"
+	"math/bits"
 	"math/rand"
 )
 
@@ -21,9 +22,19 @@ func NewKademliaID(data string) *KademliaID {
 
 // NewRandomKademliaID returns a random ID (non-crypto)
 func NewRandomKademliaID() *KademliaID {
+	return NewRandomKademliaIDFrom(nil)
+}
+
+// NewRandomKademliaIDFrom returns a random ID drawn from r, so a seeded
+// source gives reproducible IDs; nil uses the global math/rand source.
+func NewRandomKademliaIDFrom(r *rand.Rand) *KademliaID {
+	intn := rand.Intn
+	if r != nil {
+		intn = r.Intn
+	}
 	id := KademliaID{}
 	for i := 0; i < IDLength; i++ {
-		id[i] = uint8(rand.Intn(256))
+		id[i] = uint8(intn(256))
 	}
 	return &id
 }
@@ -60,6 +71,17 @@ func (kademliaID KademliaID) CalcDistance(target *KademliaID) *KademliaID {
 	return &result
 }
 
+// PrefixLen returns how many leading bits (MSB first) the two IDs share:
+// 0 if the first bit differs, IDLength*8 if they are equal.
+func (kademliaID *KademliaID) PrefixLen(other *KademliaID) int {
+	for i := 0; i < IDLength; i++ {
+		if x := kademliaID[i] ^ other[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return IDLength * 8
+}
+
 // String hex-encodes the ID
 func (kademliaID *KademliaID) String() string {
 	return hex.EncodeToString(kademliaID[0:IDLength])
@@ -0,0 +1,111 @@
+package kademlia
+
+// keyed.go: keyed records, values under a key the publisher chose rather than
+// their hash (opt-in, see WithKeyedRecords).
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrKeyedDisabled is returned by PutAt on a node without WithKeyedRecords.
+var ErrKeyedDisabled = errors.New("keyed records disabled")
+
+// keyedMagic starts every keyed record. A record for name is stored under
+// sha1(keyedMagic + name), so keyed records live in a namespace of their own:
+// one can't land on a content key without a SHA-1 preimage.
+var keyedMagic = []byte("\x00kademlia-keyed/1\n")
+
+// keyedKey returns the storage key of the record named name (canonical hex).
+func keyedKey(name string) (keyHex string, id *KademliaID) {
+	sum := sha1.Sum(append(append([]byte(nil), keyedMagic...), name...))
+	var kid KademliaID
+	copy(kid[:], sum[:])
+	return hex.EncodeToString(sum[:]), &kid
+}
+
+// encodeKeyed wraps data as the record for name, which carries the name so
+// any node can check the record against its storage key.
+func encodeKeyed(name string, data []byte) []byte {
+	b := make([]byte, 0, len(keyedMagic)+len(name)+len(data))
+	return append(append(append(b, keyedMagic...), name...), data...)
+}
+
+// decodeKeyed splits a keyed record into its name and data; ok is false for
+// anything else.
+func decodeKeyed(v []byte) (name string, data []byte, ok bool) {
+	if !bytes.HasPrefix(v, keyedMagic) || len(v) < len(keyedMagic)+2*IDLength {
+		return "", nil, false
+	}
+	name = string(v[len(keyedMagic) : len(keyedMagic)+2*IDLength])
+	if canon, err := canonicalKey(name); err != nil || canon != name || !isValidHex(name) {
+		return "", nil, false
+	}
+	return name, v[len(keyedMagic)+2*IDLength:], true
+}
+
+// keyedFitsKey reports whether value is a keyed record whose name maps to
+// keyHex.
+func keyedFitsKey(keyHex string, value []byte) bool {
+	name, _, ok := decodeKeyed(value)
+	if !ok {
+		return false
+	}
+	want, _ := keyedKey(name)
+	return want == keyHex
+}
+
+// PutAt stores data as the record named keyHex, a key the caller chose
+// rather than sha1(data), and replicates and republishes it like Put.
+// Storing there again replaces the record, so a key can name "the latest" of
+// something; any publisher may replace it. Records live apart from content
+// keys (read them back with GetAt) and only reach peers that opted in with
+// WithKeyedRecords. data, with the record's header, must fit in one chunk.
+func (kademlia *Kademlia) PutAt(keyHex string, data []byte) error {
+	if !kademlia.keyedRecords {
+		return ErrKeyedDisabled
+	}
+	name, err := canonicalKey(keyHex)
+	if err != nil {
+		return err
+	}
+	if _, err := parseKeyHex(name); err != nil {
+		return err
+	}
+	record := encodeKeyed(name, data)
+	if len(record) > chunkSize {
+		return fmt.Errorf("%w: %d bytes, PutAt stores at most %d", ErrValueTooLarge, len(data), chunkSize-(len(record)-len(data)))
+	}
+	if kademlia.valueTooLarge(len(record)) {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrValueTooLarge, len(record), kademlia.maxValueBytes.Load())
+	}
+	storeHex, id := keyedKey(name)
+	_, err = kademlia.putValue(context.Background(), storeHex, id, record, nil)
+	return err
+}
+
+// GetAt returns the data of the record PutAt stored as keyHex, and the
+// contact it came from. Records are never cached from the network.
+func (kademlia *Kademlia) GetAt(keyHex string) ([]byte, *Contact, error) {
+	name, err := canonicalKey(keyHex)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := parseKeyHex(name); err != nil {
+		return nil, nil, err
+	}
+	storeHex, _ := keyedKey(name)
+	v, from, err := kademlia.get(context.Background(), storeHex, false, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	got, data, ok := decodeKeyed(v)
+	if !ok || got != name {
+		return nil, nil, fmt.Errorf("record %s: value found is not its record", name)
+	}
+	return data, from, nil
+}
@@ -0,0 +1,169 @@
+package kademlia
+
+// latency.go: per-request-type RTT histograms, fed from the inflight path.
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// latencyBounds are the histogram bucket upper bounds; a final implicit
+// bucket catches everything slower.
+var latencyBounds = [...]time.Duration{
+	500 * time.Microsecond,
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+}
+
+// Histogram is a snapshot of one request type's RTT distribution.
+// Counts[i] counts samples <= Bounds[i] (and > Bounds[i-1]); the last entry
+// of Counts, one past len(Bounds), counts samples above every bound.
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []int64
+	Count  int64
+	Sum    time.Duration
+}
+
+// Mean is the average RTT, or 0 with no samples.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns the upper bound of the bucket holding the q-th quantile
+// (0 < q <= 1). Samples above the last bound report that last bound.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 || len(h.Bounds) == 0 {
+		return 0
+	}
+	rank := int64(q * float64(h.Count))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range h.Counts {
+		seen += n
+		if seen >= rank {
+			if i < len(h.Bounds) {
+				return h.Bounds[i]
+			}
+			break
+		}
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// latencyHist is the live, lock-free form of a Histogram.
+type latencyHist struct {
+	counts [len(latencyBounds) + 1]atomic.Int64
+	count  atomic.Int64
+	sum    atomic.Int64 // nanoseconds
+}
+
+func (h *latencyHist) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && d > latencyBounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *latencyHist) snapshot() Histogram {
+	out := Histogram{
+		Bounds: append([]time.Duration(nil), latencyBounds[:]...),
+		Counts: make([]int64, len(h.counts)),
+		Count:  h.count.Load(),
+		Sum:    time.Duration(h.sum.Load()),
+	}
+	for i := range h.counts {
+		out.Counts[i] = h.counts[i].Load()
+	}
+	return out
+}
+
+// latencyTable holds one histogram per request type. The set of types is
+// fixed, so lookups need no lock.
+type latencyTable struct {
+	ping, findNode, findValue, findMeta, store latencyHist
+}
+
+func (t *latencyTable) hist(typ msgType) *latencyHist {
+	switch typ {
+	case msgPing:
+		return &t.ping
+	case msgFindNode:
+		return &t.findNode
+	case msgFindValue:
+		return &t.findValue
+	case msgFindMeta:
+		return &t.findMeta
+	case msgStore:
+		return &t.store
+	default:
+		return nil
+	}
+}
+
+func (t *latencyTable) observe(typ msgType, d time.Duration) {
+	if h := t.hist(typ); h != nil {
+		h.observe(d)
+	}
+}
+
+// RPCType names a request type whose latency LatencyStats reports.
+type RPCType int
+
+const (
+	RPCPing          RPCType = iota + 1 // PING
+	RPCFindNode                         // FIND_NODE
+	RPCFindValue                        // FIND_VALUE
+	RPCFindValueMeta                    // FIND_VALUE_META
+	RPCStore                            // STORE
+)
+
+// msg is the wire type t stands for, or "" for an unknown RPCType.
+func (t RPCType) msg() msgType {
+	switch t {
+	case RPCPing:
+		return msgPing
+	case RPCFindNode:
+		return msgFindNode
+	case RPCFindValue:
+		return msgFindValue
+	case RPCFindValueMeta:
+		return msgFindMeta
+	case RPCStore:
+		return msgStore
+	default:
+		return ""
+	}
+}
+
+func (t RPCType) String() string {
+	if m := t.msg(); m != "" {
+		return string(m)
+	}
+	return "unknown"
+}
+
+// LatencyStats returns the send-to-reply latency histogram for a request
+// type. An unknown RPCType yields an empty histogram.
+func (kademlia *Kademlia) LatencyStats(typ RPCType) Histogram {
+	if h := kademlia.network.latency.hist(typ.msg()); h != nil {
+		return h.snapshot()
+	}
+	return Histogram{Bounds: append([]time.Duration(nil), latencyBounds[:]...), Counts: make([]int64, len(latencyBounds)+1)}
+}
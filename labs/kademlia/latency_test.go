@@ -0,0 +1,52 @@
+package kademlia
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyHist_BucketsAndQuantiles(t *testing.T) {
+	var h latencyHist
+	for _, d := range []time.Duration{300 * time.Microsecond, 3 * time.Millisecond, 3 * time.Millisecond, 5 * time.Second} {
+		h.observe(d)
+	}
+	s := h.snapshot()
+	if s.Count != 4 || len(s.Counts) != len(s.Bounds)+1 {
+		t.Fatalf("bad snapshot shape: %+v", s)
+	}
+	if s.Counts[0] != 1 || s.Counts[3] != 2 || s.Counts[len(s.Counts)-1] != 1 {
+		t.Fatalf("samples in wrong buckets: %v", s.Counts)
+	}
+	if q := s.Quantile(0.5); q != 5*time.Millisecond {
+		t.Fatalf("p50 = %v, want 5ms bucket", q)
+	}
+	if q := s.Quantile(1); q != time.Second {
+		t.Fatalf("p100 = %v, want the last bound", q)
+	}
+}
+
+// Replies resolved through the inflight table are timed per request type.
+func TestLatencyStats_RecordedPerType(t *testing.T) {
+	nodes, _ := m2Cluster(t, 3)
+	a := nodes[1]
+	keyHex, err := a.Put([]byte("timed"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, _, _ = a.network.sendFindValueTo(&nodes[0].me, keyHex, time.Second, nil)
+	a.network.PingWait(&nodes[0].me, time.Second)
+
+	for _, typ := range []RPCType{RPCPing, RPCFindNode, RPCFindValue, RPCStore} {
+		if h := a.LatencyStats(typ); h.Count == 0 {
+			t.Errorf("%s: no latency samples", typ)
+		} else if h.Mean() <= 0 || h.Mean() > time.Second {
+			t.Errorf("%s: implausible mean %v", typ, h.Mean())
+		}
+	}
+	if a.network.latency.hist(msgPong) != nil {
+		t.Fatalf("reply types must not be tracked")
+	}
+	if h := a.LatencyStats(RPCType(0)); h.Count != 0 || len(h.Counts) != len(latencyBounds)+1 {
+		t.Fatalf("unknown RPCType: %+v, want an empty histogram", h)
+	}
+}
@@ -0,0 +1,88 @@
+package kademlia
+
+// logger.go: the node's leveled diagnostics (see SetLogger).
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Logger receives a node's diagnostics. Debugf gets the per-message chatter:
+// every datagram, handler and lookup step. Infof gets what an operator wants
+// to see: refused or dropped messages, persistence failures, repairs.
+type Logger interface {
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+}
+
+// LogLevel is the least severe level a NewLogger logger writes.
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogQuiet // nothing
+)
+
+// NewLogger returns a Logger writing a line to w per message at level or
+// above, prefixed with the level.
+func NewLogger(w io.Writer, level LogLevel) Logger {
+	return &writerLogger{w: w, level: level}
+}
+
+type writerLogger struct {
+	mu    sync.Mutex // concurrent goroutines write whole lines
+	w     io.Writer
+	level LogLevel
+}
+
+func (l *writerLogger) Debugf(format string, args ...any) { l.logf(LogDebug, "debug ", format, args) }
+func (l *writerLogger) Infof(format string, args ...any)  { l.logf(LogInfo, "info  ", format, args) }
+
+func (l *writerLogger) logf(level LogLevel, prefix, format string, args []any) {
+	if level < l.level {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.w, prefix+format+"\n", args...)
+}
+
+// nopLogger is the default: nodes are silent unless given a Logger.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...any) {}
+func (nopLogger) Infof(string, ...any)  {}
+
+// logHolder boxes a Logger so it can be swapped atomically.
+type logHolder struct{ Logger }
+
+// SetLogger routes the node's diagnostics to l; nil silences them again.
+// Safe to call while the node is running.
+func (kademlia *Kademlia) SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	kademlia.logger.Store(&logHolder{l})
+}
+
+func (kademlia *Kademlia) log() Logger {
+	if h := kademlia.logger.Load(); h != nil {
+		return h.Logger
+	}
+	return nopLogger{}
+}
+
+func (kademlia *Kademlia) debugf(format string, args ...any) { kademlia.log().Debugf(format, args...) }
+func (kademlia *Kademlia) infof(format string, args ...any)  { kademlia.log().Infof(format, args...) }
+
+func (network *Network) log() Logger {
+	if network.kademlia == nil {
+		return nopLogger{}
+	}
+	return network.kademlia.log()
+}
+
+func (network *Network) debugf(format string, args ...any) { network.log().Debugf(format, args...) }
+func (network *Network) infof(format string, args ...any)  { network.log().Infof(format, args...) }
@@ -1,10 +1,16 @@
 package kademlia
 
 import (
+	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
+	"math"
 	"net"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -22,7 +28,13 @@ func randIDHex(t *testing.T) string {
 
 func freeUDPPort(t *testing.T) int {
 	t.Helper()
-	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	return freeUDPPortOn(t, "127.0.0.1")
+}
+
+// freeUDPPortOn is freeUDPPort for another local IP, e.g. "::1".
+func freeUDPPortOn(t *testing.T, ip string) int {
+	t.Helper()
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(ip), Port: 0})
 	if err != nil {
 		t.Fatalf("ListenUDP: %v", err)
 	}
@@ -32,8 +44,13 @@ func freeUDPPort(t *testing.T) int {
 
 func newNode(t *testing.T) (*Kademlia, Contact) {
 	t.Helper()
-	ip := "127.0.0.1"
-	port := freeUDPPort(t)
+	return newNodeOn(t, "127.0.0.1")
+}
+
+// newNodeOn is newNode listening on ip instead of IPv4 loopback.
+func newNodeOn(t *testing.T, ip string) (*Kademlia, Contact) {
+	t.Helper()
+	port := freeUDPPortOn(t, ip)
 	idHex := randIDHex(t)
 
 	idBytes, _ := hex.DecodeString(idHex)
@@ -105,6 +122,69 @@ func TestPingAddsBothSides(t *testing.T) {
 	}
 }
 
+// Ping learns the responder's real ID even when the caller only knew its
+// address, and fails cleanly if nothing answers.
+func TestPing_ReturnsRespondersRealContact(t *testing.T) {
+	a, _ := newNode(t)
+	_, bMe := newNode(t)
+	placeholder := NewContact(NewKademliaID(randIDHex(t)), bMe.Address)
+
+	got, err := a.Ping(&placeholder)
+	if err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if !got.ID.Equals(bMe.ID) || got.Address != bMe.Address {
+		t.Fatalf("Ping returned %s@%s, want %s@%s", got.ID, got.Address, bMe.ID, bMe.Address)
+	}
+	if c := a.routingTable.FindClosestContacts(bMe.ID, 1); len(c) != 1 || !c[0].ID.Equals(bMe.ID) {
+		t.Fatalf("routing table closest to b = %v, want b under its real ID", c)
+	}
+
+	dead := NewContact(NewKademliaID(randIDHex(t)), net.JoinHostPort("127.0.0.1", itoa(freeUDPPort(t))))
+	if _, err := a.Ping(&dead); err == nil {
+		t.Fatalf("Ping to a closed port succeeded")
+	}
+}
+
+// Nodes on the IPv6 loopback PING each other and join like IPv4 ones,
+// including when the listen IP is given in brackets.
+func TestIPv6_PingAndJoin(t *testing.T) {
+	if probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback}); err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	} else {
+		_ = probe.Close()
+	}
+	a, aMe := newNodeOn(t, "::1")
+	b, bMe := newNodeOn(t, "::1")
+	if !strings.HasPrefix(aMe.Address, "[::1]:") {
+		t.Fatalf("IPv6 contact address = %q, want [::1]:port", aMe.Address)
+	}
+
+	got, err := a.Ping(&bMe)
+	if err != nil || !got.ID.Equals(bMe.ID) || got.Address != bMe.Address {
+		t.Fatalf("Ping over IPv6 = %v, %v; want %v", got, err, bMe)
+	}
+	if !waitUntil(t, 2*time.Second, func() bool {
+		return hasContactWithAddress(a, bMe.Address) && hasContactWithAddress(b, aMe.Address)
+	}) {
+		t.Fatalf("IPv6 PING did not populate both tables")
+	}
+
+	port := freeUDPPortOn(t, "::1")
+	cMe := NewContact(NewKademliaID(randIDHex(t)), net.JoinHostPort("::1", itoa(port)))
+	c, err := NewKademlia(cMe, "[::1]", port)
+	if err != nil {
+		t.Fatalf("NewKademlia with bracketed IPv6 ip: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	if err := c.Join(&aMe); err != nil {
+		t.Fatalf("Join over IPv6: %v", err)
+	}
+	if !hasContactWithAddress(c, aMe.Address) || !hasContactWithAddress(c, bMe.Address) {
+		t.Fatalf("IPv6 joiner knows %v, want both a and b", getAllAddresses(c))
+	}
+}
+
 // Test Join(): PING + iterative lookup on self ID.
 func TestJoinPopulatesRoutingTables(t *testing.T) {
 	a, aMe := newNode(t)
@@ -152,6 +232,87 @@ func TestLookupFindsTargetInSmallNetwork(t *testing.T) {
 	}
 }
 
+// LookupContact returns what it converged on: sorted by XOR distance to the
+// target, one entry per address, never ourselves.
+func TestLookupContactReturnsSortedClosest(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 8)
+	origin := nodes[2]
+	target := NewContact(NewKademliaID(randIDHex(t)), "")
+
+	got := origin.LookupContact(&target)
+	if len(got) == 0 {
+		t.Fatalf("LookupContact returned nothing in an %d-node network", len(contacts))
+	}
+	seen := make(map[string]bool)
+	for i, c := range got {
+		if seen[c.Address] {
+			t.Fatalf("duplicate address %s in result", c.Address)
+		}
+		seen[c.Address] = true
+		if c.Address == contacts[2].Address {
+			t.Fatalf("result includes the node doing the lookup")
+		}
+		if i > 0 && c.ID.CalcDistance(target.ID).Less(got[i-1].ID.CalcDistance(target.ID)) {
+			t.Fatalf("result not sorted by distance at %d", i)
+		}
+	}
+}
+
+// With α=1 a lookup asks one peer per round and still walks a chain in which
+// each node only knows the next, every hop closer to the target.
+func TestSetAlpha_OneStillConvergesOnChain(t *testing.T) {
+	ids := []string{"00", "80", "c0", "e0", "f0", "ff"} // XOR distance to ff.. shrinks each hop
+	var out safeBuffer
+	nodes := make([]*Kademlia, len(ids))
+	contacts := make([]Contact, len(ids))
+	for i, p := range ids {
+		var opts []Option
+		if i == 0 {
+			opts = append(opts, WithLookupTrace(&out))
+		}
+		nodes[i], contacts[i] = m2NewNodeWithID(t, p+strings.Repeat("0", 2*IDLength-2), opts...)
+	}
+	for i := 0; i+1 < len(nodes); i++ {
+		nodes[i].routingTable.AddContact(contacts[i+1])
+	}
+	if err := nodes[0].SetAlpha(0); err == nil {
+		t.Fatalf("SetAlpha(0) accepted")
+	}
+	if err := nodes[0].SetAlpha(1); err != nil {
+		t.Fatalf("SetAlpha(1): %v", err)
+	}
+
+	target := contacts[len(contacts)-1]
+	got := nodes[0].LookupContact(&target)
+	if len(got) == 0 || got[0].Address != target.Address {
+		t.Fatalf("lookup did not reach the end of the chain: %v", got)
+	}
+	trace := out.String()
+	if strings.Contains(trace, "batch 2") || !strings.Contains(trace, "round 4 batch 1") {
+		t.Fatalf("want one peer per round over several rounds:\n%s", trace)
+	}
+}
+
+// With α=8 the first round of a lookup dispatches eight FIND_NODEs at once.
+func TestSetAlpha_EightQueriesPerRound(t *testing.T) {
+	_, contacts := m2Cluster(t, 10)
+	var out safeBuffer
+	k, _ := m2NewNode(t, WithLookupTrace(&out))
+	if err := k.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	if err := k.SetAlpha(8); err != nil {
+		t.Fatalf("SetAlpha(8): %v", err)
+	}
+	out.b.Reset()
+
+	target := NewContact(NewKademliaID(randIDHex(t)), "")
+	k.LookupContact(&target)
+	if trace := out.String(); !strings.Contains(trace, "round 1 batch 8:") {
+		t.Fatalf("first round did not query 8 peers:\n%s", trace)
+	}
+}
+
 // Verify FIND_NODE path adds responder and returned contacts to routing table.
 func TestFindNodePopulatesDiscoveredContacts(t *testing.T) {
 	// Three nodes: A <-> B, C <-> B; A should learn C through querying B.
@@ -262,3 +423,815 @@ func TestRepeatedPingIsIdempotentEnough(t *testing.T) {
 		t.Fatalf("Unexpected routing table size delta after repeated ping: before=%d after=%d", before, after)
 	}
 }
+
+// Join right after the bootstrap is constructed, with no settling sleep.
+func TestJoinImmediatelyAfterBootstrapStartup(t *testing.T) {
+	boot, bootMe := newNode(t)
+	a, aMe := newNode(t)
+	for _, k := range []*Kademlia{boot, a} {
+		select {
+		case <-k.Ready():
+		default:
+			t.Fatalf("NewKademlia returned before the node was ready")
+		}
+	}
+
+	if err := a.Join(&bootMe); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	if a.routingTable.Size() == 0 {
+		t.Fatalf("routing table empty after Join")
+	}
+	if !hasContactWithAddress(boot, aMe.Address) {
+		t.Fatalf("bootstrap did not learn the joiner")
+	}
+}
+
+// ForgetPeers leaves the node with an empty table; a fresh Join reconnects it.
+func TestForgetPeersThenRejoin(t *testing.T) {
+	boot, bootMe := newNode(t)
+	a, aMe := newNode(t)
+	if err := a.Join(&bootMe); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	if a.routingTable.Size() == 0 {
+		t.Fatalf("routing table empty after Join")
+	}
+
+	a.ForgetPeers()
+	if n := a.routingTable.Size(); n != 0 {
+		t.Fatalf("Size after ForgetPeers = %d, want 0", n)
+	}
+	if err := a.Join(&bootMe); err != nil {
+		t.Fatalf("re-Join: %v", err)
+	}
+	if !hasContactWithAddress(a, bootMe.Address) || !hasContactWithAddress(boot, aMe.Address) {
+		t.Fatalf("nodes did not relearn each other after re-Join")
+	}
+}
+
+// Join must report failure when the bootstrap never answers.
+func TestJoinDeadBootstrapReturnsError(t *testing.T) {
+	a, _ := newNode(t)
+	a.joinAttempts = 2
+
+	dead := NewContact(NewRandomKademliaID(), net.JoinHostPort("127.0.0.1", itoa(freeUDPPort(t))))
+	if err := a.Join(&dead); err == nil {
+		t.Fatalf("expected Join to fail against a dead bootstrap")
+	}
+	if a.routingTable.Size() != 0 {
+		t.Fatalf("dead bootstrap should not be in the routing table")
+	}
+}
+
+// FIND_NODE honours the requested count: at most that many come back even
+// when the responder knows more; no count means bucketSize.
+func TestFindNode_HonoursCount(t *testing.T) {
+	a, _ := newNode(t)
+	b, bMe := newNode(t)
+	for i := 0; i < 12; i++ {
+		b.routingTable.AddContact(NewContact(NewKademliaID(randIDHex(t)), "127.0.0.1:"+itoa(freeUDPPort(t))))
+	}
+	target := NewContact(NewKademliaID(randIDHex(t)), "")
+	got, err := a.network.sendFindNodeCountCtx(context.Background(), &bMe, &target, 5, nil)
+	if err != nil {
+		t.Fatalf("FIND_NODE count=5: %v", err)
+	}
+	if len(got) != 5 {
+		t.Fatalf("FIND_NODE count=5 returned %d contacts", len(got))
+	}
+	if got, err := a.network.sendFindNodeTo(&bMe, &target, nil); err != nil || len(got) <= 5 {
+		t.Fatalf("FIND_NODE without a count returned %d contacts, %v; want all 12 known", len(got), err)
+	}
+	if findNodeCount(0) != bucketSize || findNodeCount(1000) != maxFindNodeCount {
+		t.Fatalf("findNodeCount clamps wrong: %d, %d", findNodeCount(0), findNodeCount(1000))
+	}
+}
+
+// JoinAny skips a dead bootstrap and joins via the next one that answers,
+// even when it only knows that one by address.
+func TestJoinAnySkipsDeadBootstrap(t *testing.T) {
+	a, aMe := newNode(t)
+	boot, bootMe := newNode(t)
+
+	dead := NewContact(NewRandomKademliaID(), net.JoinHostPort("127.0.0.1", itoa(freeUDPPort(t))))
+	alive := NewContact(NewRandomKademliaID(), bootMe.Address) // placeholder ID
+	if err := a.JoinAny([]*Contact{&dead, &alive}); err != nil {
+		t.Fatalf("JoinAny: %v", err)
+	}
+	if !hasContactWithAddress(a, bootMe.Address) || !hasContactWithAddress(boot, aMe.Address) {
+		t.Fatalf("nodes did not learn each other via the live bootstrap")
+	}
+	if hasContactWithAddress(a, dead.Address) {
+		t.Fatalf("dead bootstrap ended up in the routing table")
+	}
+
+	a.joinAttempts = 2 // every attempt waits out a PING timeout
+	if err := a.JoinAny([]*Contact{&dead}); err == nil || !strings.Contains(err.Error(), dead.Address) {
+		t.Fatalf("JoinAny with only a dead bootstrap: err = %v, want one naming %s", err, dead.Address)
+	}
+}
+
+// JoinAny keeps going round the list with backoff, so a bootstrap that is
+// still starting when the node joins is waited for, as with Join.
+func TestJoinAny_WaitsForLateBootstrap(t *testing.T) {
+	a, _ := newNode(t)
+	port := freeUDPPort(t)
+	addr := net.JoinHostPort("127.0.0.1", itoa(port))
+	up := make(chan *Kademlia, 1)
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		k, err := NewKademlia(NewContact(NewRandomKademliaID(), addr), "127.0.0.1", port)
+		if err != nil {
+			t.Errorf("late bootstrap: %v", err)
+		}
+		up <- k
+	}()
+	t.Cleanup(func() {
+		if k := <-up; k != nil {
+			_ = k.Close()
+		}
+	})
+
+	boot := NewContact(NewRandomKademliaID(), addr) // placeholder ID
+	if err := a.JoinAny([]*Contact{&boot}); err != nil {
+		t.Fatalf("JoinAny before the bootstrap was up: %v", err)
+	}
+	if !hasContactWithAddress(a, addr) {
+		t.Fatalf("joined node never learned the late bootstrap")
+	}
+}
+
+// An orphaned node rejoins on its own once its bootstrap is reachable again.
+func TestRejoinWatchdog_RepopulatesAfterIsolation(t *testing.T) {
+	a, _ := m2NewNode(t, WithRejoin(50*time.Millisecond, 1))
+	boot, bootMe := m2NewNode(t)
+	if err := a.Join(&bootMe); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	if got := a.Bootstraps(); len(got) != 1 || got[0].Address != bootMe.Address {
+		t.Fatalf("Bootstraps = %v, want just %s", got, bootMe.Address)
+	}
+
+	// Bootstrap goes away and the node forgets everyone.
+	port, _ := strconv.Atoi(bootMe.Address[strings.LastIndex(bootMe.Address, ":")+1:])
+	_ = boot.Close()
+	a.ForgetPeers()
+	time.Sleep(200 * time.Millisecond) // a few failed rejoins
+	if a.routingTable.Size() != 0 {
+		t.Fatalf("table repopulated with the bootstrap down")
+	}
+
+	back, err := NewKademlia(bootMe, "127.0.0.1", port)
+	if err != nil {
+		t.Fatalf("restart bootstrap: %v", err)
+	}
+	t.Cleanup(func() { _ = back.Close() })
+	if !waitUntil(t, 5*time.Second, func() bool { return hasContactWithAddress(a, bootMe.Address) }) {
+		t.Fatalf("node did not rejoin via its bootstrap")
+	}
+}
+
+// On a chain (each node joined via the previous one) lookups still converge
+// in about log2(N) rounds, and OnLookup reports them.
+func TestOnLookup_RoundsOnChainedTopology(t *testing.T) {
+	const n = 16
+	nodes := make([]*Kademlia, n)
+	contacts := make([]Contact, n)
+	for i := range nodes {
+		nodes[i], contacts[i] = m2NewNode(t)
+		if i > 0 {
+			if err := nodes[i].Join(&contacts[i-1]); err != nil {
+				t.Fatalf("Join %d: %v", i, err)
+			}
+		}
+	}
+	var mu sync.Mutex
+	var stats []LookupStats
+	nodes[0].OnLookup(func(s LookupStats) {
+		mu.Lock()
+		stats = append(stats, s)
+		mu.Unlock()
+	})
+	maxRounds := int(math.Ceil(math.Log2(n))) + 1
+
+	nodes[0].LookupContact(&contacts[n-1])
+	// A miss walks the whole way to the key's region before giving up.
+	missing := randIDHex(t)
+	if _, _, err := nodes[0].GetNoCache(missing); err == nil {
+		t.Fatalf("Get of a key nobody stored succeeded")
+	}
+	nodes[0].OnLookup(nil)
+	nodes[0].LookupContact(&contacts[1]) // not reported
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(stats) != 2 || stats[0].Kind != "lookup" || stats[1].Kind != "get" {
+		t.Fatalf("want one lookup then one get reported, got %+v", stats)
+	}
+	if !stats[0].Target.Equals(contacts[n-1].ID) || stats[1].Target.String() != missing || stats[1].Stop == "" {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	for _, s := range stats {
+		if s.Rounds < 1 || s.Rounds > maxRounds || s.Queried < s.Rounds || s.Queried > n-1 {
+			t.Fatalf("%s: %d rounds, %d queried; want 1..%d rounds over at most %d peers", s.Kind, s.Rounds, s.Queried, maxRounds, n-1)
+		}
+		t.Logf("%s: rounds=%d queried=%d stop=%q", s.Kind, s.Rounds, s.Queried, s.Stop)
+	}
+}
+
+// A burst of sends beyond the configured budget is paced to the outbound rate.
+func TestOutboundRate_PacesBurst(t *testing.T) {
+	const rate, burst, total = 100.0, 5, 25
+	a, _ := m2NewNode(t, WithOutboundRate(rate, burst))
+	sink, err := net.ResolveUDPAddr("udp", "127.0.0.1:"+strconv.Itoa(freeUDPPort(t)))
+	if err != nil {
+		t.Fatalf("ResolveUDPAddr: %v", err)
+	}
+
+	start := time.Now()
+	for i := 0; i < total; i++ {
+		env := envelope{Type: msgPing, From: fromContact(a.me), MsgID: a.network.nextMsgID()}
+		if err := a.network.send(sink, env); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	elapsed := time.Since(start)
+
+	// The first `burst` go out at once; the rest need (total-burst)/rate.
+	want := time.Duration(float64(total-burst) / rate * float64(time.Second))
+	if elapsed < want*9/10 {
+		t.Fatalf("%d sends took %v, want >= ~%v at %.0f/s", total, elapsed, want, rate)
+	}
+	if got := a.OutboundThrottled(); got < total-burst-1 {
+		t.Fatalf("OutboundThrottled = %d, want about %d", got, total-burst)
+	}
+}
+
+// A ping exchange teaches the caller what the peer advertises in its PONG.
+func TestPingRecordsPeerCapabilities(t *testing.T) {
+	a, _ := m2NewNode(t)
+	b, bc := m2NewNode(t)
+	c, cc := m2NewNode(t)
+	const future Capabilities = 1 << 7
+	b.network.caps.Store(uint32(CapFindValueMeta | future))
+	c.network.caps.Store(0) // e.g. an older build
+
+	if _, ok := a.PeerCapabilities(bc.ID); ok {
+		t.Fatalf("capabilities known before any ping")
+	}
+	if !a.network.PingWait(&bc, time.Second) {
+		t.Fatalf("PingWait to b failed")
+	}
+	caps, ok := a.PeerCapabilities(bc.ID)
+	if !ok || !caps.Has(CapFindValueMeta|future) {
+		t.Fatalf("learned caps = %b (ok=%v), want %b", caps, ok, CapFindValueMeta|future)
+	}
+
+	a.network.SendPingMessage(&cc)
+	if caps, ok := a.PeerCapabilities(cc.ID); !ok || caps != 0 {
+		t.Fatalf("learned caps for c = %b (ok=%v), want none advertised", caps, ok)
+	}
+}
+
+// A retransmitted request whose first reply already completed the RPC yields
+// a late duplicate: counted, not delivered. Replies to nothing we sent are
+// counted separately.
+func TestLateDuplicateResponseIsCounted(t *testing.T) {
+	a, _ := m2NewNode(t)
+	b, bc := m2NewNode(t)
+	dst, _ := net.ResolveUDPAddr("udp", bc.Address)
+
+	req := envelope{Type: msgPing, From: fromContact(a.me), MsgID: a.network.nextMsgID()}
+	ch, done := a.network.register(&req)
+	if err := a.network.send(dst, req); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	select {
+	case resp := <-ch:
+		if resp.Seq != req.Seq || req.Seq == 0 {
+			t.Fatalf("PONG seq=%d, want request seq %d", resp.Seq, req.Seq)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no PONG")
+	}
+	done()
+
+	// Retransmit the identical request, as a retry timer racing the reply would.
+	_ = a.network.send(dst, req)
+	deadline := time.Now().Add(time.Second)
+	for a.LateResponses() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := a.LateResponses(); n != 1 {
+		t.Fatalf("LateResponses = %d, want 1", n)
+	}
+	if len(ch) != 0 {
+		t.Fatalf("late duplicate was delivered to a finished waiter")
+	}
+	if n := a.UnexpectedResponses(); n != 0 {
+		t.Fatalf("a late duplicate must not count as unexpected (got %d)", n)
+	}
+
+	// A PONG for a request a never made.
+	aAddr, _ := net.ResolveUDPAddr("udp", a.me.Address)
+	_ = b.network.send(aAddr, envelope{Type: msgPong, From: fromContact(b.me), MsgID: "bogus", Seq: 1 << 40})
+	deadline = time.Now().Add(time.Second)
+	for a.UnexpectedResponses() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := a.UnexpectedResponses(); n != 1 {
+		t.Fatalf("UnexpectedResponses = %d, want 1", n)
+	}
+
+	// Echoing a seq a issued doesn't make a reply late: only the MsgID of a
+	// request a completed does.
+	_ = b.network.send(aAddr, envelope{Type: msgPong, From: fromContact(b.me), MsgID: "guessed", Seq: req.Seq})
+	deadline = time.Now().Add(time.Second)
+	for a.UnexpectedResponses() == 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if late, unexpected := a.LateResponses(), a.UnexpectedResponses(); late != 1 || unexpected != 2 {
+		t.Fatalf("PONG with a known seq but unknown MsgID: late=%d unexpected=%d, want 1 and 2", late, unexpected)
+	}
+}
+
+// Two replies to one request, back to back: the waiter gets the first, the
+// second is counted late, and nothing is left in the inflight table.
+func TestDuplicateResponses_FirstDeliveredOnce(t *testing.T) {
+	a, _ := m2NewNode(t)
+	b, _ := m2NewNode(t)
+	aAddr, _ := net.ResolveUDPAddr("udp", a.me.Address)
+
+	req := envelope{Type: msgPing, From: fromContact(a.me), MsgID: a.network.nextMsgID()}
+	ch, done := a.network.register(&req)
+	defer done()
+	for i := 0; i < 2; i++ {
+		pong := envelope{Type: msgPong, From: fromContact(b.me), MsgID: req.MsgID, Seq: req.Seq}
+		if err := b.network.send(aAddr, pong); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	select {
+	case resp := <-ch:
+		if resp.MsgID != req.MsgID {
+			t.Fatalf("got reply to %s, want %s", resp.MsgID, req.MsgID)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("waiter never got a reply")
+	}
+	deadline := time.Now().Add(time.Second)
+	for a.LateResponses() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := a.LateResponses(); n != 1 {
+		t.Fatalf("LateResponses = %d, want 1", n)
+	}
+	if len(ch) != 0 {
+		t.Fatalf("duplicate was delivered to the waiter")
+	}
+	if n := a.network.pending(); n != 0 {
+		t.Fatalf("pending = %d after the reply, want 0", n)
+	}
+}
+
+// A node given extra listen addresses answers PINGs on every socket, and each
+// PONG comes back from the exact address the PING was sent to.
+func TestListenAddrs_PongFromEachSocket(t *testing.T) {
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	v6Port := probe.LocalAddr().(*net.UDPAddr).Port
+	_ = probe.Close()
+	v6Addr := net.JoinHostPort("::1", itoa(v6Port))
+
+	k, me := m2NewNode(t, WithListenAddrs(v6Addr))
+	for _, addr := range []string{me.Address, v6Addr} {
+		dst, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			t.Fatalf("resolve %s: %v", addr, err)
+		}
+		client, err := net.ListenUDP("udp", &net.UDPAddr{IP: dst.IP})
+		if err != nil {
+			t.Fatalf("client socket for %s: %v", addr, err)
+		}
+		defer client.Close()
+
+		req := envelope{
+			Type:  msgPing,
+			From:  wireContact{IDHex: m2RandIDHex(t), Address: client.LocalAddr().String()},
+			MsgID: "dual-" + addr,
+		}
+		b, _ := req.marshal()
+		if _, err := client.WriteToUDP(b, dst); err != nil {
+			t.Fatalf("write to %s: %v", addr, err)
+		}
+		_ = client.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 64*1024)
+		n, src, err := client.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("no PONG from %s: %v", addr, err)
+		}
+		var resp envelope
+		if err := resp.unmarshal(buf[:n]); err != nil || resp.Type != msgPong || resp.MsgID != req.MsgID {
+			t.Fatalf("bad reply from %s: %+v (err %v)", addr, resp, err)
+		}
+		if src.String() != dst.String() {
+			t.Fatalf("PONG for %s came from %s", dst, src)
+		}
+		if resp.From.Address != k.me.Address {
+			t.Fatalf("PONG advertises %s, want primary %s", resp.From.Address, k.me.Address)
+		}
+	}
+}
+
+// An envelope from a newer protocol version is dropped before dispatch: no
+// PONG, nothing learned. One without a version is read as v1 and answered.
+func TestReadLoop_DropsUnknownProtocolVersion(t *testing.T) {
+	k, me := newNode(t)
+	dst, err := net.ResolveUDPAddr("udp", me.Address)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: dst.IP})
+	if err != nil {
+		t.Fatalf("client socket: %v", err)
+	}
+	defer client.Close()
+	from := wireContact{IDHex: randIDHex(t), Address: client.LocalAddr().String()}
+
+	ping := func(raw string) (envelope, bool) {
+		if _, err := client.WriteToUDP([]byte(raw), dst); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		_ = client.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		buf := make([]byte, 64*1024)
+		n, _, err := client.ReadFromUDP(buf)
+		if err != nil {
+			return envelope{}, false
+		}
+		var resp envelope
+		if err := resp.unmarshal(buf[:n]); err != nil {
+			t.Fatalf("bad reply: %v", err)
+		}
+		return resp, true
+	}
+	fromJSON := `{"id":"` + from.IDHex + `","address":"` + from.Address + `"}`
+
+	if _, ok := ping(`{"v":2,"type":"PING","msg_id":"future","from":` + fromJSON + `}`); ok {
+		t.Fatalf("v2 PING was answered")
+	}
+	if k.VersionDrops() != 1 || k.routingTable.Size() != 0 {
+		t.Fatalf("v2 PING not dropped cleanly: drops=%d table=%d", k.VersionDrops(), k.routingTable.Size())
+	}
+
+	resp, ok := ping(`{"type":"PING","msg_id":"legacy","from":` + fromJSON + `}`)
+	if !ok || resp.Type != msgPong || resp.MsgID != "legacy" || resp.Version != protocolVersion {
+		t.Fatalf("unversioned PING: got %+v (ok=%v), want a v%d PONG", resp, ok, protocolVersion)
+	}
+}
+
+// rawExchange opens a bare UDP socket and returns its address and a func
+// that sends env to the node at addr and returns its reply, if any.
+func rawExchange(t *testing.T, addr string) (string, func(env envelope) (envelope, bool)) {
+	t.Helper()
+	dst, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: dst.IP})
+	if err != nil {
+		t.Fatalf("client socket: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client.LocalAddr().String(), func(env envelope) (envelope, bool) {
+		b, _ := env.marshal()
+		if _, err := client.WriteToUDP(b, dst); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		_ = client.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		buf := make([]byte, 64*1024)
+		n, _, err := client.ReadFromUDP(buf)
+		if err != nil {
+			return envelope{}, false
+		}
+		var resp envelope
+		if err := resp.unmarshal(buf[:n]); err != nil {
+			t.Fatalf("bad reply: %v", err)
+		}
+		return resp, true
+	}
+}
+
+// signedPing is a v1 PING from idHex at addr carrying claim and, unless
+// signWith is nil, a signature by signWith.
+func signedPing(msgID, idHex, addr string, signWith ed25519.PrivateKey, claim ed25519.PublicKey) envelope {
+	env := envelope{
+		Version: protocolVersion,
+		Type:    msgPing,
+		MsgID:   msgID,
+		From:    wireContact{IDHex: idHex, Address: addr, PubKey: claim},
+	}
+	if signWith != nil {
+		env.Sig = ed25519.Sign(signWith, signedPayload(env))
+	}
+	return env
+}
+
+// With WithSignedEnvelopes, unsigned and forged PINGs are dropped before the
+// sender is learned; a correctly signed one is answered with a signed PONG.
+func TestReadLoop_DropsUnsignedAndForgedEnvelopes(t *testing.T) {
+	k, me := m2NewNode(t, WithSignedEnvelopes(nil))
+	addr, ping := rawExchange(t, me.Address)
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+
+	if _, ok := ping(signedPing("unsigned", randIDHex(t), addr, nil, nil)); ok {
+		t.Fatalf("unsigned PING was answered")
+	}
+	if _, ok := ping(signedPing("forged", randIDHex(t), addr, priv, otherPub)); ok {
+		t.Fatalf("PING signed by a key other than the one it carries was answered")
+	}
+	if k.SignatureDrops() != 2 || k.routingTable.Size() != 0 {
+		t.Fatalf("bad PINGs not dropped cleanly: drops=%d table=%d", k.SignatureDrops(), k.routingTable.Size())
+	}
+
+	resp, ok := ping(signedPing("signed", randIDHex(t), addr, priv, pub))
+	if !ok || resp.Type != msgPong {
+		t.Fatalf("signed PING: got %+v (ok=%v), want a PONG", resp, ok)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(resp.From.PubKey), signedPayload(resp), resp.Sig) {
+		t.Fatalf("PONG is not signed by the key it carries")
+	}
+	if k.routingTable.Size() != 1 {
+		t.Fatalf("signed sender not learned: table=%d", k.routingTable.Size())
+	}
+
+	_, peer := m2NewNode(t, WithSignedEnvelopes(nil))
+	if !k.network.PingWait(&peer, time.Second) {
+		t.Fatalf("two signing nodes could not ping")
+	}
+}
+
+// The signature covers the whole envelope and binds it to the sender's
+// address: a signed PING altered in transit, or sent from somewhere other
+// than its From address, is dropped.
+func TestReadLoop_DropsTamperedOrRelocatedEnvelopes(t *testing.T) {
+	k, me := m2NewNode(t, WithSignedEnvelopes(nil))
+	addr, ping := rawExchange(t, me.Address)
+	pub, priv, _ := ed25519.GenerateKey(nil)
+
+	tampered := signedPing("tampered", randIDHex(t), addr, priv, pub)
+	tampered.Seq = 7 // changed after signing
+	if _, ok := ping(tampered); ok {
+		t.Fatalf("PING altered after signing was answered")
+	}
+	if _, ok := ping(signedPing("elsewhere", randIDHex(t), "127.0.0.1:1", priv, pub)); ok {
+		t.Fatalf("PING claiming another address was answered")
+	}
+	if k.SignatureDrops() != 2 || k.routingTable.Size() != 0 {
+		t.Fatalf("bad PINGs not dropped cleanly: drops=%d table=%d", k.SignatureDrops(), k.routingTable.Size())
+	}
+	if resp, ok := ping(signedPing("intact", randIDHex(t), addr, priv, pub)); !ok || resp.Type != msgPong {
+		t.Fatalf("intact signed PING: got %+v (ok=%v), want a PONG", resp, ok)
+	}
+}
+
+// Pinned keys are bounded: past maxPeerKeys the least recently heard-from ID
+// is forgotten.
+func TestPinKey_BoundedByLeastRecentlySeen(t *testing.T) {
+	k, _ := m2NewNode(t, WithSignedEnvelopes(nil))
+	pub, _, _ := ed25519.GenerateKey(nil)
+	first := NewRandomKademliaID()
+	if err := k.network.pinKey(*first, pub); err != nil {
+		t.Fatalf("pinKey: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	for i := 0; i < maxPeerKeys; i++ {
+		if err := k.network.pinKey(*NewRandomKademliaID(), pub); err != nil {
+			t.Fatalf("pinKey %d: %v", i, err)
+		}
+	}
+	k.network.keysMu.Lock()
+	defer k.network.keysMu.Unlock()
+	if n := len(k.network.peerKeys); n != maxPeerKeys {
+		t.Fatalf("%d keys pinned, want at most %d", n, maxPeerKeys)
+	}
+	if _, ok := k.network.peerKeys[*first]; ok {
+		t.Fatalf("least recently seen ID still pinned")
+	}
+}
+
+// With WithKeyDerivedID the node's ID is the hash of its key, and a validly
+// signed PING claiming any other ID is dropped without learning the sender.
+func TestKeyDerivedID_RejectsMismatchedID(t *testing.T) {
+	k, me := m2NewNode(t, WithKeyDerivedID())
+	if want := keyID(k.signKey.Public().(ed25519.PublicKey)); *k.me.ID != want || k.me.ID.Equals(me.ID) {
+		t.Fatalf("node ID %s, want sha1 of its key %s", k.me.ID, want.String())
+	}
+	addr, ping := rawExchange(t, me.Address)
+	pub, priv, _ := ed25519.GenerateKey(nil)
+
+	if _, ok := ping(signedPing("chosen-id", randIDHex(t), addr, priv, pub)); ok {
+		t.Fatalf("PING with an ID not derived from its key was answered")
+	}
+	if k.SignatureDrops() != 1 || k.routingTable.Size() != 0 {
+		t.Fatalf("mismatched ID not dropped cleanly: drops=%d table=%d", k.SignatureDrops(), k.routingTable.Size())
+	}
+
+	id := keyID(pub)
+	if resp, ok := ping(signedPing("derived-id", id.String(), addr, priv, pub)); !ok || resp.Type != msgPong {
+		t.Fatalf("PING with a key-derived ID: got %+v (ok=%v), want a PONG", resp, ok)
+	}
+	if k.routingTable.Size() != 1 {
+		t.Fatalf("key-derived sender not learned: table=%d", k.routingTable.Size())
+	}
+
+	peer, _ := m2NewNode(t, WithKeyDerivedID())
+	if !k.network.PingWait(&peer.me, time.Second) {
+		t.Fatalf("two key-derived nodes could not ping")
+	}
+}
+
+// Under WithKeyDerivedID a contact relayed in FIND_NODE_OK is PINGed before
+// it enters the routing table: one that doesn't answer stays out, and a live
+// one relayed under a made-up ID is added under the ID its key proves.
+func TestKeyDerivedID_RelayedContactsVerifiedByPing(t *testing.T) {
+	k, _ := m2NewNode(t, WithKeyDerivedID())
+	peer, _ := m2NewNode(t, WithKeyDerivedID())
+	other, _ := m2NewNode(t, WithKeyDerivedID())
+	silent := NewContact(NewRandomKademliaID(), "127.0.0.1:"+strconv.Itoa(m2FreeUDPPort(t)))
+	forged := NewContact(NewRandomKademliaID(), other.me.Address)
+	peer.routingTable.AddContact(silent)
+	peer.routingTable.AddContact(forged)
+
+	got, err := k.network.sendFindNodeTo(&peer.me, &silent, nil)
+	if err != nil {
+		t.Fatalf("FIND_NODE: %v", err)
+	}
+	if !containsAddr(got, silent.Address) || !containsAddr(got, forged.Address) {
+		t.Fatalf("relayed contacts missing from the reply: %v", got)
+	}
+	if hasContactWithAddress(k, silent.Address) {
+		t.Fatalf("relayed contact that never answered was added to the routing table")
+	}
+	if !hasContactWithAddress(k, peer.me.Address) {
+		t.Fatalf("responder was not learned")
+	}
+	byID := k.routingTable.FindClosestContacts(other.me.ID, 1)
+	if len(byID) != 1 || !byID[0].ID.Equals(other.me.ID) || byID[0].Address != other.me.Address {
+		t.Fatalf("live relayed peer not learned under its key-derived ID: %v", byID)
+	}
+	if near := k.routingTable.FindClosestContacts(forged.ID, 1); len(near) == 1 && near[0].ID.Equals(forged.ID) {
+		t.Fatalf("made-up relayed ID entered the routing table")
+	}
+}
+
+// Key-derived nodes that all joined through one bootstrap still find each
+// other through multi-hop lookups: a Put reaches the K closest, not just the
+// nodes the publisher heard from directly, and any node can Get it.
+func TestKeyDerivedID_ClusterPutGet(t *testing.T) {
+	const n = 10
+	nodes := make([]*Kademlia, n)
+	for i := range nodes {
+		nodes[i], _ = m2NewNode(t, WithKeyDerivedID())
+	}
+	for i := 1; i < n; i++ {
+		if err := nodes[i].Join(&nodes[0].me); err != nil {
+			t.Fatalf("Join node %d: %v", i, err)
+		}
+	}
+	for i, k := range nodes[1:] {
+		if size := k.routingTable.Size(); size < 2 {
+			t.Fatalf("node %d knows %d peers after joining, want more than the bootstrap", i+1, size)
+		}
+	}
+	data := []byte("found across hops")
+	key, err := nodes[n-1].Put(data)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	holders := 0
+	for _, k := range nodes {
+		if _, ok := k.loadLocal(key); ok {
+			holders++
+		}
+	}
+	if holders != n {
+		t.Fatalf("Put reached %d of %d holders", holders, n)
+	}
+	if val, _, err := nodes[1].Get(key); err != nil || string(val) != string(data) {
+		t.Fatalf("Get = %q, %v; want %q", val, err, data)
+	}
+}
+
+// A PONG to PingWait records a plausible round-trip time for that address.
+func TestPingWait_RecordsRTT(t *testing.T) {
+	a, _ := newNode(t)
+	_, bMe := newNode(t)
+	if _, ok := a.LastRTT(bMe.Address); ok {
+		t.Fatalf("RTT recorded before any ping")
+	}
+	if !a.network.PingWait(&bMe, time.Second) {
+		t.Fatalf("PingWait to b failed")
+	}
+	rtt, ok := a.LastRTT(bMe.Address)
+	if !ok || rtt <= 0 || rtt >= time.Second {
+		t.Fatalf("LastRTT = %v, %v; want a non-zero sub-second RTT", rtt, ok)
+	}
+}
+
+// Pings are counted as sent on the caller, received on the callee, and a
+// ping nobody answers as a timeout; the PONGs themselves aren't requests.
+func TestNetworkStats_CountsPingsAndTimeouts(t *testing.T) {
+	a, _ := newNode(t)
+	b, bMe := newNode(t)
+	dead := NewContact(NewKademliaID(randIDHex(t)), net.JoinHostPort("127.0.0.1", itoa(freeUDPPort(t))))
+
+	for i := 0; i < 3; i++ {
+		if !a.network.PingWait(&bMe, time.Second) {
+			t.Fatalf("ping %d to b failed", i)
+		}
+	}
+	if a.network.PingWait(&dead, 100*time.Millisecond) {
+		t.Fatalf("ping to a closed port succeeded")
+	}
+
+	got := a.network.Stats()
+	if got.PingsSent != 4 || got.Timeouts != 1 || got.PingsReceived != 0 {
+		t.Fatalf("a: %+v, want 4 pings sent, 1 timeout, none received", got)
+	}
+	if got := b.RPCStats(); got.PingsReceived != 3 || got.PingsSent != 0 || got.Timeouts != 0 {
+		t.Fatalf("b: %+v, want 3 pings received and nothing sent", got)
+	}
+}
+
+// captureLogger records formatted log lines per level.
+type captureLogger struct {
+	mu          sync.Mutex
+	debug, info []string
+}
+
+func (l *captureLogger) Debugf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.debug = append(l.debug, fmt.Sprintf(format, args...))
+}
+
+func (l *captureLogger) Infof(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.info = append(l.info, fmt.Sprintf(format, args...))
+}
+
+// A PING is debug chatter on both ends and nothing at Info level.
+func TestLogger_PingLogsAtDebugOnly(t *testing.T) {
+	a, _ := newNode(t)
+	b, bMe := newNode(t)
+	la, lb := &captureLogger{}, &captureLogger{}
+	a.SetLogger(la)
+	b.SetLogger(lb)
+
+	if !a.network.PingWait(&bMe, time.Second) {
+		t.Fatalf("ping to b failed")
+	}
+	for name, l := range map[string]*captureLogger{"a": la, "b": lb} {
+		l.mu.Lock()
+		debug, info := strings.Join(l.debug, "\n"), l.info
+		l.mu.Unlock()
+		if !strings.Contains(debug, "PING") {
+			t.Fatalf("%s: no debug line mentions the PING:\n%s", name, debug)
+		}
+		if len(info) != 0 {
+			t.Fatalf("%s: info lines for a plain PING: %q", name, info)
+		}
+	}
+}
+
+// A far bucket nothing was ever added to gets populated by the refresher:
+// its lookup asks our only neighbour, which knows a peer in that range.
+func TestBucketRefresh_PopulatesEmptyFarBucket(t *testing.T) {
+	a, _ := m2NewNodeWithID(t, "00"+strings.Repeat("0", 38), WithBucketRefresh(50*time.Millisecond, time.Hour))
+	c, cMe := m2NewNodeWithID(t, "01"+strings.Repeat("0", 38))
+	_, bMe := m2NewNodeWithID(t, "80"+strings.Repeat("0", 38))
+	a.routingTable.AddContact(cMe)
+	c.routingTable.AddContact(bMe)
+	if n := len(a.routingTable.Dump()[0]); n != 0 {
+		t.Fatalf("bucket 0 starts with %d contacts, want 0", n)
+	}
+
+	if !waitUntil(t, 3*time.Second, func() bool { return len(a.routingTable.Dump()[0]) > 0 }) {
+		t.Fatalf("bucket 0 still empty after refresh ticks")
+	}
+	if !hasContactWithAddress(a, bMe.Address) {
+		t.Fatalf("refresh did not learn the peer in bucket 0 (%s)", bMe.Address)
+	}
+	// The same tick refreshes every bucket out to c's (7).
+	if !waitUntil(t, 3*time.Second, func() bool {
+		stale := a.routingTable.StaleBuckets(time.Hour)
+		return len(stale) == 0 || stale[0] > 7
+	}) {
+		t.Fatalf("buckets 0..7 still stale: %v", a.routingTable.StaleBuckets(time.Hour))
+	}
+}
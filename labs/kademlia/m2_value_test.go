@@ -1,12 +1,18 @@
 package kademlia
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"net"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -16,7 +22,7 @@ import (
 //
 
 // m2RandIDHex returns a random 160-bit hex ID (40 hex chars).
-func m2RandIDHex(t *testing.T) string {
+func m2RandIDHex(t testing.TB) string {
 	t.Helper()
 	b := make([]byte, IDLength)
 	if _, err := rand.Read(b); err != nil {
@@ -26,7 +32,7 @@ func m2RandIDHex(t *testing.T) string {
 }
 
 // m2FreeUDPPort finds a free UDP port on localhost.
-func m2FreeUDPPort(t *testing.T) int {
+func m2FreeUDPPort(t testing.TB) int {
 	t.Helper()
 	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
 	if err != nil {
@@ -37,18 +43,23 @@ func m2FreeUDPPort(t *testing.T) int {
 }
 
 // m2NewNode spins up a single node bound to 127.0.0.1:<free-port>.
-func m2NewNode(t *testing.T) (*Kademlia, Contact) {
+func m2NewNode(t testing.TB, opts ...Option) (*Kademlia, Contact) {
+	t.Helper()
+	return m2NewNodeWithID(t, m2RandIDHex(t), opts...)
+}
+
+// m2NewNodeWithID is m2NewNode with a fixed ID, e.g. to "restart" a node.
+func m2NewNodeWithID(t testing.TB, idHex string, opts ...Option) (*Kademlia, Contact) {
 	t.Helper()
 	ip := "127.0.0.1"
 	port := m2FreeUDPPort(t)
-	idHex := m2RandIDHex(t)
 
 	idBytes, _ := hex.DecodeString(idHex)
 	var id KademliaID
 	copy(id[:], idBytes)
 	me := NewContact(&id, net.JoinHostPort(ip, strconv.Itoa(port)))
 
-	k, err := NewKademlia(me, ip, port)
+	k, err := NewKademlia(me, ip, port, opts...)
 	if err != nil {
 		t.Fatalf("NewKademlia: %v", err)
 	}
@@ -69,9 +80,29 @@ func m2WaitUntil(t *testing.T, d time.Duration, cond func() bool) bool {
 	return cond()
 }
 
+// m2CloseCluster shuts nodes down in phases so no node sends to a peer that
+// is already gone: stop every republisher, let outstanding RPCs finish (or
+// time out), then close all sockets. Safe to call more than once.
+func m2CloseCluster(nodes []*Kademlia) {
+	for _, n := range nodes {
+		n.stopBackground()
+	}
+	// Every waiter gives up within its RPC timeout, so one shared deadline is enough.
+	deadline := time.Now().Add(nodes[0].timeoutRPC)
+	for _, n := range nodes {
+		for n.network.pending() > 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	for _, n := range nodes {
+		_ = n.Close()
+	}
+}
+
 // m2Cluster creates n nodes, uses node[0] as bootstrap, and joins the rest.
-// Returns the nodes and their contacts. All nodes are kept open via t.Cleanup.
-func m2Cluster(t *testing.T, n int) ([]*Kademlia, []Contact) {
+// Returns the nodes and their contacts. The cluster is torn down with
+// m2CloseCluster via t.Cleanup (before the per-node Close cleanups).
+func m2Cluster(t testing.TB, n int) ([]*Kademlia, []Contact) {
 	t.Helper()
 	if n < 2 {
 		t.Fatalf("cluster size must be >= 2")
@@ -90,8 +121,7 @@ func m2Cluster(t *testing.T, n int) ([]*Kademlia, []Contact) {
 			t.Fatalf("Join node %d: %v", i, err)
 		}
 	}
-	// Give the UDP gossip a brief moment to settle on localhost.
-	time.Sleep(150 * time.Millisecond)
+	t.Cleanup(func() { m2CloseCluster(nodes) })
 	return nodes, contacts
 }
 
@@ -138,7 +168,7 @@ func m2KClosestPeersToKey(originIdx int, contacts []Contact, keyHex string) []Co
 // Returns true if the peer directly returned the value.
 func m2QueryHasValue(t *testing.T, reqNode *Kademlia, peer *Contact, keyHex string) bool {
 	t.Helper()
-	val, _, err := reqNode.network.sendFindValueTo(peer, keyHex, 900*time.Millisecond)
+	val, _, err := reqNode.network.sendFindValueTo(peer, keyHex, 900*time.Millisecond, nil)
 	if err != nil {
 		// Timeout or network error: treat as not having the value here.
 		return false
@@ -404,6 +434,110 @@ func TestM2_LocalCachingOnGet(t *testing.T) {
 	}
 }
 
+// TestM2_GetCachingDisabled
+// - A node built with WithGetCaching(false) joins after the Put, so it holds no replica.
+// - Its Get still returns the value, but afterwards it must NOT serve the value itself.
+func TestM2_GetCachingDisabled(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 5)
+	data := []byte("read-only-consumer")
+	key, err := nodes[1].Put(data)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	reader, readerMe := m2NewNode(t, WithGetCaching(false))
+	if err := reader.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	if m2QueryHasValue(t, nodes[0], &readerMe, key) {
+		t.Fatalf("reader unexpectedly has value before Get")
+	}
+
+	val, from, err := reader.Get(key)
+	if err != nil || string(val) != string(data) || from == nil {
+		t.Fatalf("reader Get failed: val=%q err=%v from=%v", string(val), err, from)
+	}
+	if m2QueryHasValue(t, nodes[0], &readerMe, key) {
+		t.Fatalf("reader with caching disabled served the value after Get")
+	}
+}
+
+// TestM2_Rebalance_PushesToNewClosest
+// - Put into a small cluster, then join more nodes (no republish runs).
+// - Rebalance must push the key to the newcomers, and a second pass finds nothing to repair.
+func TestM2_Rebalance_PushesToNewClosest(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 3)
+	origin := nodes[1]
+	data := []byte("rebalance-me")
+	key, err := origin.Put(data)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	var late []*Kademlia
+	for i := 0; i < 3; i++ {
+		n, _ := m2NewNode(t)
+		if err := n.Join(&contacts[0]); err != nil {
+			t.Fatalf("Join newcomer %d: %v", i, err)
+		}
+		late = append(late, n)
+	}
+	for i, n := range late {
+		if _, ok := n.loadLocal(key); ok {
+			t.Fatalf("newcomer %d has the value before Rebalance", i)
+		}
+	}
+
+	if got := origin.Rebalance(); got != 1 {
+		t.Fatalf("Rebalance repaired %d keys, want 1", got)
+	}
+	for i, n := range late {
+		if v, ok := n.loadLocal(key); !ok || string(v) != string(data) {
+			t.Fatalf("newcomer %d missing value after Rebalance", i)
+		}
+	}
+	if got := origin.Rebalance(); got != 0 {
+		t.Fatalf("second Rebalance repaired %d keys, want 0", got)
+	}
+}
+
+// TestM2_PutKeyEqualsOwnID
+// - The origin's ID is chosen as sha1(data), so the key is the node's own ID.
+// - Put must still replicate to peers (never to itself) and the origin is responsible.
+// - Gets from the origin and from a peer both return the value.
+func TestM2_PutKeyEqualsOwnID(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 5)
+	data := []byte("i am my own key")
+	key := m2KeyHex(data)
+
+	self, selfMe := m2NewNodeWithID(t, key)
+	if err := self.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	res, err := self.PutWithResult(data)
+	if err != nil || res.Key != key {
+		t.Fatalf("Put: key=%s err=%v", res.Key, err)
+	}
+	if res.State != Complete || len(res.Targets) == 0 {
+		t.Fatalf("Put state=%s targets=%d, want complete with peers", res.State, len(res.Targets))
+	}
+	for _, c := range res.Targets {
+		if c.Address == selfMe.Address || c.ID.Equals(selfMe.ID) {
+			t.Fatalf("Put listed itself as a replica target")
+		}
+	}
+	if mine, err := self.IsResponsibleFor(key); err != nil || !mine {
+		t.Fatalf("IsResponsibleFor(own ID) = %v, %v; want true", mine, err)
+	}
+
+	if val, from, err := self.Get(key); err != nil || string(val) != string(data) || from.Address != selfMe.Address {
+		t.Fatalf("origin Get: val=%q from=%v err=%v", val, from, err)
+	}
+	if val, _, err := nodes[2].Get(key); err != nil || string(val) != string(data) {
+		t.Fatalf("peer Get: val=%q err=%v", val, err)
+	}
+}
+
 // TestM2_IdempotentPut
 // - Put the same data twice; ensure we don't create weirdness.
 // - Verify that the K-closest peers + origin still have it (no change to the expected set).
@@ -446,6 +580,161 @@ func TestM2_IdempotentPut(t *testing.T) {
 	}
 }
 
+// TestM2_IdempotentPut_SkipsRedundantStores
+// - A second Put of identical bytes only probes replicas, so it sends fewer STOREs.
+// - With WithForceReplicate the second Put replicates in full again.
+func TestM2_IdempotentPut_SkipsRedundantStores(t *testing.T) {
+	_, contacts := m2Cluster(t, 5)
+	storesPerPut := func(k *Kademlia, data []byte) (first, second int64) {
+		before := k.network.sent.get(msgStore)
+		key1, err := k.Put(data)
+		if err != nil {
+			t.Fatalf("Put#1: %v", err)
+		}
+		mid := k.network.sent.get(msgStore)
+		key2, err := k.Put(data)
+		if err != nil || key2 != key1 {
+			t.Fatalf("Put#2: key=%s want %s err=%v", key2, key1, err)
+		}
+		return mid - before, k.network.sent.get(msgStore) - mid
+	}
+
+	plain, _ := m2NewNode(t)
+	forced, _ := m2NewNode(t, WithForceReplicate(true))
+	for _, n := range []*Kademlia{plain, forced} {
+		if err := n.Join(&contacts[0]); err != nil {
+			t.Fatalf("Join: %v", err)
+		}
+	}
+
+	first, second := storesPerPut(plain, []byte("put me twice"))
+	if first == 0 || second >= first {
+		t.Fatalf("identical re-Put sent %d STOREs after %d; want fewer", second, first)
+	}
+	first, second = storesPerPut(forced, []byte("force me twice"))
+	if first == 0 || second != first {
+		t.Fatalf("forced re-Put sent %d STOREs, want %d like the first", second, first)
+	}
+}
+
+// TestM2_GetWithStats_CountsMessages
+// - Two nodes: the holder and a reader whose table holds only the holder.
+// - The reader's Get is one FIND_VALUE out and one reply in (no path cache: the source is the only node queried).
+// - A repeat Get is a local hit and costs nothing.
+func TestM2_GetWithStats_CountsMessages(t *testing.T) {
+	holder, holderMe := m2NewNode(t)
+	key, err := holder.Put([]byte("counted"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	reader, _ := m2NewNode(t)
+	if err := reader.Join(&holderMe); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+
+	val, _, st, err := reader.GetWithStats(key)
+	if err != nil || string(val) != "counted" {
+		t.Fatalf("Get: val=%q err=%v", val, err)
+	}
+	if st.SentMsgs != 1 || st.RecvMsgs != 1 {
+		t.Fatalf("stats = %+v, want 1 sent and 1 received", st)
+	}
+	if st.SentBytes == 0 || st.RecvBytes <= int64(len("counted")) {
+		t.Fatalf("byte counts implausible: %+v", st)
+	}
+
+	if _, _, st, _ := reader.GetWithStats(key); st != (OpStats{}) {
+		t.Fatalf("local hit reported traffic: %+v", st)
+	}
+}
+
+// TestM2_PrefixedKeys_RoundTrip
+// - A node with WithPrefixedKeys returns KeySchemeSHA1 + the usual digest.
+// - The prefixed key and the bare digest both resolve on other nodes.
+// - A prefix naming another scheme (SHA-256's "1220") is refused, not misread.
+func TestM2_PrefixedKeys_RoundTrip(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 4)
+	origin, _ := m2NewNode(t, WithPrefixedKeys(true))
+	if err := origin.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	data := []byte("self-describing key")
+	key, err := origin.Put(data)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if want := KeySchemeSHA1 + m2KeyHex(data); key != want {
+		t.Fatalf("Put key = %s, want %s", key, want)
+	}
+
+	for _, k := range []string{key, m2KeyHex(data)} {
+		val, _, err := nodes[2].Get(k)
+		if err != nil || string(val) != string(data) {
+			t.Fatalf("Get(%s): val=%q err=%v", k, val, err)
+		}
+	}
+	if _, _, err := nodes[2].Get("1220" + m2KeyHex(data)); !errors.Is(err, ErrUnsupportedScheme) {
+		t.Fatalf("Get with a SHA-256 prefix: err=%v, want ErrUnsupportedScheme", err)
+	}
+	if val, _, err := nodes[2].Get(strings.ToUpper(key)); err != nil || string(val) != string(data) {
+		t.Fatalf("Get(upper-case key): val=%q err=%v", val, err)
+	}
+
+	// A STORE spelled with the prefix and in upper case, straight off the
+	// wire, is filed under the canonical key.
+	other := []byte("spelled differently")
+	wire := KeySchemeSHA1 + strings.ToUpper(m2KeyHex(other))
+	if err := nodes[0].network.sendStoreTo(&contacts[1], wire, other, time.Second, nil); err != nil {
+		t.Fatalf("STORE %s: %v", wire, err)
+	}
+	var held []string
+	for _, k := range nodes[1].LocalKeys() {
+		if strings.HasSuffix(strings.ToLower(k), m2KeyHex(other)) {
+			held = append(held, k)
+		}
+	}
+	if len(held) != 1 || held[0] != m2KeyHex(other) {
+		t.Fatalf("STORE %s held under %v, want only %s", wire, held, m2KeyHex(other))
+	}
+}
+
+// TestM2_LookupTrace_ReportsRoundsAndStopReason
+// - A node built with WithLookupTrace logs every round of its Get and LookupContact.
+// - The Get trace ends with "value found"; the node lookup ends for a convergence reason.
+func TestM2_LookupTrace_ReportsRoundsAndStopReason(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 5)
+	key, err := nodes[1].Put([]byte("trace me"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	var out safeBuffer
+	tracer, _ := m2NewNode(t, WithLookupTrace(&out))
+	if err := tracer.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+
+	if _, _, err := tracer.Get(key); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{"[TRACE get " + key[:8] + "]", "round 1 batch", "stop after", stopFound} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("Get trace missing %q:\n%s", want, got)
+		}
+	}
+
+	out.b.Reset()
+	target := contacts[3]
+	tracer.LookupContact(&target)
+	got = out.String()
+	if !strings.Contains(got, "[TRACE lookup "+target.ID.String()[:8]+"]") || !strings.Contains(got, "best before=") {
+		t.Fatalf("lookup trace missing rounds:\n%s", got)
+	}
+	if !strings.Contains(got, stopConverged) && !strings.Contains(got, stopEmptyBatch) {
+		t.Fatalf("lookup trace has no convergence stop reason:\n%s", got)
+	}
+}
+
 // TestM2_ConcurrentGets
 // - Spawn multiple concurrent Get() calls from different nodes; all must succeed.
 func TestM2_ConcurrentGets(t *testing.T) {
@@ -489,7 +778,1907 @@ func TestM2_ConcurrentGets(t *testing.T) {
 	}
 }
 
+// Twenty concurrent Gets for one key on one node share a single lookup. A
+// silent contact sitting on the key holds the first round open for an RPC
+// timeout, so every Get starts while that lookup is still in flight.
+func TestM2_ConcurrentGets_ShareOneLookup(t *testing.T) {
+	nodes, _ := m2Cluster(t, 6)
+	data := []byte("asked for twenty times")
+	key, err := nodes[1].Put(data)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	reader := nodes[4]
+	if _, ok := reader.loadLocal(key); ok {
+		reader.evictLocal(key, Forgotten) // must go to the network
+	}
+	keyID, _ := parseKeyHex(key)
+	reader.routingTable.AddContact(NewContact(keyID, "127.0.0.1:"+strconv.Itoa(m2FreeUDPPort(t))))
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, _, err := reader.Get(key)
+			if err == nil && !bytes.Equal(val, data) {
+				err = &mismatchErr{}
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent Get: %v", err)
+		}
+	}
+	if got := reader.valueLookups.Load(); got != 1 {
+		t.Fatalf("%d concurrent Gets ran %d lookups, want 1", n, got)
+	}
+}
+
+// A shared execution keeps running while any caller still waits for it and
+// is cancelled once the last one gives up; the next caller starts afresh.
+func TestFlightGroup_CancelsOnceEveryWaiterLeaves(t *testing.T) {
+	var g flightGroup
+	stopped := make(chan struct{})
+	work := func(ctx context.Context) any {
+		<-ctx.Done()
+		close(stopped)
+		return nil
+	}
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() { _, _, err := g.doCtx(ctx1, "k", work); errs <- err }()
+	time.Sleep(20 * time.Millisecond) // let the first caller start the work
+	go func() { _, _, err := g.doCtx(ctx2, "k", work); errs <- err }()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel1()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("first caller: err = %v, want context.Canceled", err)
+	}
+	select {
+	case <-stopped:
+		t.Fatalf("work cancelled while a caller still waits")
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel2()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("second caller: err = %v, want context.Canceled", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("work still running after every caller left")
+	}
+
+	v, shared, err := g.doCtx(context.Background(), "k", func(context.Context) any { return 1 })
+	if err != nil || shared || v != 1 {
+		t.Fatalf("next call = %v (shared=%v, err=%v), want a fresh run", v, shared, err)
+	}
+}
+
 // mismatchErr is a tiny typed error to differentiate data mismatch.
 type mismatchErr struct{}
 
 func (m *mismatchErr) Error() string { return "value mismatch" }
+
+// m2RegionPayloads returns n distinct payloads whose SHA-1 keys all share the
+// first byte, i.e. land in the same neighborhood of the ID space.
+// seq lets callers draw fresh payloads on every call.
+func m2RegionPayloads(n int, prefix byte, seq *int) [][]byte {
+	out := make([][]byte, 0, n)
+	for len(out) < n {
+		p := []byte("bulk-put-" + strconv.Itoa(*seq))
+		*seq++
+		if sum := sha1.Sum(p); sum[0] == prefix {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// m2BenchBulkPut fires batches of concurrent same-region Puts from one origin
+// and reports how many FIND_NODE messages the origin sent per batch.
+func m2BenchBulkPut(b *testing.B, coalesceBits int) {
+	nodes, _ := m2Cluster(b, 8)
+	origin := nodes[1]
+	origin.coalesceBits = coalesceBits
+
+	const batch = 16
+	seq := 0
+	var findNodes int64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		payloads := m2RegionPayloads(batch, 0x42, &seq)
+		before := origin.network.sent.get(msgFindNode)
+		b.StartTimer()
+
+		var wg sync.WaitGroup
+		for _, p := range payloads {
+			wg.Add(1)
+			go func(data []byte) {
+				defer wg.Done()
+				if _, err := origin.Put(data); err != nil {
+					b.Errorf("Put: %v", err)
+				}
+			}(p)
+		}
+		wg.Wait()
+		findNodes += origin.network.sent.get(msgFindNode) - before
+	}
+	b.ReportMetric(float64(findNodes)/float64(b.N), "findnode/batch")
+}
+
+// Compare FIND_NODE traffic for bulk Puts with and without region coalescing:
+//
+//	go test -run '^$' -bench BulkPut ./kademlia
+func BenchmarkM2_BulkPut_Coalesced(b *testing.B)   { m2BenchBulkPut(b, 8) }
+func BenchmarkM2_BulkPut_Uncoalesced(b *testing.B) { m2BenchBulkPut(b, 0) }
+
+// TestM2_RepublishDisabled_NoRepublishTraffic
+// - Two origins with a very short republish interval; one has republish off.
+// - Over a few intervals the enabled one re-sends STOREs, the disabled one sends none.
+func TestM2_RepublishDisabled_NoRepublishTraffic(t *testing.T) {
+	_, peerMe := m2NewNode(t)
+	on, _ := m2NewNode(t, WithRepublishInterval(20*time.Millisecond))
+	off, _ := m2NewNode(t, WithRepublish(false), WithRepublishInterval(20*time.Millisecond))
+
+	for _, n := range []*Kademlia{on, off} {
+		if err := n.Join(&peerMe); err != nil {
+			t.Fatalf("Join: %v", err)
+		}
+		if _, err := n.Put([]byte("ephemeral")); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if _, ok := off.originKeys[m2KeyHex([]byte("ephemeral"))]; !ok {
+		t.Fatalf("origin key should still be tracked with republish disabled")
+	}
+
+	onBefore := on.network.sent.get(msgStore)
+	offBefore := off.network.sent.get(msgStore)
+	time.Sleep(200 * time.Millisecond)
+
+	if got := off.network.sent.get(msgStore); got != offBefore {
+		t.Fatalf("republish disabled but %d STOREs were sent", got-offBefore)
+	}
+	if got := on.network.sent.get(msgStore); got == onBefore {
+		t.Fatalf("control node with republish enabled sent no STOREs")
+	}
+}
+
+// TestM2_VerifyContent_SkipsTamperedReplica
+// - One replica holds tampered bytes under the key.
+// - A verifying reader must ignore it and return (and cache) the genuine copy.
+func TestM2_VerifyContent_SkipsTamperedReplica(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 2)
+	origin, bad := nodes[0], nodes[1]
+
+	data := []byte("authentic bytes")
+	key, err := origin.Put(data)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	bad.storeLocal(key, []byte("tampered bytes"))
+
+	reader, _ := m2NewNode(t, WithVerifyContent(true))
+	if err := reader.Join(&contacts[1]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	if !hasContactWithAddress(reader, contacts[0].Address) {
+		t.Fatalf("reader should know both replicas before Get")
+	}
+
+	val, from, err := reader.Get(key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(val) != string(data) {
+		t.Fatalf("Get returned %q, want the genuine %q", val, data)
+	}
+	if from == nil || from.Address != contacts[0].Address {
+		t.Fatalf("value should come from the honest origin, got %v", from)
+	}
+	if v, _ := reader.loadLocal(key); string(v) != string(data) {
+		t.Fatalf("reader cached %q instead of the genuine value", v)
+	}
+}
+
+// ProbeKey reports presence and size of a large value without any FIND_VALUE
+// (and so without the bytes crossing the wire).
+func TestM2_ProbeKey_LargeValueWithoutTransfer(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 5)
+
+	large := bytes.Repeat([]byte("probe-me "), 3000) // ~27 KB, still one datagram
+	keyHex, err := nodes[1].Put(large)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// Every cluster member is among the K closest, so probe from a late joiner.
+	prober, _ := m2NewNode(t)
+	if err := prober.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+
+	before := prober.network.sent.get(msgFindValue)
+	exists, size, from, err := prober.ProbeKey(keyHex)
+	if err != nil {
+		t.Fatalf("ProbeKey: %v", err)
+	}
+	if !exists || size != len(large) {
+		t.Fatalf("ProbeKey = (%v, %d), want (true, %d)", exists, size, len(large))
+	}
+	if from == nil || from.Address == prober.me.Address {
+		t.Fatalf("expected a remote holder, got %v", from)
+	}
+	if got := prober.network.sent.get(msgFindValue) - before; got != 0 {
+		t.Fatalf("ProbeKey sent %d FIND_VALUE messages, want 0", got)
+	}
+	if _, ok := prober.loadLocal(keyHex); ok {
+		t.Fatalf("probe must not cache the value locally")
+	}
+
+	missing := m2KeyHex([]byte("never stored"))
+	if exists, _, _, err := prober.ProbeKey(missing); err != nil || exists {
+		t.Fatalf("ProbeKey(missing) = (%v, %v), want (false, nil)", exists, err)
+	}
+	if _, _, _, err := prober.ProbeKey("abc"); err == nil {
+		t.Fatalf("expected error for malformed key")
+	}
+}
+
+// Many lookups at once must share the node-wide RPC budget, not α each.
+func TestM2_RPCBudget_CapsConcurrentLookups(t *testing.T) {
+	const budget = 2
+	nodes, contacts := m2Cluster(t, 6)
+	node, _ := m2NewNode(t, WithRPCBudget(budget))
+	if err := node.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	stored, err := nodes[2].Put([]byte("budget-existing"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				_, _, _ = node.Get(m2KeyHex([]byte("budget-missing-" + strconv.Itoa(i))))
+				return
+			}
+			_, _ = node.Put([]byte("budget-put-" + strconv.Itoa(i)))
+		}(i)
+	}
+	wg.Add(1)
+	go func() { defer wg.Done(); _, _, _ = node.Get(stored) }()
+	wg.Wait()
+
+	peak := node.network.peak.Load()
+	t.Logf("peak in-flight=%d (budget=%d)", peak, budget)
+	if peak > budget {
+		t.Fatalf("in-flight peaked at %d, budget is %d", peak, budget)
+	}
+	if peak == 0 {
+		t.Fatalf("expected some budgeted RPCs to run")
+	}
+	if n := node.InflightCount(); n != 0 {
+		t.Fatalf("InflightCount after all lookups = %d, want 0", n)
+	}
+}
+
+// LookupDataResult is the skeleton entry point with Get's results.
+func TestM2_LookupDataResult(t *testing.T) {
+	nodes, _ := m2Cluster(t, 3)
+	keyHex, err := nodes[0].Put([]byte("lookup-data"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	val, from, err := nodes[2].LookupDataResult(keyHex)
+	if err != nil || string(val) != "lookup-data" || from == nil {
+		t.Fatalf("LookupDataResult = (%q, %v, %v)", val, from, err)
+	}
+	if _, _, err := nodes[2].LookupDataResult(m2KeyHex([]byte("absent"))); err == nil {
+		t.Fatalf("expected not-found error")
+	}
+	nodes[1].LookupData(keyHex) // void variant: must not panic
+}
+
+// An origin cut off from every peer still serves its own keys instantly.
+func TestM2_OriginGet_PartitionedReturnsLocalInstantly(t *testing.T) {
+	nodes, _ := m2Cluster(t, 4)
+	origin := nodes[0]
+	keyHex, err := origin.Put([]byte("origin-owned"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// Partition: every peer goes away but stays in origin's routing table.
+	for _, n := range nodes[1:] {
+		_ = n.Close()
+	}
+	// Even with verification on (which normally gates the local fast path).
+	origin.verifyContent = true
+
+	start := time.Now()
+	val, from, err := origin.Get(keyHex)
+	elapsed := time.Since(start)
+	if err != nil || string(val) != "origin-owned" {
+		t.Fatalf("Get = (%q, %v)", val, err)
+	}
+	if from == nil || from.Address != origin.me.Address {
+		t.Fatalf("expected origin to serve itself, got %v", from)
+	}
+	if elapsed > origin.timeoutRPC/4 {
+		t.Fatalf("origin Get took %v; should not touch the network", elapsed)
+	}
+	if v, _, err := origin.LookupDataResult(keyHex); err != nil || string(v) != "origin-owned" {
+		t.Fatalf("LookupDataResult = (%q, %v)", v, err)
+	}
+}
+
+// Put reports what is guaranteed at return: LocalOnly, Pending or Complete.
+func TestM2_PutReplicationState(t *testing.T) {
+	t.Run("LocalOnlyWithoutPeers", func(t *testing.T) {
+		for _, async := range []bool{false, true} {
+			k, _ := m2NewNode(t, WithAsyncReplication(async))
+			res, err := k.PutWithResult([]byte("alone"))
+			if err != nil || res.State != LocalOnly {
+				t.Fatalf("async=%v: state=%v err=%v, want local_only", async, res.State, err)
+			}
+		}
+	})
+
+	t.Run("CompleteWhenSync", func(t *testing.T) {
+		nodes, _ := m2Cluster(t, 3)
+		res, err := nodes[0].PutWithResult([]byte("sync-state"))
+		if err != nil || res.State != Complete {
+			t.Fatalf("state=%v err=%v, want complete", res.State, err)
+		}
+		// Complete means at least one peer already holds it: no polling needed.
+		if !m2NodeHasValueNow(nodes[1:], res.Key) {
+			t.Fatalf("complete Put but no peer holds the value")
+		}
+	})
+
+	t.Run("PendingWhenAsync", func(t *testing.T) {
+		nodes, contacts := m2Cluster(t, 3)
+		k, _ := m2NewNode(t, WithAsyncReplication(true))
+		if err := k.Join(&contacts[0]); err != nil {
+			t.Fatalf("Join: %v", err)
+		}
+		res, err := k.PutWithResult([]byte("async-state"))
+		if err != nil || res.State != Pending || res.Targets != nil {
+			t.Fatalf("state=%v targets=%v err=%v, want pending", res.State, res.Targets, err)
+		}
+		if !m2WaitUntil(t, 2*time.Second, func() bool { return m2NodeHasValueNow(nodes, res.Key) }) {
+			t.Fatalf("async replication never reached the peer")
+		}
+	})
+}
+
+// m2NodeHasValueNow reports whether any of nodes holds keyHex right now.
+func m2NodeHasValueNow(nodes []*Kademlia, keyHex string) bool {
+	for _, n := range nodes {
+		if _, ok := n.loadLocal(keyHex); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Replica STOREs that arrive while a fresh joiner is warming up are held back
+// and only become visible once warmup ends.
+func TestM2_JoinWarmup_DefersReplicaStores(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 3)
+	const warmup = 400 * time.Millisecond
+	fresh, freshC := m2NewNode(t, WithJoinWarmup(warmup, 10)) // only 3 peers exist
+	if err := fresh.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+
+	value := []byte("arrived too early")
+	keyHex := m2KeyHex(value)
+	if err := nodes[1].network.sendStoreTo(&freshC, keyHex, value, time.Second, nil); err != nil {
+		t.Fatalf("STORE during warmup should still be acked: %v", err)
+	}
+	if _, ok := fresh.loadLocal(keyHex); ok {
+		t.Fatalf("STORE during warmup was accepted immediately")
+	}
+	if m2QueryHasValue(t, nodes[1], &freshC, keyHex) {
+		t.Fatalf("provisional value served during warmup")
+	}
+
+	if !m2WaitHasLocalValue(t, fresh, keyHex, warmup+time.Second) {
+		t.Fatalf("held value not accepted after warmup")
+	}
+
+	// Once warm, STOREs land directly.
+	later := []byte("after warmup")
+	if err := nodes[1].network.sendStoreTo(&freshC, m2KeyHex(later), later, time.Second, nil); err != nil {
+		t.Fatalf("STORE after warmup: %v", err)
+	}
+	if _, ok := fresh.loadLocal(m2KeyHex(later)); !ok {
+		t.Fatalf("STORE after warmup not stored immediately")
+	}
+}
+
+// A STORE held back during warmup doesn't touch the provenance of the copy
+// the node already serves; its own is recorded once it is promoted.
+func TestM2_JoinWarmup_ProvenanceFollowsStoredCopy(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 3)
+	const warmup = 300 * time.Millisecond
+	fresh, freshC := m2NewNode(t, WithJoinWarmup(warmup, 10))
+	if err := fresh.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	value := []byte("cached, then stored")
+	keyHex := m2KeyHex(value)
+	fresh.storeLocalFrom(keyHex, value, time.Hour, Provenance{Source: Fetched, Peer: contacts[2]})
+
+	if err := nodes[1].network.sendStoreTo(&freshC, keyHex, value, time.Second, nil); err != nil {
+		t.Fatalf("STORE during warmup: %v", err)
+	}
+	if p, ok := fresh.ValueProvenance(keyHex); !ok || p.Source != Fetched || p.Peer.Address != contacts[2].Address {
+		t.Fatalf("provenance during warmup = %v (ok=%v), want the cached copy's", p, ok)
+	}
+	if !m2WaitUntil(t, warmup+time.Second, func() bool {
+		p, ok := fresh.ValueProvenance(keyHex)
+		return ok && p.Source == Stored && p.Peer.Address == contacts[1].Address
+	}) {
+		p, _ := fresh.ValueProvenance(keyHex)
+		t.Fatalf("provenance after warmup = %v, want stored by %s", p, contacts[1].Address)
+	}
+}
+
+// Replicas held during warmup count toward the store's capacity, so a
+// joiner can't be pushed past it before the held values are promoted.
+func TestM2_JoinWarmup_HeldReplicasCountTowardCapacity(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 3)
+	fresh, freshC := m2NewNode(t, WithJoinWarmup(time.Hour, 10), WithStoreCapacity(2))
+	if err := fresh.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	for _, v := range [][]byte{[]byte("held 0"), []byte("held 1")} {
+		if err := nodes[1].network.sendStoreTo(&freshC, m2KeyHex(v), v, time.Second, nil); err != nil {
+			t.Fatalf("STORE %q during warmup: %v", v, err)
+		}
+	}
+	over := []byte("one too many")
+	err := nodes[1].network.sendStoreTo(&freshC, m2KeyHex(over), over, time.Second, nil)
+	var rej *StoreRejectedError
+	if !errors.As(err, &rej) || rej.Reason != rejectFull {
+		t.Fatalf("STORE past the capacity during warmup: err = %v, want %q", err, rejectFull)
+	}
+	// Re-sending a held key replaces it rather than taking another slot.
+	again := []byte("held 0")
+	if err := nodes[1].network.sendStoreTo(&freshC, m2KeyHex(again), again, time.Second, nil); err != nil {
+		t.Fatalf("re-STORE of a held key: %v", err)
+	}
+}
+
+// Reaching the peer threshold ends warmup before the duration expires.
+func TestM2_JoinWarmup_EndsAtPeerThreshold(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 3)
+	fresh, freshC := m2NewNode(t, WithJoinWarmup(time.Hour, 2))
+	if err := fresh.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	value := []byte("neighbourhood is populated")
+	if err := nodes[2].network.sendStoreTo(&freshC, m2KeyHex(value), value, time.Second, nil); err != nil {
+		t.Fatalf("STORE: %v", err)
+	}
+	if _, ok := fresh.loadLocal(m2KeyHex(value)); !ok {
+		t.Fatalf("STORE should be accepted once the table has enough peers")
+	}
+}
+
+// PutTo places the value on exactly the chosen nodes (plus the origin), and
+// republishing does not spread it further.
+func TestM2_PutTo_ExactReplicaSet(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 5)
+	origin := nodes[0]
+	chosen := map[int]bool{0: true, 2: true, 4: true}
+
+	data := []byte("exactly here")
+	keyHex, err := origin.PutTo(data, []Contact{contacts[2], contacts[4]})
+	if err != nil {
+		t.Fatalf("PutTo: %v", err)
+	}
+	if keyHex != m2KeyHex(data) {
+		t.Fatalf("PutTo key = %s, want sha1(data)", keyHex)
+	}
+	check := func(when string) {
+		for i, n := range nodes {
+			if _, ok := n.loadLocal(keyHex); ok != chosen[i] {
+				t.Fatalf("%s: node %d holds=%v, want %v", when, i, ok, chosen[i])
+			}
+		}
+	}
+	check("after PutTo")
+	origin.republishOwnedKeys(false)
+	check("after republish")
+}
+
+// A node leaving hands off what it holds: its own keys always, replicas it
+// holds for others only when asked, so a value whose only copy was on the
+// leaver is still retrievable right after.
+func TestM2_Leave_HandsOffStoredKeys(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 6)
+	origin, leaverA, leaverB, reader := nodes[0], nodes[4], nodes[5], nodes[2]
+	onlyOn := func(data []byte, c Contact) string {
+		keyHex, err := origin.PutTo(data, []Contact{c})
+		if err != nil {
+			t.Fatalf("PutTo: %v", err)
+		}
+		origin.Forget(keyHex) // now the only copy is on c
+		return keyHex
+	}
+	onlyOn([]byte("replica on a"), contacts[4])
+	y := onlyOn([]byte("replica on b"), contacts[5])
+	if _, err := leaverA.Put([]byte("a's own value")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if n, err := leaverA.Leave(false); err != nil || n != 1 {
+		t.Fatalf("Leave(false) = %d, %v; want only a's own key handed off", n, err)
+	}
+	// b also holds a replica of a's value, Put with K above the cluster size.
+	if n, err := leaverB.Leave(true); err != nil || n != 2 {
+		t.Fatalf("Leave(true) = %d, %v; want both replicas b held handed off", n, err)
+	}
+	got, from, err := reader.GetNoCache(y)
+	if err != nil || string(got) != "replica on b" {
+		t.Fatalf("Get after its only holder left: %q, %v", got, err)
+	}
+	if from.Address == contacts[5].Address {
+		t.Fatalf("value served by the node that left")
+	}
+}
+
+// Successive republish delays wander within ±jitter of the interval, on both
+// sides of it; without jitter every delay is the interval itself.
+func TestM2_RepublishJitter_DelaysVaryWithinBand(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	k, _ := m2NewNode(t, WithRepublishInterval(interval), WithRepublishJitter(0.2))
+	lo, hi := interval*8/10, interval*12/10
+	distinct := map[time.Duration]bool{}
+	below, above := false, false
+	for i := 0; i < 200; i++ {
+		d := k.nextRepublishDelay()
+		if d < lo || d > hi {
+			t.Fatalf("delay %v outside [%v, %v]", d, lo, hi)
+		}
+		distinct[d] = true
+		below = below || d < interval
+		above = above || d > interval
+	}
+	if len(distinct) < 2 || !below || !above {
+		t.Fatalf("delays don't spread around %v: %d distinct, below=%v above=%v", interval, len(distinct), below, above)
+	}
+
+	exact, _ := m2NewNode(t, WithRepublishInterval(interval), WithRepublishJitter(0))
+	for i := 0; i < 10; i++ {
+		if d := exact.nextRepublishDelay(); d != interval {
+			t.Fatalf("jitter 0: delay %v, want %v", d, interval)
+		}
+	}
+}
+
+// Shortening the interval at runtime takes effect on the running republisher:
+// a replica that lost its copy gets it back on the next (short) tick.
+func TestM2_SetRepublishInterval_RepublishesSoon(t *testing.T) {
+	nodes, _ := m2Cluster(t, 4)
+	origin := nodes[1]
+	keyHex, err := origin.Put([]byte("again, soon"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	replica := nodes[3]
+	if !replica.evictLocal(keyHex, Forgotten) {
+		t.Fatalf("replica did not hold the value after Put")
+	}
+	if err := origin.SetRepublishInterval(0); err == nil {
+		t.Fatalf("SetRepublishInterval(0) accepted")
+	}
+	if err := origin.SetRepublishInterval(50 * time.Millisecond); err != nil {
+		t.Fatalf("SetRepublishInterval: %v", err)
+	}
+	if !m2WaitHasLocalValue(t, replica, keyHex, 2*time.Second) {
+		t.Fatalf("no republish within 2s of a 50ms interval")
+	}
+}
+
+// RepublishNow re-replicates every origin key before it returns.
+func TestM2_RepublishNow_IsSynchronous(t *testing.T) {
+	nodes, _ := m2Cluster(t, 4)
+	origin := nodes[1]
+	var keys []string
+	for _, v := range []string{"now one", "now two"} {
+		k, err := origin.Put([]byte(v))
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		keys = append(keys, k)
+	}
+	for _, n := range nodes {
+		if n == origin {
+			continue
+		}
+		for _, k := range keys {
+			n.evictLocal(k, Forgotten)
+		}
+	}
+
+	if got := origin.RepublishNow(); got != len(keys) {
+		t.Fatalf("RepublishNow republished %d keys, want %d", got, len(keys))
+	}
+	for i, n := range nodes {
+		for _, k := range keys {
+			if _, ok := n.loadLocal(k); !ok {
+				t.Fatalf("node %d lacks %s right after RepublishNow", i, k)
+			}
+		}
+	}
+}
+
+// Cancelling the context stops a Get stuck on unresponsive peers right away
+// with ctx.Err(), rather than after the per-RPC timeout; PutContext likewise.
+func TestM2_GetContext_CancelMidLookup(t *testing.T) {
+	k, _ := m2NewNode(t)
+	for i := 0; i < 3; i++ { // silent peers: every RPC would wait out timeoutRPC
+		k.routingTable.AddContact(NewContact(NewKademliaID(m2RandIDHex(t)), "127.0.0.1:"+strconv.Itoa(m2FreeUDPPort(t))))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, _, err := k.GetContext(ctx, m2RandIDHex(t))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetContext err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > k.timeoutRPC/2 {
+		t.Fatalf("GetContext took %v after cancel, want well under the %v RPC timeout", elapsed, k.timeoutRPC)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	keyHex, err := k.PutContext(ctx, []byte("cut short"))
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > k.timeoutRPC/2 {
+		t.Fatalf("PutContext: err=%v after %v, want a prompt DeadlineExceeded", err, time.Since(start))
+	}
+	if _, ok := k.loadLocal(keyHex); !ok {
+		t.Fatalf("cancelled Put did not keep the local copy")
+	}
+}
+
+// Put places replicas on the K nodes globally closest to the key by XOR,
+// as found by a lookup that confirms each of them, not on whatever the
+// origin's table happens to list: here a dead contact sitting right on the key.
+func TestM2_PutTargetsGloballyClosestK(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 10)
+	const originIdx, k = 4, 3
+	origin := nodes[originIdx]
+	if err := origin.SetReplicationFactor(k); err != nil {
+		t.Fatalf("SetReplicationFactor: %v", err)
+	}
+	data := []byte("closest of all")
+	keyHex := m2KeyHex(data)
+	stale := NewContact(NewKademliaID(keyHex), "127.0.0.1:"+strconv.Itoa(m2FreeUDPPort(t)))
+	origin.routingTable.AddContact(stale)
+
+	res, err := origin.PutWithResult(data)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	want := m2KClosestPeersToKey(originIdx, contacts, keyHex)[:k]
+	if len(res.Targets) != k {
+		t.Fatalf("%d targets, want %d", len(res.Targets), k)
+	}
+	for i := range want {
+		if res.Targets[i].Address != want[i].Address {
+			t.Fatalf("target %d = %s, want %s (globally closest)", i, res.Targets[i].Address, want[i].Address)
+		}
+	}
+
+	keyID := NewKademliaID(m2KeyHex([]byte("lookup target")))
+	closest := nodes[0].LookupClosest(keyID)
+	if len(closest) != len(nodes)-1 {
+		t.Fatalf("LookupClosest returned %d contacts, want all %d peers", len(closest), len(nodes)-1)
+	}
+	for i := 1; i < len(closest); i++ {
+		if closest[i].ID.CalcDistance(keyID).Less(closest[i-1].ID.CalcDistance(keyID)) {
+			t.Fatalf("LookupClosest not sorted nearest first")
+		}
+	}
+}
+
+// A Put that rides on a coalesced lookup for another key in its region
+// still places replicas on its own key's K closest, not the other key's.
+func TestM2_CoalescedPlacement_RiderGetsOwnClosest(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 10)
+	const originIdx, k = 4, 3
+	origin := nodes[originIdx]
+	origin.coalesceBits = 8
+	if err := origin.SetReplicationFactor(k); err != nil {
+		t.Fatalf("SetReplicationFactor: %v", err)
+	}
+	keyB := m2KeyHex([]byte("rider's key"))
+	idB := NewKademliaID(keyB)
+	idA := *idB
+	idA[IDLength-1] ^= 0xff // same region, different key
+	want := m2KClosestPeersToKey(originIdx, contacts, keyB)
+
+	// Hold a lookup for key A in flight. Like a real one it refreshes the
+	// table around the region, but it answers with the peers farthest from
+	// key B.
+	started, release := make(chan struct{}), make(chan struct{})
+	go origin.lookups.do(regionKey(&idA, origin.coalesceBits), func() any {
+		close(started)
+		origin.lookupContact(context.Background(), &Contact{ID: &idA}, k, nil)
+		<-release
+		return regionLookup{key: idA, k: k, confirmed: want[len(want)-k:]}
+	})
+	<-started
+	got := make(chan []Contact, 1)
+	go func() { got <- origin.placement(context.Background(), idB, nil) }()
+	time.Sleep(50 * time.Millisecond) // let the Put join the flight
+	close(release)
+
+	targets := <-got
+	if len(targets) != k {
+		t.Fatalf("%d targets, want %d", len(targets), k)
+	}
+	for i := range targets {
+		if targets[i].Address != want[i].Address {
+			t.Fatalf("target %d = %s, want %s (closest to the rider's key)", i, targets[i].Address, want[i].Address)
+		}
+	}
+}
+
+// A batch of concurrent Puts in one region, coalesced, still places every
+// value on its own key's K closest, and sends fewer FIND_NODEs than the same
+// batch would uncoalesced (one full lookup per Put).
+func TestM2_CoalescedBatch_EachKeyGetsItsClosest(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 10)
+	const originIdx, k, batch = 4, 3, 12
+	origin := nodes[originIdx]
+	origin.coalesceBits = 8
+	if err := origin.SetReplicationFactor(k); err != nil {
+		t.Fatalf("SetReplicationFactor: %v", err)
+	}
+	seq := 0
+	putBatch := func(payloads [][]byte) (results []PutResult, findNodes int64) {
+		before := origin.network.sent.get(msgFindNode)
+		results = make([]PutResult, len(payloads))
+		var wg sync.WaitGroup
+		for i := range payloads {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				res, err := origin.PutWithResult(payloads[i])
+				if err != nil {
+					t.Errorf("Put: %v", err)
+				}
+				results[i] = res
+			}(i)
+		}
+		wg.Wait()
+		return results, origin.network.sent.get(msgFindNode) - before
+	}
+	payloads := m2RegionPayloads(batch, 0x42, &seq)
+	results, coalesced := putBatch(payloads)
+	origin.coalesceBits = 0
+	_, uncoalesced := putBatch(m2RegionPayloads(batch, 0x42, &seq))
+	if coalesced >= uncoalesced {
+		t.Fatalf("coalesced batch sent %d FIND_NODEs, uncoalesced %d: want fewer", coalesced, uncoalesced)
+	}
+
+	for i, res := range results {
+		want := m2KClosestPeersToKey(originIdx, contacts, m2KeyHex(payloads[i]))[:k]
+		if len(res.Targets) != k {
+			t.Fatalf("payload %d: %d targets, want %d", i, len(res.Targets), k)
+		}
+		for j := range want {
+			if res.Targets[j].Address != want[j].Address {
+				t.Fatalf("payload %d target %d = %s, want %s", i, j, res.Targets[j].Address, want[j].Address)
+			}
+		}
+	}
+}
+
+// A value several datagrams long is chunked on Put and comes back
+// byte-identical from another node, content checks on; small values still
+// travel as one STORE. Forgetting the blob forgets its chunks.
+func TestM2_LargeValue_ChunkedRoundTrip(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 5)
+	for _, n := range nodes {
+		n.verifyContent = true
+	}
+	blob := make([]byte, 200*1024)
+	if _, err := rand.Read(blob); err != nil {
+		t.Fatal(err)
+	}
+
+	keyHex, err := nodes[0].Put(blob)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if keyHex != m2KeyHex(blob) {
+		t.Fatalf("key = %s, want sha1 of the whole blob", keyHex)
+	}
+	raw, ok := nodes[0].loadLocal(keyHex)
+	m, isManifest := decodeManifest(raw)
+	if !ok || !isManifest || len(m.Chunks) != 7 || m.Size != len(blob) {
+		t.Fatalf("origin holds %d bytes under the key, want a 7-chunk manifest", len(raw))
+	}
+
+	// A late joiner holds nothing, so every chunk comes over the network.
+	reader, _ := m2NewNode(t)
+	reader.verifyContent = true
+	if err := reader.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	got, _, err := reader.Get(keyHex)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(got, blob) {
+		t.Fatalf("reassembled %d bytes differ from the %d put", len(got), len(blob))
+	}
+
+	small := []byte("fits in one datagram")
+	smallKey, _ := nodes[0].Put(small)
+	if v, _ := nodes[0].loadLocal(smallKey); !bytes.Equal(v, small) {
+		t.Fatalf("small value was not stored as is")
+	}
+
+	nodes[0].Forget(keyHex)
+	for _, c := range m.Chunks {
+		if _, ok := nodes[0].loadLocal(c); ok {
+			t.Fatalf("chunk %s survived Forget of its manifest", c)
+		}
+	}
+}
+
+// A STORE whose value doesn't hash to its key is refused with STORE_ERR:
+// nothing is stored and the sender isn't learned.
+func TestM2_Store_RejectsValueNotMatchingKey(t *testing.T) {
+	a, aMe := m2NewNode(t)
+	b, bMe := m2NewNode(t)
+	keyHex := m2KeyHex([]byte("the real content"))
+
+	err := a.network.sendStoreTo(&bMe, keyHex, []byte("poison"), time.Second, nil)
+	if !errors.Is(err, ErrStoreRejected) {
+		t.Fatalf("mismatched STORE: err = %v, want ErrStoreRejected", err)
+	}
+	if _, ok := b.loadLocal(keyHex); ok {
+		t.Fatalf("mismatched value was stored")
+	}
+	if hasContactWithAddress(b, aMe.Address) {
+		t.Fatalf("poisoning sender was learned")
+	}
+
+	if err := a.network.sendStoreTo(&bMe, keyHex, []byte("the real content"), time.Second, nil); err != nil {
+		t.Fatalf("matching STORE: %v", err)
+	}
+	if _, ok := b.loadLocal(keyHex); !ok {
+		t.Fatalf("matching value was not stored")
+	}
+}
+
+// A manifest STORE is held only once the value it lists reassembles to the
+// key: a genuine manifest replayed under another key is refused.
+func TestM2_Store_ManifestCheckedAgainstKey(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 3)
+	blob := make([]byte, 3*chunkSize)
+	if _, err := rand.Read(blob); err != nil {
+		t.Fatal(err)
+	}
+	key, err := nodes[0].Put(blob)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	raw, _ := nodes[0].loadLocal(key)
+	if _, ok := decodeManifest(raw); !ok {
+		t.Fatalf("origin holds no manifest under %s", key)
+	}
+
+	late, lateMe := m2NewNode(t)
+	if err := late.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	forged := m2KeyHex([]byte("something else entirely"))
+	if err := nodes[1].network.sendStoreTo(&lateMe, forged, raw, 2*time.Second, nil); !errors.Is(err, ErrStoreRejected) {
+		t.Fatalf("manifest under a key it doesn't reassemble to: err = %v, want ErrStoreRejected", err)
+	}
+	if late.HasLocal(forged) {
+		t.Fatalf("forged manifest was stored")
+	}
+	if err := nodes[1].network.sendStoreTo(&lateMe, key, raw, 2*time.Second, nil); err != nil {
+		t.Fatalf("genuine manifest STORE: %v", err)
+	}
+	if !late.HasLocal(key) {
+		t.Fatalf("genuine manifest was not stored")
+	}
+}
+
+// A FIND_VALUE hit carries the responder's closest contacts when asked, and
+// Get uses them to path-cache even when the holder is the only node it queried.
+func TestM2_FindValueHit_CarriesContacts(t *testing.T) {
+	holder, holderMe := m2NewNode(t)
+	near, nearMe := m2NewNode(t)
+	holder.routingTable.AddContact(nearMe)
+	keyHex, err := holder.Put([]byte("with directions"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	reader, _ := m2NewNode(t)
+	val, cons, err := reader.network.sendFindValueToCtx(context.Background(), &holderMe, keyHex, true, time.Second, nil)
+	if err != nil || string(val) != "with directions" || len(cons) == 0 {
+		t.Fatalf("hit with contacts = %q, %v, %v; want the value and contacts", val, cons, err)
+	}
+	if _, cons, _ := reader.network.sendFindValueTo(&holderMe, keyHex, time.Second, nil); len(cons) != 0 {
+		t.Fatalf("plain hit carried %d contacts, want none", len(cons))
+	}
+
+	near.Forget(keyHex) // drop the replica Put sent it
+	reader.routingTable.Reset()
+	reader.routingTable.AddContact(holderMe)
+	if _, _, err := reader.Get(keyHex); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !m2WaitHasLocalValue(t, near, keyHex, time.Second) {
+		t.Fatalf("Get did not path-cache at the holder's closest contact")
+	}
+}
+
+// A node whose store is full of values it can't evict answers replica STOREs
+// with STORE_ERR, and a Put nobody accepted fails with the typed refusal at
+// once instead of timing out.
+func TestM2_Put_SurfacesStoreRejection(t *testing.T) {
+	full, fullMe := m2NewNode(t, WithStoreCapacity(1))
+	if _, err := full.Put([]byte("mine, never evicted")); err != nil {
+		t.Fatalf("Put on full node: %v", err)
+	}
+	origin, _ := m2NewNode(t)
+	if err := origin.Join(&fullMe); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+
+	data := []byte("nowhere to go")
+	start := time.Now()
+	res, err := origin.PutWithResult(data)
+	var rej *StoreRejectedError
+	if !errors.Is(err, ErrStoreRejected) || !errors.As(err, &rej) {
+		t.Fatalf("Put to a full node: err = %v, want a StoreRejectedError", err)
+	}
+	if rej.Reason != rejectFull || rej.Peer.Address != fullMe.Address {
+		t.Fatalf("refusal = %+v, want %q from %s", rej, rejectFull, fullMe.Address)
+	}
+	if res.Acked != 0 || res.Refused != 1 || res.State != LocalOnly {
+		t.Fatalf("result = %+v, want 0 acked, 1 refused, LocalOnly", res)
+	}
+	if elapsed := time.Since(start); elapsed >= origin.timeoutRPC {
+		t.Fatalf("Put took %v, as long as an RPC timeout", elapsed)
+	}
+	if _, ok := full.loadLocal(m2KeyHex(data)); ok {
+		t.Fatalf("full node stored the refused value")
+	}
+}
+
+// STOREs larger than SetMaxValueSize are refused with STORE_ERR; one at the
+// limit is stored. Put checks the same limit before storing anything.
+func TestM2_MaxValueSize_RejectsOneByteOver(t *testing.T) {
+	a, _ := m2NewNode(t)
+	b, bMe := m2NewNode(t)
+	const limit = 1000
+	b.SetMaxValueSize(limit)
+
+	over := bytes.Repeat([]byte("x"), limit+1)
+	err := a.network.sendStoreTo(&bMe, m2KeyHex(over), over, time.Second, nil)
+	var rej *StoreRejectedError
+	if !errors.As(err, &rej) || rej.Reason != rejectTooLarge {
+		t.Fatalf("STORE of %d bytes: err = %v, want a %q refusal", len(over), err, rejectTooLarge)
+	}
+	if _, ok := b.loadLocal(m2KeyHex(over)); ok {
+		t.Fatalf("oversize value was stored")
+	}
+
+	at := bytes.Repeat([]byte("y"), limit)
+	if err := a.network.sendStoreTo(&bMe, m2KeyHex(at), at, time.Second, nil); err != nil {
+		t.Fatalf("STORE of exactly %d bytes: %v", limit, err)
+	}
+	if _, ok := b.loadLocal(m2KeyHex(at)); !ok {
+		t.Fatalf("value at the limit was not stored")
+	}
+
+	if _, err := b.Put(over); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("Put of %d bytes: err = %v, want ErrValueTooLarge", len(over), err)
+	}
+	if _, ok := b.loadLocal(m2KeyHex(over)); ok {
+		t.Fatalf("oversize Put was stored locally")
+	}
+}
+
+// A highly compressible 50KB value (too big for one datagram as base64 JSON)
+// goes out gzipped in both the STORE and the FIND_VALUE reply, and arrives intact.
+func TestM2_Compression_ShrinksLargeValues(t *testing.T) {
+	a, _ := m2NewNode(t, WithCompression(1024))
+	b, bMe := m2NewNode(t, WithCompression(1024))
+	value := bytes.Repeat([]byte("all work and no play makes jack a dull boy\n"), 50*1024/43)
+	keyHex := m2KeyHex(value)
+
+	op := &opAccount{}
+	if err := a.network.sendStoreTo(&bMe, keyHex, value, time.Second, op); err != nil {
+		t.Fatalf("STORE: %v", err)
+	}
+	if sent := op.stats().SentBytes; sent >= int64(len(value))/10 {
+		t.Fatalf("STORE of %d bytes took %d on the wire, want it compressed", len(value), sent)
+	}
+	if got, ok := b.loadLocal(keyHex); !ok || !bytes.Equal(got, value) {
+		t.Fatalf("receiver stored %d bytes (ok=%v), want the original %d", len(got), ok, len(value))
+	}
+
+	op = &opAccount{}
+	got, _, err := a.network.sendFindValueTo(&bMe, keyHex, time.Second, op)
+	if err != nil || !bytes.Equal(got, value) {
+		t.Fatalf("FIND_VALUE returned %d bytes, %v; want the original %d", len(got), err, len(value))
+	}
+	if recv := op.stats().RecvBytes; recv >= int64(len(value))/10 {
+		t.Fatalf("FIND_VALUE reply took %d bytes on the wire, want it compressed", recv)
+	}
+
+	small := []byte("tiny")
+	env := envelope{Value: small}
+	compressValue(&env, 1024)
+	if env.Compressed || !bytes.Equal(env.Value, small) {
+		t.Fatalf("value under the threshold was compressed")
+	}
+}
+
+// Forget drops the origin's copy and takes the key off the republish list.
+func TestM2_Forget_StopsRepublish(t *testing.T) {
+	nodes, _ := m2Cluster(t, 3)
+	origin := nodes[0]
+	keyHex, err := origin.Put([]byte("forget me"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if !origin.Forget(keyHex) {
+		t.Fatalf("Forget reported nothing removed")
+	}
+	if _, ok := origin.loadLocal(keyHex); ok {
+		t.Fatalf("origin still holds the value after Forget")
+	}
+	if origin.Forget(keyHex) {
+		t.Fatalf("second Forget reported a removal")
+	}
+
+	// Drop the replicas, then republish: nobody should get it back.
+	for _, n := range nodes[1:] {
+		n.evictLocal(keyHex, Forgotten)
+	}
+	origin.republishOwnedKeys(false)
+	if m2NodeHasValueNow(nodes, keyHex) {
+		t.Fatalf("forgotten key was republished")
+	}
+
+	// The Store forgets it too, so a restart doesn't bring the key back.
+	st := NewMemoryStore()
+	idHex := m2RandIDHex(t)
+	first, _ := m2NewNodeWithID(t, idHex, WithStore(st))
+	keyHex, err = first.Put([]byte("forget me across a restart"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	first.Forget(keyHex)
+	_ = first.Close()
+	second, _ := m2NewNodeWithID(t, idHex, WithStore(st))
+	if second.isOrigin(keyHex) {
+		t.Fatalf("forgotten key is an origin key again after a restart")
+	}
+	if _, ok := second.loadLocal(keyHex); ok {
+		t.Fatalf("forgotten value is back after a restart")
+	}
+}
+
+// With K=2 a Put lands on exactly two peers besides the origin, even though
+// the routing buckets still hold up to bucketSize contacts.
+func TestM2_ReplicationFactor_PutStoresOnKPeers(t *testing.T) {
+	nodes, _ := m2Cluster(t, 6)
+	origin := nodes[1]
+	for _, bad := range []int{0, -1, bucketSize + 1} {
+		if err := origin.SetReplicationFactor(bad); err == nil {
+			t.Fatalf("SetReplicationFactor(%d) accepted", bad)
+		}
+	}
+	if err := origin.SetReplicationFactor(2); err != nil {
+		t.Fatalf("SetReplicationFactor(2): %v", err)
+	}
+
+	keyHex, err := origin.Put([]byte("only two copies"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	holders := 0
+	for i, n := range nodes {
+		if n == origin {
+			continue
+		}
+		if _, ok := n.loadLocal(keyHex); ok {
+			holders++
+			t.Logf("node %d holds the value", i)
+		}
+	}
+	if holders != 2 {
+		t.Fatalf("%d peers hold the value, want exactly 2", holders)
+	}
+	if _, ok := origin.loadLocal(keyHex); !ok {
+		t.Fatalf("origin did not keep its own value")
+	}
+}
+
+// With one of the K replica targets down, PutWithResult reports K-1 acks.
+func TestM2_PutReportsAckedReplicas(t *testing.T) {
+	nodes, _ := m2Cluster(t, 6)
+	origin := nodes[1]
+	const k = 5 // every other node
+	if err := origin.SetReplicationFactor(k); err != nil {
+		t.Fatalf("SetReplicationFactor(%d): %v", k, err)
+	}
+	nodes[4].Close()
+
+	res, err := origin.PutWithResult([]byte("one replica short"))
+	if err != nil {
+		t.Fatalf("PutWithResult: %v", err)
+	}
+	if res.Acked != k-1 || res.State != Complete {
+		t.Fatalf("Acked=%d State=%s, want %d acks and complete (targets %d)", res.Acked, res.State, k-1, len(res.Targets))
+	}
+}
+
+// A value held only by the peer farthest from the key (of those the reader
+// knows) is one GetFast burst away; Get's alpha-wide rounds start with the
+// closest peers and reach it late, if at all.
+func TestM2_GetFast_ReachesFarthestReplica(t *testing.T) {
+	nodes, _ := m2Cluster(t, 8)
+	reader := nodes[len(nodes)-1] // joined last: knows everyone
+	reader.getCaching = false     // neither Get may leave copies behind
+	data := []byte("only on the far side")
+	keyHex := m2KeyHex(data)
+	keyID, _ := parseKeyHex(keyHex)
+	peers := reader.routingTable.FindClosestContacts(keyID, bucketSize)
+	if len(peers) != len(nodes)-1 {
+		t.Fatalf("reader knows %d peers, want %d", len(peers), len(nodes)-1)
+	}
+	farthest := peers[len(peers)-1]
+	if err := reader.network.sendStoreTo(&farthest, keyHex, data, time.Second, nil); err != nil {
+		t.Fatalf("seed STORE to %s: %v", farthest.Address, err)
+	}
+
+	start := time.Now()
+	val, _, slowStats, err := reader.GetWithStats(keyHex)
+	slow := time.Since(start)
+	t.Logf("Get: %q, err=%v, %v, %d requests", val, err, slow, slowStats.SentMsgs)
+	if alpha := int64(reader.alpha.Load()); err == nil && slowStats.SentMsgs <= alpha {
+		t.Fatalf("Get found the value in one round of %d; the farthest peer should come last", alpha)
+	}
+
+	start = time.Now()
+	val, from, err := reader.GetFast(keyHex)
+	fast := time.Since(start)
+	t.Logf("GetFast: %v", fast)
+	if err != nil || !bytes.Equal(val, data) {
+		t.Fatalf("GetFast = %q, %v; want %q", val, err, data)
+	}
+	if from.Address != farthest.Address {
+		t.Fatalf("GetFast served from %s, want %s", from.Address, farthest.Address)
+	}
+	if fast >= reader.timeoutRPC {
+		t.Fatalf("GetFast took %v, more than one RPC timeout", fast)
+	}
+}
+
+// Below K nodes, everyone is a replica and responsible; nothing assumes K peers.
+func TestM2_SmallClusters(t *testing.T) {
+	t.Run("size1", func(t *testing.T) {
+		k, _ := m2NewNode(t)
+		res, err := k.PutWithResult([]byte("solo"))
+		if err != nil || res.State != LocalOnly || len(res.Targets) != 0 {
+			t.Fatalf("PutWithResult = %+v, %v", res, err)
+		}
+		if v, _, err := k.Get(res.Key); err != nil || string(v) != "solo" {
+			t.Fatalf("Get = (%q, %v)", v, err)
+		}
+		if ok, err := k.IsResponsibleFor(res.Key); err != nil || !ok {
+			t.Fatalf("IsResponsibleFor = (%v, %v), want true", ok, err)
+		}
+		if _, _, err := k.Get(m2KeyHex([]byte("nothing"))); err == nil {
+			t.Fatalf("expected not found")
+		}
+	})
+
+	for _, n := range []int{2, 3} {
+		t.Run("size"+strconv.Itoa(n), func(t *testing.T) {
+			nodes, _ := m2Cluster(t, n)
+			res, err := nodes[0].PutWithResult([]byte("tiny network " + strconv.Itoa(n)))
+			if err != nil || res.State != Complete {
+				t.Fatalf("PutWithResult = %+v, %v", res, err)
+			}
+			if len(res.Targets) != n-1 {
+				t.Fatalf("replicated to %d peers, want all %d", len(res.Targets), n-1)
+			}
+			for i, node := range nodes {
+				if _, ok := node.loadLocal(res.Key); !ok {
+					t.Fatalf("node %d missing the value", i)
+				}
+				if ok, _ := node.IsResponsibleFor(res.Key); !ok {
+					t.Fatalf("node %d should be responsible with fewer than K nodes", i)
+				}
+			}
+			if v, _, err := nodes[n-1].Get(res.Key); err != nil || string(v) != "tiny network "+strconv.Itoa(n) {
+				t.Fatalf("Get = (%q, %v)", v, err)
+			}
+		})
+	}
+
+	// The value's only holder is also the only peer queried: Get has no other
+	// node to path-cache to and must still succeed.
+	t.Run("lateJoinerGetsFromSoleHolder", func(t *testing.T) {
+		a, ac := m2NewNode(t)
+		keyHex, _ := a.Put([]byte("before you arrived"))
+		b, _ := m2NewNode(t)
+		if err := b.Join(&ac); err != nil {
+			t.Fatalf("Join: %v", err)
+		}
+		if v, from, err := b.Get(keyHex); err != nil || string(v) != "before you arrived" || from.Address != ac.Address {
+			t.Fatalf("Get = (%q, %v, %v)", v, from, err)
+		}
+	})
+
+	t.Run("invalidKey", func(t *testing.T) {
+		k, _ := m2NewNode(t)
+		if _, err := k.IsResponsibleFor("xyz"); !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("err = %v, want ErrInvalidKey", err)
+		}
+	})
+}
+
+// Teardown stops background work everywhere before any socket closes, and
+// leaves nothing waiting on a reply.
+func TestM2_CloseCluster_Ordered(t *testing.T) {
+	nodes, _ := m2Cluster(t, 4)
+	for _, n := range nodes {
+		if _, err := n.Put([]byte("teardown " + n.me.Address)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	m2CloseCluster(nodes)
+	for i, n := range nodes {
+		select {
+		case <-n.republishStop:
+		default:
+			t.Fatalf("node %d republisher still running", i)
+		}
+		select {
+		case <-n.network.readStopped:
+		default:
+			t.Fatalf("node %d socket still open", i)
+		}
+		if p := n.network.pending(); p != 0 {
+			t.Fatalf("node %d closed with %d RPCs pending", i, p)
+		}
+	}
+	m2CloseCluster(nodes) // idempotent
+}
+
+// MeasureReplication counts live holders and drops as replicas go away.
+func TestM2_MeasureReplication(t *testing.T) {
+	nodes, _ := m2Cluster(t, 5)
+	keyHex, err := nodes[0].Put([]byte("how many copies?"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	auditor := nodes[3]
+
+	count, peers, err := auditor.MeasureReplication(keyHex)
+	if err != nil {
+		t.Fatalf("MeasureReplication: %v", err)
+	}
+	if count != len(nodes) || len(peers) != count {
+		t.Fatalf("count=%d peers=%d, want %d (fewer than K nodes: all hold it)", count, len(peers), len(nodes))
+	}
+
+	_ = nodes[1].Close()
+	nodes[2].evictLocal(keyHex, Forgotten)
+	count, _, err = auditor.MeasureReplication(keyHex)
+	if err != nil || count != len(nodes)-2 {
+		t.Fatalf("after losing two replicas: count=%d err=%v, want %d", count, err, len(nodes)-2)
+	}
+
+	if _, _, err := auditor.MeasureReplication("nope"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("err = %v, want ErrInvalidKey", err)
+	}
+}
+
+// Asked from one of the replicas, MeasureReplication ranks the asker with
+// the peers it probes: the count is at most K, not K plus itself.
+func TestM2_MeasureReplication_AtMostKFromAHolder(t *testing.T) {
+	const k = 3
+	nodes, _ := m2Cluster(t, 8)
+	for _, n := range nodes {
+		if err := n.SetReplicationFactor(k); err != nil {
+			t.Fatalf("SetReplicationFactor: %v", err)
+		}
+	}
+	// Every node holds the value; ask from the one closest to the key.
+	value := []byte("counted once each")
+	keyHex := m2KeyHex(value)
+	keyID, _ := parseKeyHex(keyHex)
+	holder := nodes[0]
+	for _, n := range nodes {
+		n.storeLocal(keyHex, value)
+		if n.me.ID.CalcDistance(keyID).Less(holder.me.ID.CalcDistance(keyID)) {
+			holder = n
+		}
+	}
+	count, peers, err := holder.MeasureReplication(keyHex)
+	if err != nil {
+		t.Fatalf("MeasureReplication: %v", err)
+	}
+	if count != k || len(peers) != count {
+		t.Fatalf("count=%d peers=%d from a replica, want %d", count, len(peers), k)
+	}
+}
+
+// GetVerbose lists each queried peer once and flags the one that had the value.
+func TestM2_GetVerbose_ListsHops(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 5)
+	getter := nodes[1]
+	if err := getter.SetAlpha(len(nodes)); err != nil { // one round reaches everyone
+		t.Fatalf("SetAlpha: %v", err)
+	}
+	getter.LookupContact(&contacts[1])
+	value := []byte("only the holder has me")
+	key := m2KeyHex(value)
+	holder := contacts[3]
+	nodes[3].storeLocal(key, value)
+
+	got, from, hops, err := getter.GetVerbose(key)
+	if err != nil || string(got) != string(value) || from.Address != holder.Address {
+		t.Fatalf("GetVerbose = %q from %v, %v; want the value from %s", got, from, err, holder.Address)
+	}
+	seen := map[string]int{}
+	for _, h := range hops {
+		seen[h.Peer.Address]++
+		if h.HadValue != (h.Peer.Address == holder.Address) {
+			t.Fatalf("hop %s: HadValue=%v", h.Peer.Address, h.HadValue)
+		}
+	}
+	if seen[holder.Address] != 1 {
+		t.Fatalf("holder %s not listed exactly once: %+v", holder.Address, hops)
+	}
+	for addr, n := range seen {
+		if n != 1 || addr == contacts[1].Address {
+			t.Fatalf("peer %s listed %d times (getter is %s): %+v", addr, n, contacts[1].Address, hops)
+		}
+	}
+	if len(seen) < 2 {
+		t.Fatalf("expected several peers queried, got %+v", hops)
+	}
+}
+
+// PutAt stores under a caller-chosen key; other nodes fetch it by that key
+// with GetAt, and putting there again replaces the record.
+func TestM2_PutAt_CustomKeyRoundTrip(t *testing.T) {
+	a, aMe := m2NewNode(t, WithKeyedRecords(true))
+	b, _ := m2NewNode(t, WithKeyedRecords(true))
+	if err := b.Join(&aMe); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	key := m2KeyHex([]byte("latest manifest for user X"))
+	if err := a.PutAt(key, []byte("v1")); err != nil {
+		t.Fatalf("PutAt: %v", err)
+	}
+	storeHex, _ := keyedKey(key)
+	if v, ok := b.loadLocal(storeHex); !ok || !keyedFitsKey(storeHex, v) {
+		t.Fatalf("keyed record not replicated: %q, %v", v, ok)
+	}
+	if b.HasLocal(key) {
+		t.Fatalf("keyed record filed under the content key %s", key)
+	}
+
+	c, _ := m2NewNode(t)
+	if err := c.Join(&aMe); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	if v, from, err := c.GetAt(key); err != nil || string(v) != "v1" {
+		t.Fatalf("GetAt from %v = %q, %v; want v1", from, v, err)
+	}
+	if err := a.PutAt(key, []byte("v2")); err != nil {
+		t.Fatalf("second PutAt: %v", err)
+	}
+	if v, _, err := c.GetAt(key); err != nil || string(v) != "v2" {
+		t.Fatalf("GetAt after overwrite = %q, %v; want v2", v, err)
+	}
+	if err := a.PutAt("abc", []byte("x")); err == nil {
+		t.Fatalf("PutAt accepted a malformed key")
+	}
+	if err := c.PutAt(key, []byte("x")); !errors.Is(err, ErrKeyedDisabled) {
+		t.Fatalf("PutAt without WithKeyedRecords = %v, want ErrKeyedDisabled", err)
+	}
+}
+
+// Keyed records can't reach a content key or a node that hasn't opted in:
+// PutAt under a content key leaves the content value alone, and raw keyed
+// STOREs that don't name their key, or go to a node without
+// WithKeyedRecords, are refused.
+func TestM2_KeyedStore_CannotReplaceContentValue(t *testing.T) {
+	a, aMe := m2NewNode(t, WithKeyedRecords(true))
+	b, _ := m2NewNode(t, WithKeyedRecords(true))
+	if err := b.Join(&aMe); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	content := []byte("content addressed")
+	key, err := a.Put(content)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := b.PutAt(key, []byte("impostor")); err != nil {
+		t.Fatalf("PutAt under a content key's name: %v", err)
+	}
+	if v, ok := a.loadLocal(key); !ok || string(v) != string(content) {
+		t.Fatalf("content value replaced: %q, %v", v, ok)
+	}
+
+	send := func(to *Contact, keyHex string, value []byte) error {
+		return b.network.storeRPC(context.Background(), to, envelope{
+			Type: msgStore, KeyHex: keyHex, Value: value, Keyed: true,
+		}, time.Second)
+	}
+	other := m2KeyHex([]byte("some other name"))
+	if err := send(&aMe, key, encodeKeyed(other, []byte("impostor"))); !errors.Is(err, ErrStoreRejected) {
+		t.Fatalf("keyed STORE under a key its record doesn't name: %v, want ErrStoreRejected", err)
+	}
+	if err := send(&aMe, key, []byte("impostor")); !errors.Is(err, ErrStoreRejected) {
+		t.Fatalf("keyed STORE of a bare value: %v, want ErrStoreRejected", err)
+	}
+	if v, ok := a.loadLocal(key); !ok || string(v) != string(content) {
+		t.Fatalf("content value replaced: %q, %v", v, ok)
+	}
+
+	plain, plainMe := m2NewNode(t)
+	storeHex, _ := keyedKey(other)
+	var rej *StoreRejectedError
+	if err := send(&plainMe, storeHex, encodeKeyed(other, []byte("x"))); !errors.As(err, &rej) || rej.Reason != rejectKeyedOff {
+		t.Fatalf("keyed STORE to a node without WithKeyedRecords: %v, want %q", err, rejectKeyedOff)
+	}
+	if plain.HasLocal(storeHex) {
+		t.Fatalf("node without WithKeyedRecords kept a keyed record")
+	}
+}
+
+// With membership unchanged, a second republish round sends no STOREs;
+// RepublishNow still does.
+func TestM2_Republish_SkipsUnchangedReplicaSet(t *testing.T) {
+	nodes, _ := m2Cluster(t, 4)
+	origin := nodes[0]
+	if _, err := origin.Put([]byte("static cluster")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	stores := func() int64 { return origin.RPCStats().StoreSent }
+
+	before := stores()
+	if n := origin.republishOwnedKeys(false); n != 1 {
+		t.Fatalf("first round republished %d keys, want 1", n)
+	}
+	first := stores() - before
+	if first == 0 {
+		t.Fatalf("first round sent no STOREs")
+	}
+	before = stores()
+	if n := origin.republishOwnedKeys(false); n != 0 {
+		t.Fatalf("second round republished %d keys, want 0", n)
+	}
+	if sent := stores() - before; sent != 0 {
+		t.Fatalf("second round sent %d STOREs over an unchanged replica set", sent)
+	}
+	before = stores()
+	if n := origin.RepublishNow(); n != 1 || stores()-before != first {
+		t.Fatalf("RepublishNow republished %d keys with %d STOREs, want 1 with %d", n, stores()-before, first)
+	}
+}
+
+// A replica that drops the value between rounds is re-STOREd on the next
+// one even though the replica set hasn't changed; the others only get a probe.
+func TestM2_Republish_RestoresEvictedReplica(t *testing.T) {
+	nodes, _ := m2Cluster(t, 4)
+	origin := nodes[0]
+	key, err := origin.Put([]byte("lost one replica"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if n := origin.republishOwnedKeys(false); n != 1 {
+		t.Fatalf("first round republished %d keys, want 1", n)
+	}
+	var victim *Kademlia
+	for _, n := range nodes[1:] {
+		if _, ok := n.loadLocal(key); ok {
+			victim = n
+			break
+		}
+	}
+	if victim == nil {
+		t.Fatalf("no peer holds %s after the first round", key)
+	}
+	victim.evictLocal(key, Forgotten)
+
+	before := origin.RPCStats().StoreSent
+	if n := origin.republishOwnedKeys(false); n != 1 {
+		t.Fatalf("second round republished %d keys, want 1", n)
+	}
+	if sent := origin.RPCStats().StoreSent - before; sent != 1 {
+		t.Fatalf("second round sent %d STOREs, want 1 to the evicted replica", sent)
+	}
+	if _, ok := victim.loadLocal(key); !ok {
+		t.Fatalf("evicted replica was not restored")
+	}
+}
+
+// PutAsync hands back the key while replication is still under way, and
+// done reports its success afterwards.
+func TestM2_PutAsync_ReturnsBeforeReplication(t *testing.T) {
+	a, aMe := m2NewNode(t, WithOutboundRate(20, 1)) // ~50ms per message: replication is slow
+	b, _ := m2NewNode(t)
+	if err := b.Join(&aMe); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond) // let a's token bucket refill after the join
+
+	data := []byte("replicated later")
+	key, done := a.PutAsync(data)
+	if key != m2KeyHex(data) {
+		t.Fatalf("PutAsync key = %q, want sha1(data)", key)
+	}
+	if _, ok := a.loadLocal(key); !ok {
+		t.Fatalf("PutAsync returned before storing locally")
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("replication finished before PutAsync returned (err=%v)", err)
+	default:
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("background replication: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("done never reported")
+	}
+	if _, ok := b.loadLocal(key); !ok {
+		t.Fatalf("value not on the peer once done reported success")
+	}
+	if _, open := <-done; open {
+		t.Fatalf("done not closed after reporting")
+	}
+}
+
+// ValueProvenance tells a value we published from one STOREd to us and one
+// our Get cached.
+func TestM2_ValueProvenance_RecordsHowValuesArrived(t *testing.T) {
+	a, aMe := m2NewNode(t)
+	b, _ := m2NewNode(t)
+	if err := b.Join(&aMe); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	want := func(k *Kademlia, key, desc string) {
+		t.Helper()
+		p, ok := k.ValueProvenance(key)
+		if !ok || p.String() != desc || p.At.IsZero() {
+			t.Fatalf("ValueProvenance(%s) = %q (at %v), %v; want %q", key[:8], p, p.At, ok, desc)
+		}
+	}
+
+	fetched, err := a.PutTo([]byte("only on a"), nil)
+	if err != nil {
+		t.Fatalf("PutTo: %v", err)
+	}
+	if _, _, err := b.Get(fetched); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	want(a, fetched, "published here")
+	want(b, fetched, "fetched via Get from "+aMe.Address)
+
+	stored, err := a.Put([]byte("pushed to b"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	want(b, stored, "stored by "+aMe.Address)
+
+	b.evictLocal(stored, Forgotten)
+	if _, ok := b.ValueProvenance(stored); ok {
+		t.Fatalf("provenance outlived the value")
+	}
+}
+
+// A cluster on a MemNet stores and finds values with no sockets at all; the
+// drop hook sees every datagram, here routed through the M4 simulator's
+// loss model (at 0% so the outcome is fixed).
+func TestM2_MemNet_PutGetWithoutSockets(t *testing.T) {
+	const n = 8
+	mem := NewMemNet()
+	sim := newSimCluster(t, n, 0, *m4Seed)
+	index := make(map[string]int, n)
+	var datagrams atomic.Int64
+	mem.SetDrop(func(from, to string) bool {
+		datagrams.Add(1)
+		return sim.dropped(index[from], index[to])
+	})
+
+	nodes := make([]*Kademlia, n)
+	for i := range nodes {
+		addr := net.JoinHostPort("10.0.0."+strconv.Itoa(i+1), "4000")
+		index[addr] = i
+		id := NewRandomKademliaID()
+		k, err := NewKademlia(NewContact(id, addr), "10.0.0."+strconv.Itoa(i+1), 4000, WithTransport(mem.Listen))
+		if err != nil {
+			t.Fatalf("NewKademlia %d: %v", i, err)
+		}
+		t.Cleanup(func() { _ = k.Close() })
+		nodes[i] = k
+	}
+	for i := 1; i < n; i++ {
+		if err := nodes[i].Join(&nodes[0].me); err != nil {
+			t.Fatalf("Join node %d: %v", i, err)
+		}
+	}
+
+	data := []byte("no sockets were harmed")
+	key, err := nodes[1].Put(data)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	nodes[n-1].Forget(key) // make the Get go over the MemNet
+	got, from, err := nodes[n-1].Get(key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("Get = %q, want %q", got, data)
+	}
+	if from == nil || index[from.Address] == n-1 {
+		t.Fatalf("value should come from another node, got %v", from)
+	}
+	if datagrams.Load() == 0 {
+		t.Fatalf("no datagrams went through the MemNet")
+	}
+}
+
+// The first STORE is lost on the real send path; the retransmission still
+// lands the replica within the RPC timeout.
+func TestM2_DropPolicy_LostStoreIsRetransmitted(t *testing.T) {
+	a, _ := m2NewNode(t)
+	b, bMe := m2NewNode(t)
+	if err := a.Join(&bMe); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	var stores atomic.Int64
+	a.network.SetDropPolicy(func(env envelope) bool {
+		return env.Type == msgStore && stores.Add(1) == 1
+	})
+
+	res, err := a.PutWithResult([]byte("lost once"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if res.Acked != 1 || res.State != Complete {
+		t.Fatalf("Put acked=%d state=%v, want 1 and Complete", res.Acked, res.State)
+	}
+	if stores.Load() != 2 {
+		t.Fatalf("STOREs sent = %d, want the dropped one plus one retransmission", stores.Load())
+	}
+	if !b.HasLocal(res.Key) {
+		t.Fatalf("b has no replica after the retransmission")
+	}
+}
+
+// A hit ends the round: Get doesn't wait out a peer of the same batch that
+// never answers.
+func TestM2_Get_ReturnsOnFirstValueInBatch(t *testing.T) {
+	getter, _ := m2NewNode(t)
+	holder, holderMe := m2NewNode(t)
+	data := []byte("fast answer")
+	key, err := holder.Put(data) // holder knows nobody: kept locally only
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	hole, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = hole.Close() })
+	getter.routingTable.AddContact(NewContact(NewRandomKademliaID(), hole.LocalAddr().String()))
+	getter.routingTable.AddContact(holderMe)
+
+	start := time.Now()
+	got, from, err := getter.GetNoCache(key)
+	elapsed := time.Since(start)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Get = %q, %v; want %q", got, err, data)
+	}
+	if from == nil || from.Address != holderMe.Address {
+		t.Fatalf("value came from %v, want the holder %s", from, holderMe.Address)
+	}
+	if elapsed >= getter.timeoutRPC/2 {
+		t.Fatalf("Get took %v: waited on the silent peer (RPC timeout %v)", elapsed, getter.timeoutRPC)
+	}
+}
+
+// Three values appended under one key all come back from a node that keeps
+// no append sets itself; a node without WithAppendKeys can't append.
+func TestM2_PutAppend_GetAllFromAnotherNode(t *testing.T) {
+	var nodes []*Kademlia
+	var boot Contact
+	for i := 0; i < 3; i++ {
+		k, me := m2NewNode(t, WithAppendKeys(true))
+		if i == 0 {
+			boot = me
+		} else if err := k.Join(&boot); err != nil {
+			t.Fatalf("Join %d: %v", i, err)
+		}
+		nodes = append(nodes, k)
+	}
+	reader, _ := m2NewNode(t)
+	if err := reader.Join(&boot); err != nil {
+		t.Fatalf("Join reader: %v", err)
+	}
+
+	key := m2KeyHex([]byte("topic"))
+	want := [][]byte{[]byte("one"), []byte("two"), []byte("three")}
+	for i, v := range want {
+		if err := nodes[i].PutAppend(key, v); err != nil {
+			t.Fatalf("PutAppend %q: %v", v, err)
+		}
+	}
+	if err := nodes[0].PutAppend(key, want[1]); err != nil {
+		t.Fatalf("re-appending a value: %v", err)
+	}
+
+	got, err := reader.GetAll(key)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("GetAll = %q, want %q", got, want)
+	}
+	for _, w := range want {
+		found := false
+		for _, g := range got {
+			found = found || bytes.Equal(g, w)
+		}
+		if !found {
+			t.Fatalf("GetAll = %q, missing %q", got, w)
+		}
+	}
+	if set := reader.loadAppended(key); set != nil {
+		t.Fatalf("reader holds %q without WithAppendKeys", set)
+	}
+	if err := reader.PutAppend(key, []byte("four")); !errors.Is(err, ErrAppendDisabled) {
+		t.Fatalf("PutAppend without WithAppendKeys = %v, want ErrAppendDisabled", err)
+	}
+	if v, _, err := reader.Get(key); err == nil {
+		t.Fatalf("plain Get found %q under an append key", v)
+	}
+}
+
+// GetNoCache finds the value but leaves no copy on the reader, nor a
+// path-cached one on the other peer it queried; a plain Get does both.
+func TestM2_GetNoCache_HasLocalStaysFalse(t *testing.T) {
+	holder, holderMe := m2NewNode(t)
+	bystander, bystanderMe := m2NewNode(t)
+	reader, _ := m2NewNode(t)
+	data := []byte("just passing through")
+	key, err := holder.Put(data) // holder knows nobody: kept locally only
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	reader.routingTable.AddContact(holderMe)
+	reader.routingTable.AddContact(bystanderMe)
+
+	got, _, err := reader.GetNoCache(key)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("GetNoCache = %q, %v; want %q", got, err, data)
+	}
+	if reader.HasLocal(key) {
+		t.Fatalf("reader holds the value after GetNoCache")
+	}
+	if bystander.HasLocal(key) {
+		t.Fatalf("GetNoCache path-cached the value on %s", bystanderMe.Address)
+	}
+
+	if _, _, err := reader.Get(key); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !reader.HasLocal(key) || !bystander.HasLocal(key) {
+		t.Fatalf("Get should cache locally and at the bystander (reader=%v bystander=%v)",
+			reader.HasLocal(key), bystander.HasLocal(key))
+	}
+}
+
+// A client-mode node's Put lands on its peers but nothing stays with the
+// client; peers don't list it, and STOREs to it are refused.
+func TestM2_ClientMode_PutKeepsNothingLocally(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 3)
+	client, clientMe := m2NewNode(t, WithClientMode(true))
+	if err := client.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+
+	data := []byte("sent by a client")
+	res, err := client.PutWithResult(data)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if res.Acked != len(nodes) {
+		t.Fatalf("Put acked by %d peers, want %d", res.Acked, len(nodes))
+	}
+	for i, n := range nodes {
+		if !n.HasLocal(res.Key) {
+			t.Fatalf("node %d has no replica", i)
+		}
+		if hasContactWithAddress(n, clientMe.Address) {
+			t.Fatalf("node %d added the client to its routing table", i)
+		}
+	}
+	if client.HasLocal(res.Key) || len(client.LocalKeys()) != 0 || client.isOrigin(res.Key) {
+		t.Fatalf("client kept something: keys=%v origin=%v", client.LocalKeys(), client.isOrigin(res.Key))
+	}
+
+	if got, _, err := client.Get(res.Key); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Get = %q, %v; want %q", got, err, data)
+	}
+	if client.HasLocal(res.Key) {
+		t.Fatalf("client cached the value on Get")
+	}
+	if err := nodes[0].network.sendStoreTo(&clientMe, res.Key, data, time.Second, nil); !errors.Is(err, ErrStoreRejected) {
+		t.Fatalf("STORE to the client = %v, want a refusal", err)
+	}
+}
+
+// A client with append keys on appends only to its peers and refuses APPENDs.
+func TestM2_ClientMode_PutAppendKeepsNoSet(t *testing.T) {
+	peer, peerMe := m2NewNode(t, WithAppendKeys(true))
+	client, clientMe := m2NewNode(t, WithAppendKeys(true), WithClientMode(true))
+	if err := client.Join(&peerMe); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+
+	key := m2KeyHex([]byte("client topic"))
+	if err := client.PutAppend(key, []byte("from a client")); err != nil {
+		t.Fatalf("PutAppend: %v", err)
+	}
+	if set := client.loadAppended(key); set != nil {
+		t.Fatalf("client kept append set %q", set)
+	}
+	if set := peer.loadAppended(key); len(set) != 1 {
+		t.Fatalf("peer set = %q, want the client's value", set)
+	}
+	err := peer.network.sendAppendToCtx(context.Background(), &clientMe, key, []byte("x"), time.Second, nil)
+	if !errors.Is(err, ErrStoreRejected) {
+		t.Fatalf("APPEND to the client = %v, want a refusal", err)
+	}
+}
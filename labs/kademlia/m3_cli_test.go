@@ -2,10 +2,15 @@ package kademlia
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"io"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -112,6 +117,34 @@ func TestM3_Get_ReturnsValueAndFromAddress(t *testing.T) {
 	}
 }
 
+// peek prints like get but leaves no copy behind on the reading node.
+func TestM3_Peek_DoesNotCache(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 4)
+	content := "look but do not keep"
+	key, err := nodes[1].Put([]byte(content))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	reader, readerMe := m2NewNode(t) // joins after the Put: holds no replica
+	if err := reader.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+
+	cli, _, out, _ := newCLI(reader)
+	if err := cli.RunLine("peek " + key); err != nil {
+		t.Fatalf("peek errored: %v", err)
+	}
+	if got := out.String(); !strings.Contains(got, content) || !strings.Contains(got, "from ") {
+		t.Fatalf("peek output missing value or source; out=%q", got)
+	}
+	if _, ok := reader.loadLocal(key); ok {
+		t.Fatalf("peek cached the value locally")
+	}
+	if m2QueryHasValue(t, nodes[0], &readerMe, key) {
+		t.Fatalf("reader serves the value after peek")
+	}
+}
+
 // Test that exit calls quit and returns io.EOF so a REPL can stop.
 func TestM3_Exit_CallsQuit(t *testing.T) {
 	k, _ := m2NewNode(t)
@@ -208,3 +241,249 @@ func TestM3_Whitespace_Tolerated(t *testing.T) {
 		t.Fatalf("get output didn't include content; out=%q", out.String())
 	}
 }
+
+// `put -v` prints the key first, then one line per replica target.
+func TestM3_PutVerbose_PrintsReplicaSet(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 4)
+
+	cli, _, out, _ := newCLI(nodes[0])
+	content := "where does this live"
+	if err := cli.RunLine("put -v " + content); err != nil {
+		t.Fatalf("put -v errored: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := sha1.Sum([]byte(content))
+	if lines[0] != hex.EncodeToString(want[:]) {
+		t.Fatalf("first line should be the key, got %q", lines[0])
+	}
+	if last := lines[len(lines)-1]; last != "state complete" {
+		t.Fatalf("last line should report the replication state, got %q", last)
+	}
+	// Fewer than K peers exist, so every other node is a target.
+	replicas := lines[1 : len(lines)-1]
+	if len(replicas) != len(contacts)-1 {
+		t.Fatalf("expected %d replica lines, got %d: %q", len(contacts)-1, len(replicas), replicas)
+	}
+	for _, line := range replicas {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "replica" {
+			t.Fatalf("malformed replica line %q", line)
+		}
+		n := m2NodeByAddress(nodes, fields[1])
+		if n == nil || n.me.ID.String() != fields[2] {
+			t.Fatalf("replica line %q does not name a cluster node", line)
+		}
+		if !m2WaitHasLocalValue(t, n, lines[0], 2*time.Second) {
+			t.Fatalf("listed replica %s does not hold the value", fields[1])
+		}
+	}
+}
+
+func TestM3_CacheClear(t *testing.T) {
+	k, _ := m2NewNode(t)
+	k.storeLocal(m2KeyHex([]byte("cached")), []byte("cached"))
+
+	cli, _, out, _ := newCLI(k)
+	if err := cli.RunLine("cache clear"); err != nil {
+		t.Fatalf("cache clear errored: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "cleared 1" {
+		t.Fatalf("unexpected output %q", got)
+	}
+	out.Reset()
+	if err := cli.RunLine("cache"); err == nil || !strings.Contains(out.String(), "ERR") {
+		t.Fatalf("bare 'cache' should be a usage error, got %q", out.String())
+	}
+}
+
+func TestM3_Rebalance_ReportsRepairedCount(t *testing.T) {
+	k, _ := m2NewNode(t)
+	if _, err := k.Put([]byte("no peers yet")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	cli, _, out, _ := newCLI(k)
+	if err := cli.RunLine("rebalance"); err != nil {
+		t.Fatalf("rebalance errored: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "repaired 0" {
+		t.Fatalf("unexpected output %q", got)
+	}
+}
+
+func TestM3_Forget_OKThenNotFound(t *testing.T) {
+	k, _ := m2NewNode(t)
+	key, err := k.Put([]byte("scratch"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	cli, _, out, _ := newCLI(k)
+	if err := cli.RunLine("forget " + key); err != nil || strings.TrimSpace(out.String()) != "OK" {
+		t.Fatalf("forget: err=%v out=%q", err, out.String())
+	}
+	out.Reset()
+	if err := cli.RunLine("forget " + key); err == nil || strings.TrimSpace(out.String()) != "NOTFOUND" {
+		t.Fatalf("second forget: err=%v out=%q", err, out.String())
+	}
+	out.Reset()
+	if err := cli.RunLine("forget abc"); err == nil || !strings.Contains(out.String(), "ERR") {
+		t.Fatalf("forget with a bad key should error, got %q", out.String())
+	}
+}
+
+// `keys` lists exactly what the node holds, sorted.
+func TestM3_Keys_ListsLocalKeys(t *testing.T) {
+	k, _ := m2NewNode(t)
+	var want []string
+	for _, v := range []string{"first", "second"} {
+		key, err := k.Put([]byte(v))
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		want = append(want, key)
+	}
+	sort.Strings(want)
+	cli, _, out, _ := newCLI(k)
+	if err := cli.RunLine("keys"); err != nil {
+		t.Fatalf("keys errored: %v", err)
+	}
+	if got := strings.Fields(out.String()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("keys printed %q, want %q", got, want)
+	}
+}
+
+// `has` answers from the local store only: NO before Put, YES after.
+func TestM3_Has_NoThenYes(t *testing.T) {
+	k, _ := m2NewNode(t)
+	content := []byte("check me")
+	key := m2KeyHex(content)
+	cli, _, out, _ := newCLI(k)
+	if err := cli.RunLine("has " + key); err != nil || strings.TrimSpace(out.String()) != "NO" {
+		t.Fatalf("has before put: err=%v out=%q", err, out.String())
+	}
+	if _, err := k.Put(content); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	out.Reset()
+	if err := cli.RunLine("has " + key); err != nil || strings.TrimSpace(out.String()) != "YES" {
+		t.Fatalf("has after put: err=%v out=%q", err, out.String())
+	}
+	out.Reset()
+	if err := cli.RunLine("has abc"); err == nil || !strings.Contains(out.String(), "ERR") {
+		t.Fatalf("has with a bad key should error, got %q", out.String())
+	}
+}
+
+func TestM3_PinAndUnpin(t *testing.T) {
+	k, _ := m2NewNode(t)
+	key := m2KeyHex([]byte("keep me"))
+	k.storeLocal(key, []byte("keep me"))
+
+	cli, _, out, _ := newCLI(k)
+	if err := cli.RunLine("pin " + key); err != nil {
+		t.Fatalf("pin errored: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "pinned "+key {
+		t.Fatalf("unexpected output %q", got)
+	}
+	if n := k.ClearCache(); n != 0 || !k.isPinned(key) {
+		t.Fatalf("pinned value not retained (cleared %d)", n)
+	}
+	out.Reset()
+	if err := cli.RunLine("unpin " + key); err != nil || k.isPinned(key) {
+		t.Fatalf("unpin failed: err=%v out=%q", err, out.String())
+	}
+	out.Reset()
+	if err := cli.RunLine("pin zz"); err == nil || !strings.Contains(out.String(), "ERR") {
+		t.Fatalf("pin with a bad key should error, got %q", out.String())
+	}
+}
+
+// `info` prints the node's identity and table/store sizes, one "name value" per line.
+func TestM3_Info_PrintsIdentityAndCounts(t *testing.T) {
+	k, me := m2NewNode(t)
+	for i := 0; i < 3; i++ {
+		k.routingTable.AddContact(NewContact(NewKademliaID(m2RandIDHex(t)), "127.0.0.1:"+itoa(m2FreeUDPPort(t))))
+	}
+	k.storeLocal(m2KeyHex([]byte("one")), []byte("one"))
+
+	cli, _, out, _ := newCLI(k)
+	if err := cli.RunLine("info"); err != nil {
+		t.Fatalf("info errored: %v", err)
+	}
+	want := "id " + me.ID.String() + "\naddr " + me.Address + "\ncontacts 3\nkeys 1"
+	if got := strings.TrimSpace(out.String()); got != want {
+		t.Fatalf("info printed %q, want %q", got, want)
+	}
+}
+
+func TestM3_UnknownCommand_SuggestsCloseMatch(t *testing.T) {
+	k, _ := m2NewNode(t)
+	cli, _, out, _ := newCLI(k)
+
+	if err := cli.RunLine("gte abc"); err == nil {
+		t.Fatalf("expected error for unknown command")
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "put, get") || lines[1] != `did you mean "get"?` {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+
+	out.Reset()
+	_ = cli.RunLine("frobnicate")
+	if strings.Contains(out.String(), "did you mean") {
+		t.Fatalf("no suggestion expected for a distant command, got %q", out.String())
+	}
+}
+
+// RunContext processes input until cancelled, even while blocked on a read.
+func TestM3_RunContext_ReturnsOnCancel(t *testing.T) {
+	k, _ := m2NewNode(t)
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	out := &safeBuffer{}
+	cli := NewCLI(k, pr, out, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- cli.RunContext(ctx) }()
+
+	if _, err := io.WriteString(pw, "put hello\n"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	want := m2KeyHex([]byte("hello"))
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(out.String(), want) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !strings.Contains(out.String(), want) {
+		t.Fatalf("put was not executed, output %q", out.String())
+	}
+
+	cancel() // the reader is now blocked on the pipe
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("RunContext returned %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("RunContext did not return after cancel")
+	}
+}
+
+// safeBuffer is a bytes.Buffer safe for one writer and a polling reader.
+type safeBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (s *safeBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *safeBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
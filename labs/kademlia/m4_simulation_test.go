@@ -151,3 +151,114 @@ func TestM4_Simulation_KeyShape_Sanity(t *testing.T) {
 		// Most likely missing (value not put), which is OK; we just confirm no panic and valid shape.
 	}
 }
+
+// m4Retrievable puts one value and counts how many vantage points can read it back.
+func m4Retrievable(t *testing.T, cluster *simCluster, payload string) (replicas, successes int) {
+	t.Helper()
+	nodes := len(cluster.nodes)
+	origin := nodes / 3
+	keyHex, _, replicas := cluster.simPut(origin, []byte(payload))
+	starts := []int{0, nodes / 5, nodes / 2, (4 * nodes) / 5, nodes - 1, origin}
+	for _, idx := range starts {
+		if v, _, ok := cluster.simGet(idx, keyHex); ok && string(v) == payload {
+			successes++
+		}
+	}
+	return replicas, successes
+}
+
+// Per-link rates: each direction of a pair draws its own rate, within drop±spread.
+func TestM4_Simulation_PairDrops_Asymmetric(t *testing.T) {
+	const drop, spread = 20, 15
+	cluster := newSimCluster(t, 1000, drop, *m4Seed).withPairDrops(spread)
+
+	var asymmetric int
+	for i := 0; i < 50; i++ {
+		cluster.dropped(i, i+1)
+		cluster.dropped(i+1, i)
+		ab := cluster.pairDrop[simLink{i, i + 1}]
+		ba := cluster.pairDrop[simLink{i + 1, i}]
+		for _, pct := range []int{ab, ba} {
+			if pct < drop-spread || pct > drop+spread {
+				t.Fatalf("link drop%% %d outside %d±%d", pct, drop, spread)
+			}
+		}
+		if ab != ba {
+			asymmetric++
+		}
+	}
+	if asymmetric == 0 {
+		t.Fatalf("expected at least one asymmetric pair out of 50")
+	}
+
+	replicas, successes := m4Retrievable(t, cluster, "m4-pair-drop")
+	t.Logf("replicas=%d successes=%d (drop=%d%%±%d)", replicas, successes, drop, spread)
+	if successes == 0 {
+		t.Fatalf("value not retrievable from any vantage point under per-link loss")
+	}
+}
+
+// Gilbert-Elliott loss arrives in bursts: consecutive drops on a link are far
+// more common than under IID loss.
+func TestM4_Simulation_GilbertElliott_IsBursty(t *testing.T) {
+	meanBurst := func(c *simCluster) (float64, int) {
+		var bursts, drops, run int
+		for i := 0; i < 5000; i++ {
+			if c.dropped(0, 1) {
+				drops++
+				run++
+				continue
+			}
+			if run > 0 {
+				bursts++
+				run = 0
+			}
+		}
+		if run > 0 {
+			bursts++
+		}
+		if bursts == 0 {
+			return 0, drops
+		}
+		return float64(drops) / float64(bursts), drops
+	}
+
+	uniform := newSimCluster(t, 2, 10, *m4Seed)
+	ge := newSimCluster(t, 2, 0, *m4Seed).withGilbertElliott(geParams{EnterPct: 5, ExitPct: 30, BadPct: 80})
+
+	uBurst, uDrops := meanBurst(uniform)
+	gBurst, gDrops := meanBurst(ge)
+	t.Logf("uniform: drops=%d meanBurst=%.2f; gilbert-elliott: drops=%d meanBurst=%.2f", uDrops, uBurst, gDrops, gBurst)
+	if gDrops == 0 {
+		t.Fatalf("gilbert-elliott model dropped nothing")
+	}
+	if gBurst <= uBurst*1.5 {
+		t.Fatalf("expected bursty loss: ge meanBurst=%.2f vs uniform %.2f", gBurst, uBurst)
+	}
+}
+
+// Replication/retrieval under bursty, per-link loss. The loss model follows
+// -m4.loss/-m4.pairspread/-m4.ge.*; without flags it runs Gilbert-Elliott on
+// top of -m4.drop so there is always a correlated-loss scenario.
+func TestM4_Simulation_RealisticLoss_StillRetrievable(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping M4 scale test in -short mode")
+	}
+	nodes := *m4Nodes
+	if nodes < 1000 {
+		nodes = 1000
+	}
+	cluster := newSimCluster(t, nodes, *m4Drop, *m4Seed).applyLossFlags(t)
+	if cluster.ge == nil {
+		cluster.withGilbertElliott(geParams{EnterPct: *m4GEEnter, ExitPct: *m4GEExit, BadPct: *m4GEBad})
+	}
+
+	replicas, successes := m4Retrievable(t, cluster, "m4-realistic-loss")
+	t.Logf("replicas=%d successes=%d (drop=%d%% spread=%d ge=%+v)", replicas, successes, *m4Drop, *m4PairSpread, *cluster.ge)
+	if replicas < 1 {
+		t.Fatalf("replicas should be at least origin: got %d", replicas)
+	}
+	if successes == 0 {
+		t.Fatalf("value not retrievable from any vantage point under realistic loss")
+	}
+}
@@ -1,6 +1,6 @@
 package kademlia
 
-// network.go: UDP transport + M1 handlers (PING, FIND_NODE)
+// network.go: request/response over a Transport + M1 handlers (PING, FIND_NODE)
 
 import (
 	"context"
@@ -8,38 +8,169 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Network provides UDP-based request/response for PING and FIND_NODE.
+// Network provides request/response for PING and FIND_NODE over UDP, or
+// whatever Transport WithTransport supplies.
 type Network struct {
-	conn        *net.UDPConn
+	// conns[0] is bound to the node's own address; any others come from
+	// WithListenAddrs. All feed the same handlers and inflight table.
+	conns       []Transport
 	kademlia    *Kademlia
 	mu          sync.Mutex
-	inflight    map[string]chan envelope // msgID -> response chan
-	readStopped chan struct{}
+	inflight    map[string]*pendingRPC // msgID -> waiting request
+	readStopped chan struct{}          // closed once every read loop exits
+	ready       chan struct{}          // closed once every read loop is running
+	sent        msgCounter             // outbound envelopes per type
+	rpcs        rpcCounters            // requests sent/received/timed out (see Stats)
+
+	// MsgIDs of the last maxRecentRPCs requests that left inflight (answered
+	// or given up on), in a ring; a reply to one of them is late. Under mu.
+	recent     map[string]struct{}
+	recentRing [maxRecentRPCs]string
+	recentNext int
+
+	// Node-wide RPC budget (see WithRPCBudget). budget is nil when unbounded;
+	// active/peak count budgeted requests either way.
+	budget chan struct{}
+	active atomic.Int64
+	peak   atomic.Int64
+
+	// Outbound pacing (nil = unlimited) and how many sends it delayed.
+	limiter   *tokenBucket
+	throttled atomic.Int64
+
+	// Test-injected loss (see SetDropPolicy); nil = send everything.
+	dropFunc atomic.Pointer[func(env envelope) bool]
+
+	// Send-to-delivery latency per request type (see LatencyStats).
+	latency latencyTable
+
+	// seq numbers our requests. Replies with no inflight waiter are counted:
+	// late if they answer a recently completed request (a duplicate, or one
+	// that arrived after its request timed out), unexpected otherwise.
+	seq        atomic.Uint64
+	late       atomic.Int64
+	unexpected atomic.Int64
+	badVersion atomic.Int64 // envelopes dropped for a protocol version we don't speak
+	badSig     atomic.Int64 // envelopes dropped by signature checks (see WithSignedEnvelopes)
+
+	// Public key first seen per node ID, when verifying signatures (at most
+	// maxPeerKeys of them).
+	keysMu   sync.Mutex
+	peerKeys map[KademliaID]peerKey
+
+	// How we encode what we send (see codec.go); reads accept any codec.
+	codec Codec
+
+	// caps is what our PONGs advertise (a Capabilities, atomic so tests can
+	// change it on a running node); peerCaps is what peers' PONGs said.
+	caps     atomic.Uint32
+	capsMu   sync.RWMutex
+	peerCaps map[KademliaID]Capabilities
+
+	// Most recent PING round trip per peer address (see LastRTT).
+	rttMu sync.RWMutex
+	rtt   map[string]time.Duration
+
+	// Manifest STOREs being checked, by sender address and MsgID (see
+	// checkManifest).
+	checkMu  sync.Mutex
+	checking map[string]struct{}
 }
 
-// NewNetwork binds ip:port and starts the read loop.
+// msgCounter tallies envelopes per message type.
+type msgCounter struct {
+	mu sync.Mutex
+	n  map[msgType]int64
+}
+
+func (c *msgCounter) add(t msgType) {
+	c.mu.Lock()
+	if c.n == nil {
+		c.n = make(map[msgType]int64)
+	}
+	c.n[t]++
+	c.mu.Unlock()
+}
+
+func (c *msgCounter) get(t msgType) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n[t]
+}
+
+// NewNetwork binds ip:port (ip may be IPv4 or IPv6, e.g. "::1") plus any
+// WithListenAddrs extras, and starts one read loop per socket.
 // NOTE: We retain your existing Listen() symbol below, but you don't need it.
 // Use NewKademlia(...) which creates a Network per node.
 func NewNetwork(k *Kademlia, ip string, port int) (*Network, error) {
-	udpAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", ip, port))
-	if err != nil {
-		return nil, err
+	// IPv6 literals may come bracketed ("[::1]"), as in a host:port.
+	ip = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+	addrs := []string{net.JoinHostPort(ip, fmt.Sprint(port))}
+	if k != nil {
+		addrs = append(addrs, k.listenAddrs...)
 	}
-	conn, err := net.ListenUDP("udp", udpAddr)
-	if err != nil {
-		return nil, err
+	listen := listenUDP
+	if k != nil && k.transport != nil {
+		listen = k.transport
+	}
+	conns := make([]Transport, 0, len(addrs))
+	for _, a := range addrs {
+		conn, err := listen(a)
+		if err != nil {
+			for _, c := range conns {
+				_ = c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
 	}
 	n := &Network{
-		conn:        conn,
+		conns:       conns,
 		kademlia:    k,
-		inflight:    make(map[string]chan envelope),
+		inflight:    make(map[string]*pendingRPC),
+		recent:      make(map[string]struct{}),
 		readStopped: make(chan struct{}),
+		ready:       make(chan struct{}),
+		peerCaps:    make(map[KademliaID]Capabilities),
+		rtt:         make(map[string]time.Duration),
+		peerKeys:    make(map[KademliaID]peerKey),
+		checking:    make(map[string]struct{}),
+		codec:       JSONCodec{},
+	}
+	n.caps.Store(uint32(localCapabilities))
+	if k != nil && k.codec != nil {
+		n.codec = k.codec
+	}
+	if k != nil && k.rpcBudget > 0 {
+		n.budget = make(chan struct{}, k.rpcBudget)
 	}
-	go n.readLoop()
+	if k != nil && k.outboundRate > 0 {
+		n.limiter = newTokenBucket(k.outboundRate, k.outboundBurst)
+	}
+	var reading, started sync.WaitGroup
+	for _, c := range conns {
+		reading.Add(1)
+		started.Add(1)
+		go func(c Transport) {
+			defer reading.Done()
+			started.Done()
+			n.readLoop(c)
+		}(c)
+	}
+	go func() {
+		reading.Wait()
+		close(n.readStopped)
+	}()
+	// The sockets are bound, so datagrams already queue in the kernel; once
+	// every loop is running they're also being drained and answered.
+	started.Wait()
+	close(n.ready)
 	return n, nil
 }
 
@@ -47,8 +178,8 @@ func NewNetwork(k *Kademlia, ip string, port int) (*Network, error) {
 func Listen(ip string, port int) { /* no-op; call NewKademlia instead */ }
 
 func (network *Network) Close() error {
-	if network.conn != nil {
-		_ = network.conn.Close()
+	for _, c := range network.conns {
+		_ = c.Close()
 	}
 	select {
 	case <-network.readStopped:
@@ -57,94 +188,301 @@ func (network *Network) Close() error {
 	return nil
 }
 
+// acquireRPC blocks until the node-wide budget has room for one more request
+// and returns the matching release. PINGs bypass the budget: they can be
+// issued from AddContact while a budgeted request is still being handled.
+func (network *Network) acquireRPC() (release func()) {
+	if network.budget != nil {
+		network.budget <- struct{}{}
+	}
+	n := network.active.Add(1)
+	for {
+		p := network.peak.Load()
+		if n <= p || network.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	return func() {
+		network.active.Add(-1)
+		if network.budget != nil {
+			<-network.budget
+		}
+	}
+}
+
+// pendingRPC is a request waiting for its reply in the inflight table.
+type pendingRPC struct {
+	ch   chan envelope
+	typ  msgType    // request type, for latency accounting
+	sent time.Time  // when it was registered, just before sending
+	op   *opAccount // charged for the reply (nil = unaccounted)
+}
+
+// register stamps req with the next sequence number and adds it to the
+// inflight table so readLoop can hand its reply to the returned channel.
+// readLoop removes the entry as it delivers the first reply; call done once
+// the caller stops waiting so an unanswered request is removed too. A
+// retransmission must reuse req as-is so its reply echoes the same MsgID and
+// Seq.
+func (network *Network) register(req *envelope) (ch chan envelope, done func()) {
+	req.Seq = network.seq.Add(1)
+	p := &pendingRPC{ch: make(chan envelope, 1), typ: req.Type, sent: time.Now(), op: req.op}
+	network.mu.Lock()
+	network.inflight[req.MsgID] = p
+	network.mu.Unlock()
+	return p.ch, func() {
+		network.mu.Lock()
+		network.completeLocked(req.MsgID)
+		network.mu.Unlock()
+	}
+}
+
+// maxRecentRPCs is how many completed requests are remembered to tell late
+// replies from unexpected ones.
+const maxRecentRPCs = 1024
+
+// completeLocked removes msgID from the inflight table and remembers it
+// among the recently completed requests. Callers hold mu.
+func (network *Network) completeLocked(msgID string) {
+	delete(network.inflight, msgID)
+	if _, ok := network.recent[msgID]; ok {
+		return
+	}
+	if old := network.recentRing[network.recentNext]; old != "" {
+		delete(network.recent, old)
+	}
+	network.recentRing[network.recentNext] = msgID
+	network.recentNext = (network.recentNext + 1) % maxRecentRPCs
+	network.recent[msgID] = struct{}{}
+}
+
+// pending is the number of requests still waiting for a reply.
+func (network *Network) pending() int {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	return len(network.inflight)
+}
+
 func (network *Network) nextMsgID() string {
 	b := make([]byte, 16)
 	_, _ = rand.Read(b)
 	return hex.EncodeToString(b)
 }
 
+// send transmits a request from the socket matching to's address family.
 func (network *Network) send(to *net.UDPAddr, env envelope) error {
-	b, err := env.marshal()
+	return network.sendVia(network.connFor(to), to, env)
+}
+
+// SetDropPolicy makes the send path consult fn for every outgoing envelope
+// and silently discard those it returns true for, as if the datagram were
+// lost: it is still counted and paced, and the other side never sees it.
+// For exercising timeouts and retransmission in tests; nil turns it off.
+func (network *Network) SetDropPolicy(fn func(env envelope) bool) {
+	if fn == nil {
+		network.dropFunc.Store(nil)
+		return
+	}
+	network.dropFunc.Store(&fn)
+}
+
+// connFor picks the first socket of to's family (IPv4 vs IPv6), falling
+// back to the primary one.
+func (network *Network) connFor(to *net.UDPAddr) Transport {
+	v4 := to.IP.To4() != nil
+	for _, c := range network.conns {
+		if local := c.LocalAddr(); local != nil && (local.IP.To4() != nil) == v4 {
+			return c
+		}
+	}
+	return network.conns[0]
+}
+
+// sendVia transmits env on conn. Handlers reply on the socket the request
+// arrived on, so a peer always hears back from the address it used.
+func (network *Network) sendVia(conn Transport, to *net.UDPAddr, env envelope) error {
+	env.Version = protocolVersion
+	if network.kademlia != nil {
+		compressValue(&env, network.kademlia.compressAbove)
+		env.Client = network.kademlia.clientMode
+	}
+	network.sign(&env)
+	b, err := network.codec.marshal(env)
 	if err != nil {
 		return err
 	}
+	if network.limiter != nil {
+		if wait := network.limiter.take(); wait > 0 {
+			network.throttled.Add(1)
+			time.Sleep(wait)
+		}
+	}
 	// Wire-level send—pairs with your REPLICATE logs.
-	fmt.Printf("[NET] => %s msg=%s to=%s\n", env.Type, env.MsgID, to.String())
-	_, err = network.conn.WriteToUDP(b, to)
-	return err
+	network.debugf("[NET] => %s msg=%s to=%s", env.Type, env.MsgID, to.String())
+	network.sent.add(env.Type)
+	network.rpcs.countSent(env.Type)
+	env.op.sent(len(b))
+	if drop := network.dropFunc.Load(); drop != nil && (*drop)(env) {
+		network.debugf("[NET] policy dropped %s msg=%s to=%s", env.Type, env.MsgID, to.String())
+		return nil
+	}
+	return conn.Send(b, to)
 }
 
-func (network *Network) readLoop() {
+func (network *Network) readLoop(conn Transport) {
 	buf := make([]byte, 64*1024)
 	for {
-		n, src, err := network.conn.ReadFromUDP(buf)
+		n, src, err := conn.Receive(buf)
 		if err != nil {
-			close(network.readStopped)
 			return
 		}
 		var env envelope
 		if err := env.unmarshal(buf[:n]); err != nil {
 			continue
 		}
+		if v := env.version(); v != protocolVersion {
+			// Fields may mean something else in another version: don't guess.
+			network.badVersion.Add(1)
+			network.infof("[NET] drop %s msg=%s from=%s: protocol v%d, we speak v%d", env.Type, env.MsgID, src, v, protocolVersion)
+			continue
+		}
+		if network.kademlia != nil && network.kademlia.signKey != nil {
+			// Checked before any handler or waiter can learn the sender.
+			if err := network.verify(env, src); err != nil {
+				network.badSig.Add(1)
+				network.infof("[NET] drop %s msg=%s from=%s: %v", env.Type, env.MsgID, src, err)
+				continue
+			}
+		}
+		if err := decompressValue(&env); err != nil {
+			network.infof("[NET] drop %s msg=%s from=%s: %v", env.Type, env.MsgID, src, err)
+			continue
+		}
+		if key, err := canonicalKey(env.KeyHex); err == nil {
+			// One spelling per key from here on; a key with an unknown
+			// scheme is left as sent for the handler to refuse.
+			env.KeyHex = key
+		}
 
-		fmt.Printf("[NET] <= %s msg=%s from=%s\n", env.Type, env.MsgID, env.From.Address)
+		network.debugf("[NET] <= %s msg=%s from=%s", env.Type, env.MsgID, env.From.Address)
 
 		// Response path: deliver to waiter
 		//
 		// IMPORTANT:
-		// - sendStoreTo waits for STORE_OK
-		// - sendFindValueTo waits for FIND_VALUE_OK (with either Value or Contacts)
+		// - sendStoreTo waits for STORE_OK (or STORE_ERR, a refusal)
+		// - sendFindValueTo waits for FIND_VALUE_OK (Value and/or Contacts)
 		// If we don't forward these, callers will time out spuriously.
 		if env.Type == msgPong || env.Type == msgFindNodeOK ||
-			env.Type == msgFindValueOK || env.Type == msgStoreOK {
+			env.Type == msgFindValueOK || env.Type == msgStoreOK || env.Type == msgStoreErr {
+			// The first reply claims the entry, so the waiter's one-slot
+			// channel is always free for it and any duplicate (a retransmit's
+			// second PONG, say) finds nothing and is counted late below.
 			network.mu.Lock()
-			ch := network.inflight[env.MsgID]
+			p := network.inflight[env.MsgID]
+			_, late := network.recent[env.MsgID]
+			if p != nil {
+				network.completeLocked(env.MsgID)
+			}
 			network.mu.Unlock()
-			if ch != nil {
-				select {
-				case ch <- env:
-				default:
-				}
+			if p != nil {
+				p.ch <- env
+				network.latency.observe(p.typ, time.Since(p.sent))
+				p.op.received(n)
 				continue
 			}
+			if late {
+				network.late.Add(1)
+				network.debugf("[NET] late %s msg=%s seq=%d dropped", env.Type, env.MsgID, env.Seq)
+			} else {
+				network.unexpected.Add(1)
+				network.debugf("[NET] unexpected %s msg=%s seq=%d dropped", env.Type, env.MsgID, env.Seq)
+			}
+			continue
 		}
 
 		// Request path: dispatch to handlers
+		network.rpcs.countReceived(env.Type)
 		switch env.Type {
 		case msgPing:
-			network.handlePing(env, src)
+			network.handlePing(env, conn, src)
 		case msgFindNode:
-			network.handleFindNode(env, src)
+			network.handleFindNode(env, conn, src)
 		case msgStore:
-			network.handleStore(env, src)
-		case msgFindValue:
-			network.handleFindValue(env, src)
+			network.handleStore(env, conn, src)
+		case msgFindValue, msgFindMeta:
+			network.handleFindValue(env, conn, src)
 		default:
 			// ignore unknown types
 		}
 	}
 }
 
-// PING handler -> PONG
-func (network *Network) handlePing(env envelope, src *net.UDPAddr) {
-	// Learn/refresh sender in our routing table
-	if contact, err := env.From.toContact(); err == nil &&
-		network.kademlia != nil && network.kademlia.routingTable != nil {
+// learnSender adds (or refreshes) a request's sender in our routing table,
+// unless it is a client-mode node, which asks but never serves.
+func (network *Network) learnSender(env envelope) {
+	if env.Client || network.kademlia == nil || network.kademlia.routingTable == nil {
+		return
+	}
+	if contact, err := env.From.toContact(); err == nil {
 		network.kademlia.routingTable.AddContact(contact)
 	}
+}
+
+// learnRelayed adds contacts some peer told us about (in FIND_NODE_OK or
+// FIND_VALUE_OK) to our routing table. Under WithKeyDerivedID relayed
+// contacts carry no key, so each one we don't know yet is PINGed first (in
+// parallel, waiting for all) and added under the ID its signed PONG proves;
+// one that doesn't answer is left out.
+func (network *Network) learnRelayed(cs []Contact) {
+	if network.kademlia == nil || network.kademlia.routingTable == nil {
+		return
+	}
+	if !network.kademlia.keyDerivedID {
+		for _, c := range cs {
+			network.kademlia.routingTable.AddContact(c)
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	for _, c := range cs {
+		if network.knows(c) || c.ID.Equals(network.kademlia.me.ID) {
+			continue
+		}
+		wg.Add(1)
+		go func(c Contact) {
+			defer wg.Done()
+			if resp, err := network.ping(&c, network.kademlia.timeoutRPC); err == nil {
+				network.learnSender(resp)
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+// knows reports whether c is in our routing table at its address.
+func (network *Network) knows(c Contact) bool {
+	closest := network.kademlia.routingTable.FindClosestContacts(c.ID, 1)
+	return len(closest) == 1 && closest[0].ID.Equals(c.ID) && closest[0].Address == c.Address
+}
+
+// PING handler -> PONG
+func (network *Network) handlePing(env envelope, conn Transport, src *net.UDPAddr) {
+	network.learnSender(env)
 
 	// Reply
 	reply := envelope{
 		Type:  msgPong,
 		From:  fromContact(network.kademlia.me),
 		MsgID: env.MsgID, // echo the request ID back
+		Seq:   env.Seq,
+		Caps:  Capabilities(network.caps.Load()),
 	}
-	_ = network.send(src, reply)
-	fmt.Printf("[PING] from=%s -> PONG\n", env.From.Address)
+	_ = network.sendVia(conn, src, reply)
+	network.debugf("[PING] from=%s -> PONG", env.From.Address)
 }
 
 // FIND_NODE handler -> FIND_NODE_OK
-func (network *Network) handleFindNode(env envelope, src *net.UDPAddr) {
+func (network *Network) handleFindNode(env envelope, conn Transport, src *net.UDPAddr) {
 	if network.kademlia == nil || network.kademlia.routingTable == nil {
 		return
 	}
@@ -155,58 +493,43 @@ func (network *Network) handleFindNode(env envelope, src *net.UDPAddr) {
 	var target KademliaID
 	copy(target[:], idBytes)
 
-	contacts := network.kademlia.routingTable.FindClosestContacts(&target, bucketSize)
+	contacts := network.kademlia.routingTable.FindClosestContacts(&target, findNodeCount(env.Count))
 
 	reply := envelope{
 		Type:  msgFindNodeOK,
 		From:  fromContact(network.kademlia.me),
 		MsgID: env.MsgID,
+		Seq:   env.Seq,
 	}
 	reply.Contacts = make([]wireContact, 0, len(contacts))
 	for _, c := range contacts {
 		reply.Contacts = append(reply.Contacts, fromContact(c))
 	}
-	_ = network.send(src, reply)
-	fmt.Printf("[FIND_NODE] from=%s target=%s returning %d contacts\n", env.From.Address, env.TargetID, len(contacts))
+	_ = network.sendVia(conn, src, reply)
+	network.debugf("[FIND_NODE] from=%s target=%s returning %d contacts", env.From.Address, env.TargetID, len(contacts))
 }
 
-// -------- Public methods kept from your skeleton --------
+// maxFindNodeCount caps how many contacts one FIND_NODE_OK carries, keeping
+// the reply well inside a datagram.
+const maxFindNodeCount = 4 * bucketSize
 
-// SendPingMessage sends a PING to the given peer and waits for PONG.
-func (network *Network) SendPingMessage(contact *Contact) {
-	fmt.Printf("[PING=>] to=%s\n", contact.Address)
-	if contact == nil || contact.Address == "" {
-		return
+// findNodeCount is how many contacts to answer a FIND_NODE asking for n.
+func findNodeCount(n int) int {
+	if n <= 0 {
+		return bucketSize
 	}
-	dst, err := net.ResolveUDPAddr("udp", contact.Address)
-	if err != nil {
-		return
-	}
-	env := envelope{
-		Type:  msgPing,
-		From:  fromContact(network.kademlia.me),
-		MsgID: network.nextMsgID(),
-	}
-	ch := make(chan envelope, 1)
-	network.mu.Lock()
-	network.inflight[env.MsgID] = ch
-	network.mu.Unlock()
-	defer func() {
-		network.mu.Lock()
-		delete(network.inflight, env.MsgID)
-		network.mu.Unlock()
-	}()
+	return min(n, maxFindNodeCount)
+}
 
-	_ = network.send(dst, env)
+// -------- Public methods kept from your skeleton --------
 
+// SendPingMessage sends a PING to the given peer and waits for PONG.
+func (network *Network) SendPingMessage(contact *Contact) {
+	network.debugf("[PING=>] to=%s", contact.Address)
 	// Update our routing table only on success
-	select {
-	case <-ch:
-		if network.kademlia != nil && network.kademlia.routingTable != nil {
-			network.kademlia.routingTable.AddContact(*contact)
-		}
-	case <-time.After(800 * time.Millisecond):
-		// timeout: treat as failure, do nothing
+	if _, err := network.ping(contact, 800*time.Millisecond); err == nil &&
+		network.kademlia != nil && network.kademlia.routingTable != nil {
+		network.kademlia.routingTable.AddContact(*contact)
 	}
 }
 
@@ -214,42 +537,98 @@ func (network *Network) SendPingMessage(contact *Contact) {
 // NOTE: Unlike SendPingMessage, callers expect a boolean and we avoid side-effects
 // beyond the usual routingTable refresh on success.
 func (network *Network) PingWait(contact *Contact, timeout time.Duration) bool {
-	if contact == nil || contact.Address == "" {
+	if _, err := network.ping(contact, timeout); err != nil {
 		return false
 	}
+	// handlePing already refreshed the sender in our table; also keep the callee.
+	if network.kademlia != nil && network.kademlia.routingTable != nil {
+		network.kademlia.routingTable.AddContact(*contact)
+	}
+	return true
+}
+
+// Ping sends a PING and returns the responder as its PONG describes it, so
+// a caller that only knows an address (a bootstrap, say) learns the real ID.
+// contact.ID is not checked and may be a placeholder. The responder is
+// added to the routing table under that real contact, unless it is in client
+// mode.
+func (network *Network) Ping(contact *Contact) (Contact, error) {
+	resp, err := network.ping(contact, 800*time.Millisecond)
+	if err != nil {
+		return Contact{}, err
+	}
+	peer, err := resp.From.toContact()
+	if err != nil {
+		return Contact{}, fmt.Errorf("ping %s: bad PONG sender: %w", contact.Address, err)
+	}
+	network.learnSender(resp)
+	return peer, nil
+}
+
+// ping sends one PING to contact and waits up to timeout for the PONG,
+// recording the round trip and the capabilities it advertises.
+func (network *Network) ping(contact *Contact, timeout time.Duration) (envelope, error) {
+	if contact == nil || contact.Address == "" {
+		return envelope{}, fmt.Errorf("bad peer")
+	}
 	dst, err := net.ResolveUDPAddr("udp", contact.Address)
 	if err != nil {
-		return false
+		return envelope{}, err
 	}
 	env := envelope{
 		Type:  msgPing,
 		From:  fromContact(network.kademlia.me),
 		MsgID: network.nextMsgID(),
 	}
-	ch := make(chan envelope, 1)
-	network.mu.Lock()
-	network.inflight[env.MsgID] = ch
-	network.mu.Unlock()
-	defer func() {
-		network.mu.Lock()
-		delete(network.inflight, env.MsgID)
-		network.mu.Unlock()
-	}()
+	ch, done := network.register(&env)
+	defer done()
+	start := time.Now()
 	if err := network.send(dst, env); err != nil {
-		return false
+		return envelope{}, err
 	}
 	select {
-	case <-ch:
-		// handlePing already refreshed the sender in our table; also keep the callee.
-		if network.kademlia != nil && network.kademlia.routingTable != nil {
-			network.kademlia.routingTable.AddContact(*contact)
-		}
-		return true
+	case resp := <-ch:
+		network.recordRTT(contact.Address, time.Since(start))
+		network.recordCaps(resp)
+		return resp, nil
 	case <-time.After(timeout):
-		return false
+		network.rpcs.timeouts.Add(1)
+		return envelope{}, context.DeadlineExceeded
 	}
 }
 
+// recordCaps remembers the capabilities a PONG advertised for its sender.
+func (network *Network) recordCaps(pong envelope) {
+	c, err := pong.From.toContact()
+	if err != nil {
+		return
+	}
+	network.capsMu.Lock()
+	network.peerCaps[*c.ID] = pong.Caps
+	network.capsMu.Unlock()
+}
+
+func (network *Network) recordRTT(addr string, d time.Duration) {
+	network.rttMu.Lock()
+	network.rtt[addr] = d
+	network.rttMu.Unlock()
+}
+
+func (network *Network) lastRTT(addr string) (time.Duration, bool) {
+	network.rttMu.RLock()
+	defer network.rttMu.RUnlock()
+	d, ok := network.rtt[addr]
+	return d, ok
+}
+
+// peerCapabilities returns what id advertised in its last PONG.
+func (network *Network) peerCapabilities(id *KademliaID) (Capabilities, bool) {
+	network.capsMu.RLock()
+	defer network.capsMu.RUnlock()
+	c, ok := network.peerCaps[*id]
+	return c, ok
+}
+
 // SendFindContactMessage asks the *peer* "contact" for nodes close to *contact.ID*.
 // (Good for simple refresh. For iterative lookup with an arbitrary target, we add
 // a more explicit helper below.)
@@ -262,7 +641,24 @@ func (network *Network) SendFindContactMessage(contact *Contact) {
 
 // Explicit helper used by LookupContact: ask "peer" for nodes close to "target".
 func (network *Network) SendFindContactMessageTo(peer *Contact, target *Contact) ([]Contact, error) {
-	fmt.Printf("[FIND_NODE=>] peer=%s target=%s\n", peer.Address, target.ID.String())
+	return network.sendFindNodeTo(peer, target, nil)
+}
+
+// sendFindNodeTo is SendFindContactMessageTo with the traffic charged to op.
+func (network *Network) sendFindNodeTo(peer *Contact, target *Contact, op *opAccount) ([]Contact, error) {
+	return network.sendFindNodeToCtx(context.Background(), peer, target, op)
+}
+
+// sendFindNodeToCtx is sendFindNodeTo that also gives up with ctx.Err() once
+// ctx is done. The Ctx variants below do the same for their requests.
+func (network *Network) sendFindNodeToCtx(ctx context.Context, peer *Contact, target *Contact, op *opAccount) ([]Contact, error) {
+	return network.sendFindNodeCountCtx(ctx, peer, target, 0, op)
+}
+
+// sendFindNodeCountCtx is sendFindNodeToCtx asking for up to count contacts
+// instead of the responder's default bucketSize.
+func (network *Network) sendFindNodeCountCtx(ctx context.Context, peer *Contact, target *Contact, count int, op *opAccount) ([]Contact, error) {
+	network.debugf("[FIND_NODE=>] peer=%s target=%s", peer.Address, target.ID.String())
 	if peer == nil || peer.Address == "" || target == nil || target.ID == nil {
 		return nil, fmt.Errorf("bad args")
 	}
@@ -275,16 +671,12 @@ func (network *Network) SendFindContactMessageTo(peer *Contact, target *Contact)
 		From:     fromContact(network.kademlia.me),
 		MsgID:    network.nextMsgID(),
 		TargetID: target.ID.String(),
+		Count:    count,
+		op:       op,
 	}
-	ch := make(chan envelope, 1)
-	network.mu.Lock()
-	network.inflight[env.MsgID] = ch
-	network.mu.Unlock()
-	defer func() {
-		network.mu.Lock()
-		delete(network.inflight, env.MsgID)
-		network.mu.Unlock()
-	}()
+	defer network.acquireRPC()()
+	ch, done := network.register(&env)
+	defer done()
 
 	if err := network.send(dst, env); err != nil {
 		return nil, err
@@ -300,12 +692,9 @@ func (network *Network) SendFindContactMessageTo(peer *Contact, target *Contact)
 			c, err := wc.toContact()
 			if err == nil {
 				contacts = append(contacts, c)
-				// Learn discovered contacts
-				if network.kademlia != nil && network.kademlia.routingTable != nil {
-					network.kademlia.routingTable.AddContact(c)
-				}
 			}
 		}
+		network.learnRelayed(contacts) // learn discovered contacts
 		// Learn the responder
 		if c, err := resp.From.toContact(); err == nil &&
 			network.kademlia != nil && network.kademlia.routingTable != nil {
@@ -314,72 +703,277 @@ func (network *Network) SendFindContactMessageTo(peer *Contact, target *Contact)
 		return contacts, nil
 
 	case <-time.After(800 * time.Millisecond):
+		network.rpcs.timeouts.Add(1)
 		return nil, context.DeadlineExceeded
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
 // ---------- M2 handlers ----------
 
-func (network *Network) handleStore(env envelope, src *net.UDPAddr) {
-	// learn sender
-	if c, err := env.From.toContact(); err == nil && network.kademlia != nil && network.kademlia.routingTable != nil {
-		network.kademlia.routingTable.AddContact(c)
+func (network *Network) handleStore(env envelope, conn Transport, src *net.UDPAddr) {
+	if env.Append {
+		network.handleAppend(env, conn, src)
+		return
+	}
+	if env.Keyed && !network.kademlia.keyedRecords {
+		network.rejectStore(env, conn, src, rejectKeyedOff)
+		return
+	}
+	// Keys are content hashes: refuse (and don't learn) a sender whose value
+	// doesn't match, rather than let it poison the key. A keyed record (PutAt)
+	// must instead be the record its key names.
+	if env.Keyed {
+		if _, err := parseKeyHex(env.KeyHex); err != nil || !keyedFitsKey(env.KeyHex, env.Value) {
+			network.rejectStore(env, conn, src, rejectMismatch)
+			return
+		}
+	} else if env.KeyHex != "" && len(env.Value) > 0 && !contentMatchesKey(env.KeyHex, env.Value) {
+		// A chunk manifest can't hash to its key: it is held only once the
+		// value it lists has been fetched and does (checkManifest).
+		m, ok := decodeManifest(env.Value)
+		if !ok {
+			network.rejectStore(env, conn, src, rejectMismatch)
+			return
+		}
+		if !network.kademlia.clientMode && !network.kademlia.holdsValue(env.KeyHex, env.Value) {
+			network.checkManifest(env, conn, src, m)
+			return
+		}
+	}
+	network.acceptStore(env, conn, src)
+}
+
+// acceptStore stores and acknowledges a STORE whose value has been checked
+// against its key, unless the node refuses to hold it.
+func (network *Network) acceptStore(env envelope, conn Transport, src *net.UDPAddr) {
+	network.learnSender(env)
+	if network.kademlia.clientMode {
+		network.rejectStore(env, conn, src, rejectClient)
+		return
+	}
+	if network.kademlia.valueTooLarge(len(env.Value)) {
+		network.rejectStore(env, conn, src, rejectTooLarge)
+		return
 	}
-	// store locally
-	if env.KeyHex != "" && len(env.Value) > 0 && network.kademlia != nil {
-		network.kademlia.storeLocal(env.KeyHex, env.Value)
+	// store locally (provisionally while warming up after a join)
+	provisional := env.KeyHex != "" && len(env.Value) > 0 && network.kademlia.warmingUp()
+	if env.KeyHex != "" && network.kademlia.storeFull(env.KeyHex, len(env.Value), provisional) {
+		network.rejectStore(env, conn, src, rejectFull)
+		return
+	}
+	if env.KeyHex != "" && len(env.Value) > 0 {
+		var prov Provenance // recorded once the value is in the store
+		if from, err := env.From.toContact(); err == nil {
+			prov = Provenance{Source: Stored, Peer: from}
+		}
+		if provisional {
+			network.kademlia.holdProvisional(env.KeyHex, env.Value, prov)
+		} else {
+			network.kademlia.storeLocalFrom(env.KeyHex, env.Value, network.kademlia.storeTTL, prov)
+		}
 	}
 	// ack
-	_ = network.send(src, envelope{
+	_ = network.sendVia(conn, src, envelope{
+		Type:  msgStoreOK,
+		From:  fromContact(network.kademlia.me),
+		MsgID: env.MsgID,
+		Seq:   env.Seq,
+	})
+	network.debugf("[STORE] from=%s key=%s saved=true provisional=%v", env.From.Address, env.KeyHex, provisional)
+}
+
+// maxManifestChecks bounds the manifest STOREs a node checks at once; each
+// costs a lookup per chunk. More are refused as rejectBusy.
+const maxManifestChecks = 8
+
+// checkManifest answers a manifest STORE once the value the manifest lists
+// has been fetched and matched against the key: it is then stored like any
+// other, or refused as not matching. The check runs off the read loop (its
+// lookups need the loop's replies) and gets two RPC timeouts, so one peer
+// that never answers doesn't fail it; a retransmitted copy of a STORE under
+// check is ignored.
+func (network *Network) checkManifest(env envelope, conn Transport, src *net.UDPAddr, m manifest) {
+	id := env.From.Address + "/" + env.MsgID
+	network.checkMu.Lock()
+	if _, dup := network.checking[id]; dup {
+		network.checkMu.Unlock()
+		return
+	}
+	if len(network.checking) >= maxManifestChecks {
+		network.checkMu.Unlock()
+		network.rejectStore(env, conn, src, rejectBusy)
+		return
+	}
+	network.checking[id] = struct{}{}
+	network.checkMu.Unlock()
+
+	go func() {
+		defer func() {
+			network.checkMu.Lock()
+			delete(network.checking, id)
+			network.checkMu.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 2*network.kademlia.timeoutRPC)
+		defer cancel()
+		if _, _, err := network.kademlia.assemble(ctx, env.KeyHex, m, nil, false, nil); err != nil {
+			network.infof("[STORE] from=%s key=%s manifest check failed: %v", env.From.Address, env.KeyHex, err)
+			network.rejectStore(env, conn, src, rejectMismatch)
+			return
+		}
+		network.acceptStore(env, conn, src)
+	}()
+}
+
+// handleAppend serves a STORE marked Append: the value joins the key's set
+// instead of replacing anything. Append values needn't hash to the key, and
+// nodes that haven't opted in (WithAppendKeys) refuse them.
+func (network *Network) handleAppend(env envelope, conn Transport, src *net.UDPAddr) {
+	if !network.kademlia.appendKeys {
+		network.rejectStore(env, conn, src, rejectAppendOff)
+		return
+	}
+	network.learnSender(env)
+	if network.kademlia.clientMode {
+		network.rejectStore(env, conn, src, rejectClient)
+		return
+	}
+	if _, err := parseKeyHex(env.KeyHex); err != nil || len(env.Value) == 0 {
+		network.rejectStore(env, conn, src, rejectMismatch)
+		return
+	}
+	added, reason := network.kademlia.appendLocal(env.KeyHex, env.Value)
+	if reason != "" {
+		network.rejectStore(env, conn, src, reason)
+		return
+	}
+	_ = network.sendVia(conn, src, envelope{
 		Type:  msgStoreOK,
 		From:  fromContact(network.kademlia.me),
 		MsgID: env.MsgID,
+		Seq:   env.Seq,
 	})
-	fmt.Printf("[STORE] from=%s key=%s saved=true\n", env.From.Address, env.KeyHex)
+	network.debugf("[APPEND] from=%s key=%s added=%v", env.From.Address, env.KeyHex, added)
 }
 
-func (network *Network) handleFindValue(env envelope, src *net.UDPAddr) {
+// rejectStore answers a STORE with STORE_ERR carrying reason.
+func (network *Network) rejectStore(env envelope, conn Transport, src *net.UDPAddr, reason string) {
+	_ = network.sendVia(conn, src, envelope{
+		Type:   msgStoreErr,
+		From:   fromContact(network.kademlia.me),
+		MsgID:  env.MsgID,
+		Seq:    env.Seq,
+		Reason: reason,
+	})
+	network.infof("[STORE] from=%s key=%s REJECT: %s", env.From.Address, env.KeyHex, reason)
+}
+
+func (network *Network) handleFindValue(env envelope, conn Transport, src *net.UDPAddr) {
 	if network.kademlia == nil || network.kademlia.routingTable == nil {
 		return
 	}
-	// If we have the value locally, return it.
-	if val, ok := network.kademlia.loadLocal(env.KeyHex); ok {
-		_ = network.send(src, envelope{
+	if env.Append && env.Type == msgFindValue {
+		// The caller already located the replicas: just list our set.
+		_ = network.sendVia(conn, src, envelope{
 			Type:   msgFindValueOK,
 			From:   fromContact(network.kademlia.me),
 			MsgID:  env.MsgID,
+			Seq:    env.Seq,
 			KeyHex: env.KeyHex,
-			Value:  val,
+			Values: network.kademlia.loadAppended(env.KeyHex),
+		})
+		return
+	}
+	// If we have the value locally, return it (or just its size for META).
+	if val, ok := network.kademlia.loadLocal(env.KeyHex); ok && env.Type == msgFindMeta {
+		_ = network.sendVia(conn, src, envelope{
+			Type:   msgFindValueOK,
+			From:   fromContact(network.kademlia.me),
+			MsgID:  env.MsgID,
+			Seq:    env.Seq,
+			KeyHex: env.KeyHex,
+			Found:  true,
+			Size:   len(val),
 		})
-		fmt.Printf("[FIND_VALUE] HIT key=%s from=%s returning VALUE\n", env.KeyHex, env.From.Address)
+		network.debugf("[FIND_VALUE_META] HIT key=%s from=%s size=%d", env.KeyHex, env.From.Address, len(val))
+		return
+	} else if ok {
+		reply := envelope{
+			Type:   msgFindValueOK,
+			From:   fromContact(network.kademlia.me),
+			MsgID:  env.MsgID,
+			Seq:    env.Seq,
+			KeyHex: env.KeyHex,
+			Value:  val,
+		}
+		if env.WithContacts {
+			reply.Contacts = network.closestTo(env.KeyHex)
+		}
+		_ = network.sendVia(conn, src, reply)
+		network.debugf("[FIND_VALUE] HIT key=%s from=%s returning VALUE", env.KeyHex, env.From.Address)
 		return
 	}
 
 	// Otherwise return closest contacts to the key (treat key as ID)
 	if len(env.KeyHex) == 40 {
-		b, _ := hex.DecodeString(env.KeyHex)
-		var target KademliaID
-		copy(target[:], b)
-		contacts := network.kademlia.routingTable.FindClosestContacts(&target, bucketSize)
-		out := make([]wireContact, 0, len(contacts))
-		for _, c := range contacts {
-			out = append(out, fromContact(c))
-		}
-		_ = network.send(src, envelope{
+		_ = network.sendVia(conn, src, envelope{
 			Type:     msgFindValueOK,
 			From:     fromContact(network.kademlia.me),
 			MsgID:    env.MsgID,
+			Seq:      env.Seq,
 			KeyHex:   env.KeyHex,
-			Contacts: out,
+			Contacts: network.closestTo(env.KeyHex),
 		})
 	}
 
 }
 
+// closestTo lists the K contacts in our table closest to a 40-hex key, for
+// the wire.
+func (network *Network) closestTo(keyHex string) []wireContact {
+	b, _ := hex.DecodeString(keyHex)
+	var target KademliaID
+	copy(target[:], b)
+	contacts := network.kademlia.routingTable.FindClosestContacts(&target, bucketSize)
+	out := make([]wireContact, 0, len(contacts))
+	for _, c := range contacts {
+		out = append(out, fromContact(c))
+	}
+	return out
+}
+
 // ---------- M2 client helpers (internal) ----------
 
-func (network *Network) sendStoreTo(peer *Contact, keyHex string, value []byte, timeout time.Duration) error {
-	fmt.Printf("[STORE=>] to=%s key=%s\n", peer.Address, keyHex)
+func (network *Network) sendStoreTo(peer *Contact, keyHex string, value []byte, timeout time.Duration, op *opAccount) error {
+	return network.sendStoreToCtx(context.Background(), peer, keyHex, value, timeout, op)
+}
+
+func (network *Network) sendStoreToCtx(ctx context.Context, peer *Contact, keyHex string, value []byte, timeout time.Duration, op *opAccount) error {
+	network.debugf("[STORE=>] to=%s key=%s", peer.Address, keyHex)
+	return network.storeRPC(ctx, peer, envelope{
+		Type:   msgStore,
+		KeyHex: keyHex,
+		Value:  value,
+		Keyed:  keyedFitsKey(keyHex, value),
+		op:     op,
+	}, timeout)
+}
+
+// sendAppendToCtx asks peer to add value to the append set under keyHex.
+func (network *Network) sendAppendToCtx(ctx context.Context, peer *Contact, keyHex string, value []byte, timeout time.Duration, op *opAccount) error {
+	network.debugf("[APPEND=>] to=%s key=%s", peer.Address, keyHex)
+	return network.storeRPC(ctx, peer, envelope{
+		Type:   msgStore,
+		KeyHex: keyHex,
+		Value:  value,
+		Append: true,
+		op:     op,
+	}, timeout)
+}
+
+// storeRPC sends the STORE env to peer and waits for STORE_OK or STORE_ERR.
+func (network *Network) storeRPC(ctx context.Context, peer *Contact, env envelope, timeout time.Duration) error {
 	if peer == nil || peer.Address == "" {
 		return fmt.Errorf("bad peer")
 	}
@@ -387,67 +981,171 @@ func (network *Network) sendStoreTo(peer *Contact, keyHex string, value []byte,
 	if err != nil {
 		return err
 	}
+	keyHex := env.KeyHex
+	env.From = fromContact(network.kademlia.me)
+	env.MsgID = network.nextMsgID()
+	defer network.acquireRPC()()
+	ch, done := network.register(&env)
+	defer done()
+
+	if err := network.send(dst, env); err != nil {
+		return err
+	}
+	// A lost STORE would cost the replica, so it is sent again (same MsgID:
+	// whichever copy is answered first completes it) halfway to the timeout.
+	retransmit := time.NewTimer(timeout / 2)
+	defer retransmit.Stop()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case resp := <-ch:
+			if resp.Type == msgStoreErr {
+				return &StoreRejectedError{Peer: *peer, Reason: resp.Reason}
+			}
+			return nil
+		case <-retransmit.C:
+			network.debugf("[STORE=>] retransmit to=%s key=%s msg=%s", peer.Address, keyHex, env.MsgID)
+			if err := network.send(dst, env); err != nil {
+				return err
+			}
+		case <-deadline:
+			network.rpcs.timeouts.Add(1)
+			return context.DeadlineExceeded
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (network *Network) sendFindValueTo(peer *Contact, keyHex string, timeout time.Duration, op *opAccount) (val []byte, contacts []Contact, err error) {
+	return network.sendFindValueToCtx(context.Background(), peer, keyHex, false, timeout, op)
+}
+
+// sendFindValueToCtx sends FIND_VALUE and returns the value on a hit and the
+// responder's closest contacts otherwise; with withContacts, a hit carries
+// those contacts too.
+func (network *Network) sendFindValueToCtx(ctx context.Context, peer *Contact, keyHex string, withContacts bool, timeout time.Duration, op *opAccount) (val []byte, contacts []Contact, err error) {
+	network.debugf("[FIND_VALUE=>] to=%s key=%s", peer.Address, keyHex)
+	if peer == nil || peer.Address == "" {
+		return nil, nil, fmt.Errorf("bad peer")
+	}
+	dst, err := net.ResolveUDPAddr("udp", peer.Address)
+	if err != nil {
+		return nil, nil, err
+	}
 	env := envelope{
-		Type:   msgStore,
+		Type:         msgFindValue,
+		From:         fromContact(network.kademlia.me),
+		MsgID:        network.nextMsgID(),
+		KeyHex:       keyHex,
+		WithContacts: withContacts,
+		op:           op,
+	}
+	defer network.acquireRPC()()
+	ch, done := network.register(&env)
+	defer done()
+
+	if err := network.send(dst, env); err != nil {
+		return nil, nil, err
+	}
+	select {
+	case resp := <-ch:
+		// Learn responder + contacts we got back
+		if c, err2 := resp.From.toContact(); err2 == nil && network.kademlia != nil && network.kademlia.routingTable != nil {
+			network.kademlia.routingTable.AddContact(c)
+		}
+		out := make([]Contact, 0, len(resp.Contacts))
+		for _, wc := range resp.Contacts {
+			if c, err2 := wc.toContact(); err2 == nil {
+				out = append(out, c)
+			}
+		}
+		network.learnRelayed(out)
+		if len(resp.Value) > 0 {
+			return resp.Value, out, nil
+		}
+		return nil, out, nil
+	case <-time.After(timeout):
+		network.rpcs.timeouts.Add(1)
+		return nil, nil, context.DeadlineExceeded
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+// sendFindAllToCtx sends FIND_VALUE for the append set under keyHex and
+// returns whatever values peer holds there (none is not an error).
+func (network *Network) sendFindAllToCtx(ctx context.Context, peer *Contact, keyHex string, timeout time.Duration, op *opAccount) ([][]byte, error) {
+	network.debugf("[FIND_ALL=>] to=%s key=%s", peer.Address, keyHex)
+	if peer == nil || peer.Address == "" {
+		return nil, fmt.Errorf("bad peer")
+	}
+	dst, err := net.ResolveUDPAddr("udp", peer.Address)
+	if err != nil {
+		return nil, err
+	}
+	env := envelope{
+		Type:   msgFindValue,
 		From:   fromContact(network.kademlia.me),
 		MsgID:  network.nextMsgID(),
 		KeyHex: keyHex,
-		Value:  value,
+		Append: true,
+		op:     op,
 	}
-	ch := make(chan envelope, 1)
-	network.mu.Lock()
-	network.inflight[env.MsgID] = ch
-	network.mu.Unlock()
-	defer func() { network.mu.Lock(); delete(network.inflight, env.MsgID); network.mu.Unlock() }()
+	defer network.acquireRPC()()
+	ch, done := network.register(&env)
+	defer done()
 
 	if err := network.send(dst, env); err != nil {
-		return err
+		return nil, err
 	}
 	select {
-	case <-ch:
-		return nil
+	case resp := <-ch:
+		return resp.Values, nil
 	case <-time.After(timeout):
-		return context.DeadlineExceeded
+		network.rpcs.timeouts.Add(1)
+		return nil, context.DeadlineExceeded
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
-func (network *Network) sendFindValueTo(peer *Contact, keyHex string, timeout time.Duration) (val []byte, contacts []Contact, err error) {
-	fmt.Printf("[FIND_VALUE=>] to=%s key=%s\n", peer.Address, keyHex)
+// sendFindMetaTo is sendFindValueTo for FIND_VALUE_META: on a hit it reports
+// found and the value size instead of returning the bytes.
+func (network *Network) sendFindMetaTo(peer *Contact, keyHex string, timeout time.Duration, op *opAccount) (found bool, size int, contacts []Contact, err error) {
+	return network.sendFindMetaToCtx(context.Background(), peer, keyHex, timeout, op)
+}
+
+func (network *Network) sendFindMetaToCtx(ctx context.Context, peer *Contact, keyHex string, timeout time.Duration, op *opAccount) (found bool, size int, contacts []Contact, err error) {
+	network.debugf("[FIND_VALUE_META=>] to=%s key=%s", peer.Address, keyHex)
 	if peer == nil || peer.Address == "" {
-		return nil, nil, fmt.Errorf("bad peer")
+		return false, 0, nil, fmt.Errorf("bad peer")
 	}
 	dst, err := net.ResolveUDPAddr("udp", peer.Address)
 	if err != nil {
-		return nil, nil, err
+		return false, 0, nil, err
 	}
 	env := envelope{
-		Type:   msgFindValue,
+		Type:   msgFindMeta,
 		From:   fromContact(network.kademlia.me),
 		MsgID:  network.nextMsgID(),
 		KeyHex: keyHex,
+		op:     op,
 	}
-	ch := make(chan envelope, 1)
-	network.mu.Lock()
-	network.inflight[env.MsgID] = ch
-	network.mu.Unlock()
-	defer func() { network.mu.Lock(); delete(network.inflight, env.MsgID); network.mu.Unlock() }()
+	defer network.acquireRPC()()
+	ch, done := network.register(&env)
+	defer done()
 
 	if err := network.send(dst, env); err != nil {
-		return nil, nil, err
+		return false, 0, nil, err
 	}
 	select {
 	case resp := <-ch:
-		// Learn responder + contacts we got back
 		if c, err2 := resp.From.toContact(); err2 == nil && network.kademlia != nil && network.kademlia.routingTable != nil {
 			network.kademlia.routingTable.AddContact(c)
 		}
-		for _, wc := range resp.Contacts {
-			if c, err2 := wc.toContact(); err2 == nil && network.kademlia != nil && network.kademlia.routingTable != nil {
-				network.kademlia.routingTable.AddContact(c)
-			}
-		}
-		if len(resp.Value) > 0 {
-			return resp.Value, nil, nil
+		if resp.Found {
+			return true, resp.Size, nil, nil
 		}
 		out := make([]Contact, 0, len(resp.Contacts))
 		for _, wc := range resp.Contacts {
@@ -455,9 +1153,13 @@ func (network *Network) sendFindValueTo(peer *Contact, keyHex string, timeout ti
 				out = append(out, c)
 			}
 		}
-		return nil, out, nil
+		network.learnRelayed(out)
+		return false, 0, out, nil
 	case <-time.After(timeout):
-		return nil, nil, context.DeadlineExceeded
+		network.rpcs.timeouts.Add(1)
+		return false, 0, nil, context.DeadlineExceeded
+	case <-ctx.Done():
+		return false, 0, nil, ctx.Err()
 	}
 }
 
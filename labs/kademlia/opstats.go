@@ -0,0 +1,45 @@
+package kademlia
+
+// opstats.go: per-call traffic accounting (PutWithStats, GetWithStats, ...).
+
+import "sync/atomic"
+
+// OpStats is the traffic one operation caused: requests it sent and replies
+// delivered back to it, in messages and encoded bytes. Pings issued by the
+// routing table on the side (LRU liveness probes) aren't attributed.
+type OpStats struct {
+	SentMsgs, RecvMsgs   int64
+	SentBytes, RecvBytes int64
+}
+
+// opAccount accumulates OpStats for one call. Requests carry it in their
+// envelope, the inflight entry keeps it for the reply, and the parallel
+// workers of a lookup all add to it, hence the atomics. nil means the call
+// isn't being accounted.
+type opAccount struct {
+	sentMsgs, recvMsgs   atomic.Int64
+	sentBytes, recvBytes atomic.Int64
+}
+
+func (a *opAccount) sent(n int) {
+	if a != nil {
+		a.sentMsgs.Add(1)
+		a.sentBytes.Add(int64(n))
+	}
+}
+
+func (a *opAccount) received(n int) {
+	if a != nil {
+		a.recvMsgs.Add(1)
+		a.recvBytes.Add(int64(n))
+	}
+}
+
+func (a *opAccount) stats() OpStats {
+	return OpStats{
+		SentMsgs:  a.sentMsgs.Load(),
+		RecvMsgs:  a.recvMsgs.Load(),
+		SentBytes: a.sentBytes.Load(),
+		RecvBytes: a.recvBytes.Load(),
+	}
+}
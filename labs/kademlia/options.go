@@ -0,0 +1,269 @@
+package kademlia
+
+// options.go: functional options accepted by NewKademlia.
+
+import (
+	"crypto/ed25519"
+	"io"
+	"time"
+)
+
+// Option tweaks a node's configuration before its network and background
+// goroutines start. Pass any number of them to NewKademlia.
+type Option func(*Kademlia)
+
+// WithRepublish turns the periodic republisher on or off (default on).
+// When off, origin keys are still tracked locally; they're just never re-pushed.
+func WithRepublish(enabled bool) Option {
+	return func(k *Kademlia) { k.republishEnabled = enabled }
+}
+
+// WithRepublishInterval sets how often origin keys are republished.
+func WithRepublishInterval(d time.Duration) Option {
+	return func(k *Kademlia) {
+		if d > 0 {
+			k.republishInterval.Store(int64(d))
+		}
+	}
+}
+
+// WithRepublishJitter makes each republish interval vary at random by up to
+// fraction of itself either way (default 0.1, i.e. ±10%), so nodes started
+// together drift apart instead of republishing in bursts. 0 republishes on
+// the exact interval; fractions outside [0, 1) are ignored.
+func WithRepublishJitter(fraction float64) Option {
+	return func(k *Kademlia) {
+		if fraction >= 0 && fraction < 1 {
+			k.republishJitter = fraction
+		}
+	}
+}
+
+// WithVerifyContent makes the node check that values hash (SHA-1) to their key
+// before accepting them; Get skips responders whose bytes don't match.
+func WithVerifyContent(enabled bool) Option {
+	return func(k *Kademlia) { k.verifyContent = enabled }
+}
+
+// WithGetCaching controls whether Get stores fetched values locally and
+// path-caches them at the closest node it queried (default on). Turning it
+// off makes the node a pure consumer that never serves what it reads.
+func WithGetCaching(enabled bool) Option {
+	return func(k *Kademlia) { k.getCaching = enabled }
+}
+
+// WithStoreCapacity caps how many values the node keeps. Storing past the
+// cap evicts the least recently stored-or-served cached value (reason
+// CapacityEvicted); origin and pinned values are never evicted. n <= 0 (the
+// default) means unlimited.
+func WithStoreCapacity(n int) Option {
+	return func(k *Kademlia) { k.maxValues = n }
+}
+
+// WithStoreTTL sets how long values stored for other nodes (STORE replicas
+// and copies cached by Get) are kept without being stored again; the
+// republisher refreshes them well within the 24h default. Values this node
+// originated never expire; append sets expire ttl after their last append.
+// ttl <= 0 disables expiry and the sweeper.
+func WithStoreTTL(ttl time.Duration) Option {
+	return func(k *Kademlia) { k.storeTTL = ttl }
+}
+
+// WithDataDir keeps a snapshot of the node in dir: NewKademlia loads it
+// (re-PINGing the saved contacts) and Close writes it back. See SaveState.
+func WithDataDir(dir string) Option {
+	return func(k *Kademlia) { k.dataDir = dir }
+}
+
+// WithStore backs the node's values and origin keys with s. Whatever s
+// already holds is loaded at startup, so a node restarted with the same ID
+// and Store keeps serving its data and republishing the keys it originated.
+func WithStore(s Store) Option {
+	return func(k *Kademlia) { k.persist = s }
+}
+
+// WithReplicationStrategy replaces the default K-closest replica placement.
+func WithReplicationStrategy(s ReplicationStrategy) Option {
+	return func(k *Kademlia) {
+		if s != nil {
+			k.replication = s
+		}
+	}
+}
+
+// WithRPCBudget caps how many FIND_NODE/FIND_VALUE/STORE requests the node
+// has outstanding at once, across all concurrent lookups, Puts and the
+// republisher. Callers over the cap wait for a slot. n <= 0 means unbounded.
+func WithRPCBudget(n int) Option {
+	return func(k *Kademlia) { k.rpcBudget = n }
+}
+
+// WithOutboundRate limits everything the node sends (requests and replies) to
+// perSecond messages, allowing bursts of up to burst. Sends over the rate are
+// delayed, not dropped, and counted in OutboundThrottled. perSecond <= 0
+// (the default) means unlimited.
+func WithOutboundRate(perSecond float64, burst int) Option {
+	return func(k *Kademlia) {
+		k.outboundRate = perSecond
+		k.outboundBurst = burst
+	}
+}
+
+// WithListenAddrs makes the node also listen on each "host:port" in addrs,
+// e.g. an IPv6 address next to an IPv4 one. All sockets serve the same node;
+// replies leave through the socket the request came in on, and requests go
+// out the first socket of the peer's address family. The advertised contact
+// keeps the primary address given to NewKademlia.
+func WithListenAddrs(addrs ...string) Option {
+	return func(k *Kademlia) { k.listenAddrs = append(k.listenAddrs, addrs...) }
+}
+
+// WithKeyedRecords lets the node hold keyed records: values stored with
+// PutAt under a key the publisher chose and read back with GetAt. Without it
+// the node refuses keyed STOREs, and PutAt fails; content keys are
+// unaffected.
+func WithKeyedRecords(enabled bool) Option {
+	return func(k *Kademlia) { k.keyedRecords = enabled }
+}
+
+// WithAppendKeys lets the node hold append keys: sets of distinct values
+// added with PutAppend and read back with GetAll. Without it the node
+// refuses append STOREs, and PutAppend fails; plain keys are unaffected.
+func WithAppendKeys(enabled bool) Option {
+	return func(k *Kademlia) { k.appendKeys = enabled }
+}
+
+// WithClientMode makes the node a pure client: it looks up, Puts and Gets
+// but holds no values. Put replicates without keeping a copy (so nothing
+// republishes it), Get never caches, incoming STOREs are refused, and its
+// envelopes are marked so peers leave it out of their routing tables and
+// never pick it as a replica.
+func WithClientMode(enabled bool) Option {
+	return func(k *Kademlia) { k.clientMode = enabled }
+}
+
+// WithTransport makes the node open its listen addresses with listen instead
+// of binding UDP sockets, e.g. (*MemNet).Listen to run a cluster in memory.
+func WithTransport(listen func(addr string) (Transport, error)) Option {
+	return func(k *Kademlia) { k.transport = listen }
+}
+
+// WithTrieRoutingTable backs the node's routing table with the prefix tree
+// from the Kademlia paper (buckets split only along our own ID) instead of
+// the default fixed array of 160 buckets.
+func WithTrieRoutingTable() Option {
+	return func(k *Kademlia) { k.trieRouting = true }
+}
+
+// WithAsyncReplication makes Put return as soon as the value is stored
+// locally, with State Pending, while the lookup and STOREs run in the
+// background. The default waits for replication and reports Complete.
+func WithAsyncReplication(enabled bool) Option {
+	return func(k *Kademlia) { k.asyncReplication = enabled }
+}
+
+// WithLookupTrace writes a line per round of every LookupContact and Get to
+// w: the batch queried with distances, the best live distance before and
+// after, and why the loop stopped (empty batch, no live contacts, converged,
+// value found). nil (the default) turns tracing off.
+func WithLookupTrace(w io.Writer) Option {
+	return func(k *Kademlia) { k.traceOut = w }
+}
+
+// WithPrefixedKeys makes Put return keys as KeySchemeSHA1 + the 40-hex
+// digest, so they stay unambiguous once other hash schemes exist. Every
+// lookup accepts both forms regardless; on the wire keys are always bare.
+func WithPrefixedKeys(enabled bool) Option {
+	return func(k *Kademlia) { k.prefixedKeys = enabled }
+}
+
+// WithForceReplicate makes Put always STORE to every target. By default a
+// Put of bytes the node already holds only probes the targets and STOREs to
+// the ones missing the key.
+func WithForceReplicate(enabled bool) Option {
+	return func(k *Kademlia) { k.forceReplicate = enabled }
+}
+
+// WithJoinWarmup holds back replica STOREs received after Join until the
+// routing table has minPeers contacts, for at most d. Held values are acked
+// (so origins don't retry) but neither served nor listed until warmup ends,
+// at which point they're stored normally. The node can still Get meanwhile.
+func WithJoinWarmup(d time.Duration, minPeers int) Option {
+	return func(k *Kademlia) {
+		k.warmupFor = d
+		k.warmupMinPeers = minPeers
+	}
+}
+
+// WithBucketRefresh sets how often the node checks for buckets that have
+// seen no traffic for staleAfter (defaults 10m and 1h) and refreshes each by
+// looking up a random ID in its range. interval <= 0 disables refreshing.
+func WithBucketRefresh(interval, staleAfter time.Duration) Option {
+	return func(k *Kademlia) {
+		k.refreshInterval = interval
+		if staleAfter > 0 {
+			k.refreshStale = staleAfter
+		}
+	}
+}
+
+// WithRejoin sets how often the node checks whether its routing table has
+// fallen below minPeers contacts (defaults 30s and 1) and, if so, rejoins via
+// the bootstraps remembered from Join and JoinAny; failed attempts back off
+// up to 16 intervals. interval <= 0 disables the watchdog.
+func WithRejoin(interval time.Duration, minPeers int) Option {
+	return func(k *Kademlia) {
+		k.rejoinInterval = interval
+		if minPeers > 0 {
+			k.rejoinMinPeers = minPeers
+		}
+	}
+}
+
+// WithSignedEnvelopes makes the node sign every envelope it sends with key
+// (a fresh one if nil) and drop incoming envelopes that aren't signed, fail
+// to verify, arrive from an address other than their sender's, or claim a
+// node ID first seen with another key. Nodes that don't sign can no longer
+// reach this one, so enable it network-wide.
+func WithSignedEnvelopes(key ed25519.PrivateKey) Option {
+	return func(k *Kademlia) {
+		k.signEnvelopes = true
+		k.signKey = key
+	}
+}
+
+// WithKeyDerivedID turns on WithSignedEnvelopes and replaces the node's ID
+// with the SHA-1 of its public key; envelopes whose sender's ID isn't the
+// hash of the key it signs with are dropped, so a peer can't choose the ID
+// it is known by in this node's routing table. Contacts relayed in
+// FIND_NODE_OK and FIND_VALUE_OK carry no key, so they are PINGed before
+// they enter the table, under the ID their signed PONG proves; this costs
+// lookups an extra round trip per newly discovered peer. The Contact passed
+// to NewKademlia only supplies the address.
+func WithKeyDerivedID() Option {
+	return func(k *Kademlia) {
+		k.signEnvelopes = true
+		k.keyDerivedID = true
+	}
+}
+
+// WithCodec sets how the node encodes the envelopes it sends (JSONCodec by
+// default; BinaryCodec is smaller). Incoming envelopes are accepted in
+// either form, so nodes with different codecs still talk.
+func WithCodec(c Codec) Option {
+	return func(k *Kademlia) { k.codec = c }
+}
+
+// WithCompression gzips the value of outgoing STOREs and FIND_VALUE replies
+// longer than threshold bytes, when that makes it smaller. Receivers always
+// inflate compressed values, so this only affects what the node sends.
+// threshold <= 0 (the default) sends values as they are.
+func WithCompression(threshold int) Option {
+	return func(k *Kademlia) { k.compressAbove = threshold }
+}
+
+// WithLogger sends the node's diagnostics to l from the start, including
+// what NewKademlia itself logs; see SetLogger to change it later.
+func WithLogger(l Logger) Option {
+	return func(k *Kademlia) { k.SetLogger(l) }
+}
@@ -0,0 +1,171 @@
+package kademlia
+
+// persist.go: optional durable backing for the local value store.
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Store persists a node's values and which of them it originated, so a node
+// restarted on the same Store (and ID) serves its old data and resumes
+// republishing its own keys. The in-memory map stays the working copy; the
+// Store only sees writes through and is read once, in NewKademlia.
+type Store interface {
+	Put(keyHex string, value []byte) error
+	Delete(keyHex string) error
+	// MarkOrigin records that this node published keyHex via Put.
+	MarkOrigin(keyHex string) error
+	// UnmarkOrigin undoes MarkOrigin once the node forgets keyHex.
+	UnmarkOrigin(keyHex string) error
+	// Load returns everything persisted so far.
+	Load() (values map[string][]byte, origins []string, err error)
+}
+
+// MemoryStore is a Store kept in process memory. It outlives the nodes that
+// use it, which is enough to simulate a restart in tests and demos.
+type MemoryStore struct {
+	mu      sync.Mutex
+	values  map[string][]byte
+	origins map[string]struct{}
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		values:  make(map[string][]byte),
+		origins: make(map[string]struct{}),
+	}
+}
+
+func (s *MemoryStore) Put(keyHex string, value []byte) error {
+	s.mu.Lock()
+	s.values[keyHex] = append([]byte(nil), value...)
+	s.mu.Unlock()
+	return nil
+}
+
+// Delete drops the value; origin membership is kept, matching the node,
+// which skips origin keys it no longer holds. UnmarkOrigin drops that.
+func (s *MemoryStore) Delete(keyHex string) error {
+	s.mu.Lock()
+	delete(s.values, keyHex)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStore) MarkOrigin(keyHex string) error {
+	s.mu.Lock()
+	s.origins[keyHex] = struct{}{}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStore) UnmarkOrigin(keyHex string) error {
+	s.mu.Lock()
+	delete(s.origins, keyHex)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStore) Load() (map[string][]byte, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := make(map[string][]byte, len(s.values))
+	for k, v := range s.values {
+		values[k] = append([]byte(nil), v...)
+	}
+	origins := make([]string, 0, len(s.origins))
+	for k := range s.origins {
+		origins = append(origins, k)
+	}
+	return values, origins, nil
+}
+
+// restore fills the in-memory store and origin set from the persistent Store.
+func (kademlia *Kademlia) restore() error {
+	if kademlia.persist == nil {
+		return nil
+	}
+	values, origins, err := kademlia.persist.Load()
+	if err != nil {
+		return fmt.Errorf("load store: %w", err)
+	}
+	kademlia.loadValues(values, origins)
+	return nil
+}
+
+// loadValues adds previously saved values and origin keys to the node, as
+// restore and loadState do at startup.
+func (kademlia *Kademlia) loadValues(values map[string][]byte, origins []string) {
+	kademlia.storeMu.Lock()
+	if kademlia.valueStore == nil {
+		kademlia.valueStore = make(map[string][]byte, len(values))
+	}
+	for k, v := range values {
+		kademlia.storedBytes += int64(len(v) - len(kademlia.valueStore[k]))
+		kademlia.valueStore[k] = v
+		kademlia.setProvenanceLocked(k, Provenance{Source: Restored})
+	}
+	kademlia.storeMu.Unlock()
+	for k := range values {
+		kademlia.touch(k)
+	}
+	kademlia.originMu.Lock()
+	for _, k := range origins {
+		kademlia.originKeys[k] = struct{}{}
+	}
+	kademlia.originMu.Unlock()
+	// Deadlines aren't saved: replicas restart with a full TTL.
+	var replicas []string
+	for k := range values {
+		if !kademlia.isOrigin(k) {
+			replicas = append(replicas, k)
+		}
+	}
+	kademlia.storeMu.Lock()
+	for _, k := range replicas {
+		kademlia.setExpiryLocked(k, kademlia.storeTTL)
+	}
+	kademlia.storeMu.Unlock()
+}
+
+// persistPut, persistDelete, persistOrigin and persistUnmarkOrigin write
+// through to the Store. Failures are logged rather than returned: the
+// in-memory copy is still correct, only a later restart would miss the
+// change.
+func (kademlia *Kademlia) persistPut(keyHex string, value []byte) {
+	if kademlia.persist == nil {
+		return
+	}
+	if err := kademlia.persist.Put(keyHex, value); err != nil {
+		kademlia.infof("[STORE] persist put %s: %v", keyHex, err)
+	}
+}
+
+func (kademlia *Kademlia) persistDelete(keyHex string) {
+	if kademlia.persist == nil {
+		return
+	}
+	if err := kademlia.persist.Delete(keyHex); err != nil {
+		kademlia.infof("[STORE] persist delete %s: %v", keyHex, err)
+	}
+}
+
+func (kademlia *Kademlia) persistOrigin(keyHex string) {
+	if kademlia.persist == nil {
+		return
+	}
+	if err := kademlia.persist.MarkOrigin(keyHex); err != nil {
+		kademlia.infof("[STORE] persist origin %s: %v", keyHex, err)
+	}
+}
+
+func (kademlia *Kademlia) persistUnmarkOrigin(keyHex string) {
+	if kademlia.persist == nil {
+		return
+	}
+	if err := kademlia.persist.UnmarkOrigin(keyHex); err != nil {
+		kademlia.infof("[STORE] persist unmark origin %s: %v", keyHex, err)
+	}
+}
@@ -0,0 +1,49 @@
+package kademlia
+
+// refresh.go: periodic refresh of buckets that have gone quiet.
+
+import "time"
+
+// bucketRefresher ticks until Close and refreshes stale buckets, so ranges
+// of the ID space we don't otherwise talk to keep live contacts.
+func (kademlia *Kademlia) bucketRefresher() {
+	defer kademlia.background.Done()
+	ticker := time.NewTicker(kademlia.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			kademlia.refreshStaleBuckets()
+		case <-kademlia.republishStop:
+			return
+		}
+	}
+}
+
+// refreshStaleBuckets runs a lookup for a random ID in every bucket idle for
+// longer than refreshStale, and returns how many it refreshed. Buckets
+// closer than our nearest known contact are skipped: every ID in them is
+// nearer to us than any peer we know, so their lookups would all converge
+// on the same neighbours the last bucket's does.
+func (kademlia *Kademlia) refreshStaleBuckets() int {
+	nearest := kademlia.routingTable.FindClosestContacts(kademlia.me.ID, 1)
+	if len(nearest) == 0 {
+		return 0 // nobody to ask
+	}
+	deepest := kademlia.routingTable.getBucketIndex(nearest[0].ID)
+	n := 0
+	for _, i := range kademlia.routingTable.StaleBuckets(kademlia.refreshStale) {
+		if i > deepest {
+			break
+		}
+		select {
+		case <-kademlia.republishStop:
+			return n
+		default:
+		}
+		target := NewContact(kademlia.routingTable.RandomIDInBucket(i), "")
+		kademlia.LookupContact(&target)
+		n++
+	}
+	return n
+}
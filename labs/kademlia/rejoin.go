@@ -0,0 +1,78 @@
+package kademlia
+
+// rejoin.go: the watchdog that rejoins via remembered bootstraps once the
+// node has lost (nearly) all its contacts (WithRejoin).
+
+import "time"
+
+// rejoinMaxBackoff caps the watchdog's wait between failed rejoins, as a
+// multiple of rejoinInterval.
+const rejoinMaxBackoff = 16
+
+// rememberBootstraps adds contacts to the rejoin list, once per address.
+func (kademlia *Kademlia) rememberBootstraps(contacts ...Contact) {
+	kademlia.bootMu.Lock()
+	defer kademlia.bootMu.Unlock()
+	for _, c := range contacts {
+		known := false
+		for _, b := range kademlia.bootstraps {
+			known = known || b.Address == c.Address
+		}
+		if !known && c.Address != kademlia.me.Address {
+			kademlia.bootstraps = append(kademlia.bootstraps, c)
+		}
+	}
+}
+
+// Bootstraps returns the bootstrap contacts remembered from Join and JoinAny.
+func (kademlia *Kademlia) Bootstraps() []Contact {
+	kademlia.bootMu.Lock()
+	defer kademlia.bootMu.Unlock()
+	return append([]Contact(nil), kademlia.bootstraps...)
+}
+
+// rejoinWatchdog checks every rejoinInterval until Close whether the table
+// has fallen below rejoinMinPeers and, if so, rejoins via the remembered
+// bootstraps. While that fails the wait doubles, up to rejoinMaxBackoff
+// intervals, so an isolated node doesn't keep hammering dead bootstraps.
+func (kademlia *Kademlia) rejoinWatchdog() {
+	defer kademlia.background.Done()
+	wait := kademlia.rejoinInterval
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-kademlia.republishStop:
+			return
+		}
+		if kademlia.rejoinIfIsolated() {
+			wait = min(2*wait, rejoinMaxBackoff*kademlia.rejoinInterval)
+		} else {
+			wait = kademlia.rejoinInterval
+		}
+		timer.Reset(wait)
+	}
+}
+
+// rejoinIfIsolated rejoins via the remembered bootstraps when the table holds
+// fewer than rejoinMinPeers contacts. It reports whether that was needed and
+// failed to bring the table back up.
+func (kademlia *Kademlia) rejoinIfIsolated() (failed bool) {
+	if kademlia.routingTable.Size() >= kademlia.rejoinMinPeers {
+		return false
+	}
+	boots := kademlia.Bootstraps()
+	if len(boots) == 0 {
+		return false // never joined: nothing to fall back on
+	}
+	kademlia.infof("[REJOIN] %d contact(s) left, rejoining via %d bootstrap(s)", kademlia.routingTable.Size(), len(boots))
+	targets := make([]*Contact, len(boots))
+	for i := range boots {
+		targets[i] = &boots[i]
+	}
+	if err := kademlia.JoinAny(targets); err != nil {
+		kademlia.infof("[REJOIN] %v", err)
+	}
+	return kademlia.routingTable.Size() < kademlia.rejoinMinPeers
+}
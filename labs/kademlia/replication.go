@@ -0,0 +1,62 @@
+package kademlia
+
+// replication.go: pluggable choice of replica targets for Put/republish.
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// ReplicationStrategy picks the peers a value is replicated to.
+// view is this node's routing table, freshly refreshed around key by a lookup;
+// k is the node's replication factor. The node skips itself when sending.
+type ReplicationStrategy interface {
+	Targets(key *KademliaID, view *RoutingTable, k int) []Contact
+}
+
+// KClosest is the default strategy: the k contacts closest to key by XOR,
+// nearest first.
+type KClosest struct{}
+
+func (KClosest) Targets(key *KademliaID, view *RoutingTable, k int) []Contact {
+	contacts := view.FindClosestContacts(key, k)
+	sort.SliceStable(contacts, func(i, j int) bool {
+		return contacts[i].ID.CalcDistance(key).Less(contacts[j].ID.CalcDistance(key))
+	})
+	return contacts
+}
+
+// KClosestPlusRandom replicates to the k closest plus Extra contacts drawn at
+// random from the rest of the table, trading traffic for resilience.
+// Rand may be nil to use the global source.
+type KClosestPlusRandom struct {
+	Extra int
+	Rand  *rand.Rand
+}
+
+func (s KClosestPlusRandom) Targets(key *KademliaID, view *RoutingTable, k int) []Contact {
+	// Start from the default placement so the first k targets always match it;
+	// FindClosestContacts only widens its bucket scan until it has enough
+	// candidates, so a full-table sort can disagree with it at the cutoff.
+	closest := KClosest{}.Targets(key, view, k)
+	chosen := make(map[KademliaID]bool, len(closest))
+	for _, c := range closest {
+		chosen[*c.ID] = true
+	}
+	var rest []Contact
+	for _, c := range view.FindClosestContacts(key, view.Size()) {
+		if !chosen[*c.ID] {
+			rest = append(rest, c)
+		}
+	}
+	shuffle := rand.Shuffle
+	if s.Rand != nil {
+		shuffle = s.Rand.Shuffle
+	}
+	shuffle(len(rest), func(i, j int) { rest[i], rest[j] = rest[j], rest[i] })
+	extra := s.Extra
+	if extra > len(rest) {
+		extra = len(rest)
+	}
+	return append(closest, rest[:extra]...)
+}
@@ -0,0 +1,137 @@
+package kademlia
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// filledTable returns a routing table (me = 0) holding n random contacts.
+func filledTable(n int, rng *rand.Rand) *RoutingTable {
+	rt := NewRoutingTable(NewContact(NewKademliaID(zeroIDHex()), "127.0.0.1:9999"))
+	for i := 0; i < n; i++ {
+		var id KademliaID
+		rng.Read(id[:])
+		rt.AddContact(NewContact(&id, fmt.Sprintf("127.0.0.1:%d", 20000+i)))
+	}
+	return rt
+}
+
+// The default strategy is exactly the old placement: K closest, nearest first.
+func TestKClosest_MatchesClosestContacts(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	rt := filledTable(60, rng)
+	key := NewRandomKademliaID()
+
+	got := KClosest{}.Targets(key, rt, 10)
+	want := rt.FindClosestContacts(key, 10)
+	if len(got) != len(want) {
+		t.Fatalf("got %d targets, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i].Address != want[i].Address {
+			t.Fatalf("target[%d]=%s, want %s", i, got[i].Address, want[i].Address)
+		}
+		if i > 0 && got[i].ID.CalcDistance(key).Less(got[i-1].ID.CalcDistance(key)) {
+			t.Fatalf("targets not sorted by distance at %d", i)
+		}
+	}
+}
+
+func TestKClosestPlusRandom_AddsDistinctExtras(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	rt := filledTable(40, rng)
+	key := NewRandomKademliaID()
+
+	s := KClosestPlusRandom{Extra: 3, Rand: rand.New(rand.NewSource(1))}
+	got := s.Targets(key, rt, 5)
+	if len(got) != 8 {
+		t.Fatalf("expected 5 closest + 3 extra, got %d", len(got))
+	}
+	closest := KClosest{}.Targets(key, rt, 5)
+	seen := make(map[string]bool)
+	for i, c := range got {
+		if i < 5 && c.Address != closest[i].Address {
+			t.Fatalf("first k targets should be the k closest")
+		}
+		if seen[c.Address] {
+			t.Fatalf("duplicate target %s", c.Address)
+		}
+		seen[c.Address] = true
+	}
+}
+
+// farthestOnly is a toy strategy that sends the value to the single farthest peer.
+type farthestOnly struct{ calls int }
+
+func (s *farthestOnly) Targets(key *KademliaID, view *RoutingTable, k int) []Contact {
+	s.calls++
+	all := KClosest{}.Targets(key, view, view.Size())
+	if len(all) == 0 {
+		return nil
+	}
+	return all[len(all)-1:]
+}
+
+// Put must place replicas wherever the configured strategy says.
+func TestReplicationStrategy_UsedByPut(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 5)
+	strategy := &farthestOnly{}
+	origin, _ := m2NewNode(t, WithReplicationStrategy(strategy))
+	if err := origin.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+
+	res, err := origin.PutWithResult([]byte("only the far one"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if strategy.calls != 1 || len(res.Targets) != 1 {
+		t.Fatalf("strategy calls=%d targets=%v", strategy.calls, res.Targets)
+	}
+	// STORE waits for STORE_OK, so placement is settled once Put returns.
+	for _, n := range nodes {
+		_, has := n.loadLocal(res.Key)
+		if want := n.me.Address == res.Targets[0].Address; has != want {
+			t.Fatalf("node %s has=%v, want %v", n.me.Address, has, want)
+		}
+	}
+}
+
+// PlacementFor names the same targets Put would use but stores nothing.
+func TestPlacementFor_DryRunPut(t *testing.T) {
+	nodes, _ := m2Cluster(t, 5)
+	origin := nodes[0]
+	data := []byte("placement dry run")
+	keyHex := m2KeyHex(data)
+
+	before := origin.network.sent.get(msgStore)
+	planned, err := origin.PlacementFor(keyHex)
+	if err != nil {
+		t.Fatalf("PlacementFor: %v", err)
+	}
+	if got := origin.network.sent.get(msgStore) - before; got != 0 {
+		t.Fatalf("dry run sent %d STOREs", got)
+	}
+	for _, n := range nodes {
+		if _, ok := n.loadLocal(keyHex); ok {
+			t.Fatalf("dry run stored the value at %s", n.me.Address)
+		}
+	}
+
+	res, err := origin.PutWithResult(data)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if fmt.Sprint(addrs(planned)) != fmt.Sprint(addrs(res.Targets)) {
+		t.Fatalf("planned %v, Put used %v", addrs(planned), addrs(res.Targets))
+	}
+
+	for _, bad := range []string{"", "abc", strings.Repeat("z", 40)} {
+		if _, err := origin.PlacementFor(bad); !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("PlacementFor(%q) err = %v, want ErrInvalidKey", bad, err)
+		}
+	}
+}
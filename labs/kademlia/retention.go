@@ -0,0 +1,235 @@
+package kademlia
+
+// retention.go: what the local store keeps when it has to drop something —
+// the value-count cap (WithStoreCapacity), the byte quota (SetStorageQuota),
+// their LRU order, expiry (WithStoreTTL), and pins.
+
+import (
+	"container/list"
+	"time"
+)
+
+// retention is the store's eviction bookkeeping, guarded by Kademlia.lruMu
+// (never held while taking another store lock).
+type retention struct {
+	order  *list.List               // keyHex, most recently used at the front
+	pos    map[string]*list.Element // keyHex -> its element in order
+	pinned map[string]struct{}
+}
+
+// touch marks keyHex as just used (stored or served). Always tracked, so a
+// quota set at runtime knows which values have gone unused longest.
+func (kademlia *Kademlia) touch(keyHex string) {
+	kademlia.lruMu.Lock()
+	defer kademlia.lruMu.Unlock()
+	r := &kademlia.retain
+	if r.order == nil {
+		r.order = list.New()
+		r.pos = make(map[string]*list.Element)
+	}
+	if e, ok := r.pos[keyHex]; ok {
+		r.order.MoveToFront(e)
+		return
+	}
+	r.pos[keyHex] = r.order.PushFront(keyHex)
+}
+
+// forget drops keyHex from the LRU order (its pin, if any, stays).
+func (kademlia *Kademlia) forget(keyHex string) {
+	kademlia.lruMu.Lock()
+	defer kademlia.lruMu.Unlock()
+	if e, ok := kademlia.retain.pos[keyHex]; ok {
+		kademlia.retain.order.Remove(e)
+		delete(kademlia.retain.pos, keyHex)
+	}
+}
+
+// isPinned reports whether keyHex is exempt from eviction.
+func (kademlia *Kademlia) isPinned(keyHex string) bool {
+	kademlia.lruMu.Lock()
+	defer kademlia.lruMu.Unlock()
+	_, ok := kademlia.retain.pinned[keyHex]
+	return ok
+}
+
+// enforceCap evicts least recently used values until the store is back
+// within maxValues and the storage quota, reporting each as CapacityEvicted.
+// Origin and pinned values are never chosen, nor is keep (the value just
+// stored), so the store can stay over when nothing else is left to drop.
+func (kademlia *Kademlia) enforceCap(keep string) {
+	quota := kademlia.storageQuota.Load()
+	if kademlia.maxValues <= 0 && quota <= 0 {
+		return
+	}
+	for {
+		kademlia.storeMu.RLock()
+		n, size := len(kademlia.valueStore), kademlia.storedBytes+kademlia.appendBytes
+		kademlia.storeMu.RUnlock()
+		if (kademlia.maxValues <= 0 || n <= kademlia.maxValues) && (quota <= 0 || size <= quota) {
+			return
+		}
+		victim, ok := kademlia.lruVictim(keep)
+		if !ok || !kademlia.evictLocal(victim, CapacityEvicted) {
+			return
+		}
+	}
+}
+
+// storeFull reports whether storing size bytes under keyHex would leave the
+// store over maxValues or the storage quota with nothing evictable: every
+// value held is origin or pinned. Replica STOREs are refused then instead of
+// growing the store past its limits. Replicas held during warmup count as
+// stored; a provisional one evicts nothing until it is promoted, so it is
+// refused as soon as it would go over.
+func (kademlia *Kademlia) storeFull(keyHex string, size int, provisional bool) bool {
+	quota := kademlia.storageQuota.Load()
+	if kademlia.maxValues <= 0 && quota <= 0 {
+		return false
+	}
+	kademlia.storeMu.RLock()
+	old, held := kademlia.valueStore[keyHex]
+	n, after := len(kademlia.valueStore), kademlia.storedBytes+kademlia.appendBytes+int64(size-len(old))
+	kademlia.storeMu.RUnlock()
+	kademlia.provMu.Lock()
+	prev, waiting := kademlia.provisional[keyHex]
+	n += len(kademlia.provisional)
+	after += kademlia.provBytes
+	kademlia.provMu.Unlock()
+	if provisional {
+		held = held || waiting
+		after -= int64(len(prev.value))
+	}
+	overCount := kademlia.maxValues > 0 && !held && n >= kademlia.maxValues
+	if !overCount && (quota <= 0 || after <= quota) {
+		return false
+	}
+	if provisional {
+		return true
+	}
+	_, ok := kademlia.lruVictim(keyHex)
+	return !ok
+}
+
+func (kademlia *Kademlia) lruVictim(keep string) (string, bool) {
+	kademlia.originMu.RLock()
+	defer kademlia.originMu.RUnlock()
+	kademlia.lruMu.Lock()
+	defer kademlia.lruMu.Unlock()
+	r := &kademlia.retain
+	if r.order == nil {
+		return "", false
+	}
+	for e := r.order.Back(); e != nil; e = e.Prev() {
+		k := e.Value.(string)
+		if _, mine := kademlia.originKeys[k]; mine || k == keep {
+			continue
+		}
+		if _, pin := r.pinned[k]; pin {
+			continue
+		}
+		return k, true
+	}
+	return "", false
+}
+
+// SetStorageQuota caps the total bytes of values the node keeps. Going over
+// evicts the least recently stored-or-served cached values (reason
+// CapacityEvicted) until it fits, which happens at once if the store is
+// already over; origin and pinned values are never evicted, so they alone may
+// exceed it. Append sets count toward it too; they aren't evicted to fit,
+// but past it further appends are refused. n <= 0 (the default) means
+// unlimited.
+func (kademlia *Kademlia) SetStorageQuota(n int) {
+	kademlia.storageQuota.Store(int64(max(n, 0)))
+	kademlia.enforceCap("")
+}
+
+// Pin keeps keyHex in the local store through expiry, capacity eviction and
+// ClearCache, without making it an origin key: it is retained and served but
+// never republished. Pinning a key we don't hold yet takes effect once it
+// arrives.
+func (kademlia *Kademlia) Pin(keyHex string) error {
+	keyHex, err := canonicalKey(keyHex)
+	if err != nil {
+		return err
+	}
+	if _, err := parseKeyHex(keyHex); err != nil {
+		return err
+	}
+	kademlia.lruMu.Lock()
+	defer kademlia.lruMu.Unlock()
+	if kademlia.retain.pinned == nil {
+		kademlia.retain.pinned = make(map[string]struct{})
+	}
+	kademlia.retain.pinned[keyHex] = struct{}{}
+	return nil
+}
+
+// Unpin makes keyHex evictable again. Unpinning a key that isn't pinned is a no-op.
+func (kademlia *Kademlia) Unpin(keyHex string) error {
+	keyHex, err := canonicalKey(keyHex)
+	if err != nil {
+		return err
+	}
+	if _, err := parseKeyHex(keyHex); err != nil {
+		return err
+	}
+	kademlia.lruMu.Lock()
+	delete(kademlia.retain.pinned, keyHex)
+	kademlia.lruMu.Unlock()
+	return nil
+}
+
+// setExpiryLocked gives keyHex a deadline ttl from now, or none for ttl <= 0.
+// Callers hold storeMu for writing.
+func (kademlia *Kademlia) setExpiryLocked(keyHex string, ttl time.Duration) {
+	if ttl <= 0 {
+		delete(kademlia.expires, keyHex)
+		return
+	}
+	if kademlia.expires == nil {
+		kademlia.expires = make(map[string]time.Time)
+	}
+	kademlia.expires[keyHex] = time.Now().Add(ttl)
+}
+
+// sweeper drops expired values and append sets every sweepInterval until
+// Close.
+func (kademlia *Kademlia) sweeper() {
+	defer kademlia.background.Done()
+	ticker := time.NewTicker(kademlia.sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			kademlia.sweepExpired()
+			kademlia.sweepAppendSets()
+		case <-kademlia.republishStop:
+			return
+		}
+	}
+}
+
+// sweepExpired evicts every unpinned value past its deadline as TTLExpired
+// and returns how many it dropped.
+func (kademlia *Kademlia) sweepExpired() int {
+	now := time.Now()
+	var due []string
+	kademlia.storeMu.RLock()
+	for k, deadline := range kademlia.expires {
+		if now.After(deadline) {
+			due = append(due, k)
+		}
+	}
+	kademlia.storeMu.RUnlock()
+	n := 0
+	for _, k := range due {
+		if kademlia.isPinned(k) {
+			continue
+		}
+		if kademlia.evictLocalIfExpired(k, TTLExpired, now) {
+			n++
+		}
+	}
+	return n
+}
@@ -2,10 +2,21 @@ package kademlia
 
 import (
 	"sync"
+	"time"
 )
 
 const bucketSize = 20
 
+// ContactTable is the minimal routing-table contract a lookup relies on.
+// RoutingTable satisfies it with either bucket layout.
+type ContactTable interface {
+	AddContact(contact Contact)
+	FindClosestContacts(target *KademliaID, count int) []Contact
+	SetPingFunc(pf func(Contact) bool)
+}
+
+var _ ContactTable = (*RoutingTable)(nil)
+
 // RoutingTable definition
 // keeps a refrence contact of me and an array of buckets
 type RoutingTable struct {
@@ -14,6 +25,11 @@ type RoutingTable struct {
 	mu      sync.RWMutex
 	// Called outside the lock to test liveness of an LRU contact when a bucket is full.
 	pingFunc func(Contact) bool
+	// Time source for bucket activity; swapped out by tests.
+	now func() time.Time
+	// Non-nil when built by NewTrieRoutingTable: contacts then live in the
+	// prefix tree and buckets[] only tracks per-distance activity.
+	trie *trieTable
 }
 
 // NewRoutingTable returns a new instance of a RoutingTable
@@ -23,9 +39,28 @@ func NewRoutingTable(me Contact) *RoutingTable {
 		routingTable.buckets[i] = newBucket()
 	}
 	routingTable.me = me
+	routingTable.now = time.Now
 	return routingTable
 }
 
+// NewTrieRoutingTable returns a RoutingTable that stores contacts in a
+// prefix tree (see routingtrie.go) instead of the fixed 160-bucket array.
+// Eviction, replacement caching and the ping policy are the same.
+func NewTrieRoutingTable(me Contact) *RoutingTable {
+	routingTable := NewRoutingTable(me)
+	routingTable.trie = newTrieTable(me.ID)
+	return routingTable
+}
+
+// bucketFor returns the bucket that holds (or would hold) id.
+// Caller holds routingTable.mu for writing in trie mode, since it may split.
+func (routingTable *RoutingTable) bucketFor(id *KademliaID) *bucket {
+	if routingTable.trie != nil {
+		return routingTable.trie.bucketFor(id)
+	}
+	return routingTable.buckets[routingTable.getBucketIndex(id)]
+}
+
 // SetPingFunc wires a liveness probe used by the eviction policy.
 func (routingTable *RoutingTable) SetPingFunc(pf func(Contact) bool) {
 	routingTable.mu.Lock()
@@ -49,14 +84,17 @@ func (routingTable *RoutingTable) AddContact(contact Contact) {
 	}
 
 	bucketIndex := routingTable.getBucketIndex(contact.ID)
+	now := routingTable.now()
+	routingTable.buckets[bucketIndex].touch(now)
+	contact.LastSeen = now
 
 	// ---- Phase 1: decide under lock (find existing / space / LRU) ----
 	routingTable.mu.Lock()
-	b := routingTable.buckets[bucketIndex]
+	b := routingTable.bucketFor(contact.ID)
 	// If already present, move-to-front (most-recent) and return.
 	for e := b.list.Front(); e != nil; e = e.Next() {
 		if e.Value.(Contact).ID.Equals(contact.ID) {
-			b.list.MoveToFront(e)
+			b.seen(e, now)
 			routingTable.mu.Unlock()
 			return
 		}
@@ -68,8 +106,8 @@ func (routingTable *RoutingTable) AddContact(contact Contact) {
 		return
 	}
 
-	// Full: capture *current* LRU (least-recent) and release lock to ping it.
-	lruElt := b.list.Back()
+	// Full: capture *current* LRU (seen longest ago) and release lock to ping it.
+	lruElt := b.oldest()
 	lru := lruElt.Value.(Contact)
 	routingTable.mu.Unlock()
 
@@ -82,7 +120,7 @@ func (routingTable *RoutingTable) AddContact(contact Contact) {
 	// ---- Phase 3: re-acquire and mutate bucket based on liveness ----
 	routingTable.mu.Lock()
 	defer routingTable.mu.Unlock()
-	b = routingTable.buckets[bucketIndex]
+	b = routingTable.bucketFor(contact.ID)
 
 	if !alive {
 		// Evict the LRU (if still there), add the new contact at front.
@@ -100,7 +138,7 @@ func (routingTable *RoutingTable) AddContact(contact Contact) {
 	// but remember the new contact in the replacement cache.
 	for e := b.list.Back(); e != nil; e = e.Prev() {
 		if e.Value.(Contact).ID.Equals(lru.ID) {
-			b.list.MoveToFront(e)
+			b.seen(e, routingTable.now())
 			break
 		}
 	}
@@ -108,26 +146,72 @@ func (routingTable *RoutingTable) AddContact(contact Contact) {
 
 }
 
+// RemoveContact drops the contact with id from its bucket (or from the bucket's
+// replacement cache). A freed bucket slot is refilled from the replacement
+// cache. Returns whether anything was removed.
+func (routingTable *RoutingTable) RemoveContact(id *KademliaID) bool {
+	if id == nil {
+		return false
+	}
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	b := routingTable.bucketFor(id)
+	if _, ok := b.remove(id); ok {
+		return true
+	}
+	return b.removeReplacement(id)
+}
+
 // FindClosestContacts finds the count closest Contacts to the target in the RoutingTable
 func (routingTable *RoutingTable) FindClosestContacts(target *KademliaID, count int) []Contact {
+	return routingTable.FindClosestContactsExcluding(target, count, nil)
+}
+
+// FindClosestContactsExcluding is FindClosestContacts skipping every contact
+// for which exclude returns true. Excluded contacts don't count toward count,
+// so a lookup passing its visited/dead set gets up to count fresh candidates:
+// the count closest of them by XOR distance, not just the first found.
+// A nil exclude excludes nothing.
+func (routingTable *RoutingTable) FindClosestContactsExcluding(target *KademliaID, count int, exclude func(Contact) bool) []Contact {
 	routingTable.mu.RLock()
 	defer routingTable.mu.RUnlock()
 	var candidates ContactCandidates
 	bucketIndex := routingTable.getBucketIndex(target)
-	bucket := routingTable.buckets[bucketIndex]
-
-	candidates.Append(bucket.GetContactAndCalcDistance(target))
+	home := routingTable.buckets[bucketIndex]
+	// A lookup for target counts as activity on the bucket covering it.
+	home.touch(routingTable.now())
+	if routingTable.trie != nil {
+		return routingTable.trie.findClosest(target, count, exclude)
+	}
 
-	for i := 1; (bucketIndex-i >= 0 || bucketIndex+i < IDLength*8) && candidates.Len() < count; i++ {
-		if bucketIndex-i >= 0 {
-			bucket = routingTable.buckets[bucketIndex-i]
-			candidates.Append(bucket.GetContactAndCalcDistance(target))
+	appendFrom := func(b *bucket) {
+		contacts := b.GetContactAndCalcDistance(target)
+		if exclude != nil {
+			kept := contacts[:0]
+			for _, c := range contacts {
+				if !exclude(c) {
+					kept = append(kept, c)
+				}
+			}
+			contacts = kept
 		}
-		if bucketIndex+i < IDLength*8 {
-			bucket = routingTable.buckets[bucketIndex+i]
-			candidates.Append(bucket.GetContactAndCalcDistance(target))
+		candidates.Append(contacts)
+	}
+
+	// Buckets come in tiers of distance to target: the home bucket holds the
+	// nearest contacts, every deeper bucket (longer prefix shared with us)
+	// the next, all first differing from target at bit bucketIndex, then each
+	// shallower bucket in turn, farther and farther. A tier is taken whole,
+	// so stopping once count are in hand still returns the true closest.
+	appendFrom(home)
+	if candidates.Len() < count {
+		for i := bucketIndex + 1; i < IDLength*8; i++ {
+			appendFrom(routingTable.buckets[i])
 		}
 	}
+	for i := bucketIndex - 1; i >= 0 && candidates.Len() < count; i-- {
+		appendFrom(routingTable.buckets[i])
+	}
 
 	candidates.Sort()
 
@@ -138,16 +222,104 @@ func (routingTable *RoutingTable) FindClosestContacts(target *KademliaID, count
 	return candidates.GetContacts(count)
 }
 
-// getBucketIndex get the correct Bucket index for the KademliaID
-func (routingTable *RoutingTable) getBucketIndex(id *KademliaID) int {
-	distance := id.CalcDistance(routingTable.me.ID)
-	for i := 0; i < IDLength; i++ {
-		for j := 0; j < 8; j++ {
-			if (distance[i]>>uint8(7-j))&0x1 != 0 {
-				return i*8 + j
-			}
+// Size returns the number of contacts across all buckets (replacements excluded).
+func (routingTable *RoutingTable) Size() int {
+	routingTable.mu.RLock()
+	defer routingTable.mu.RUnlock()
+	if routingTable.trie != nil {
+		return routingTable.trie.size()
+	}
+	n := 0
+	for _, b := range routingTable.buckets {
+		n += b.Len()
+	}
+	return n
+}
+
+// Dump returns a copy of the table's contacts grouped by bucket index (see
+// getBucketIndex), most recently seen first and each stamped with its
+// LastSeen; replacements are left out. In trie mode contacts are grouped by
+// their distance bucket the same way.
+func (routingTable *RoutingTable) Dump() [][]Contact {
+	routingTable.mu.RLock()
+	defer routingTable.mu.RUnlock()
+	out := make([][]Contact, IDLength*8)
+	add := func(b *bucket) {
+		for e := b.list.Front(); e != nil; e = e.Next() {
+			c := e.Value.(Contact)
+			i := routingTable.getBucketIndex(c.ID)
+			out[i] = append(out[i], c)
+		}
+	}
+	if routingTable.trie != nil {
+		routingTable.trie.leaves(add)
+	} else {
+		for _, b := range routingTable.buckets {
+			add(b)
+		}
+	}
+	return out
+}
+
+// Reset forgets every contact: all buckets and replacement caches are
+// emptied (and a trie collapses back to a single leaf). Our own contact and
+// the ping function are kept, so the table is ready for a fresh Join. The
+// buckets are emptied in place: AddContact, BucketLastActivity and
+// StaleBuckets read their activity stamps without the lock.
+func (routingTable *RoutingTable) Reset() {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	for _, b := range routingTable.buckets {
+		b.list.Init()
+		b.repl = nil
+	}
+	if routingTable.trie != nil {
+		routingTable.trie = newTrieTable(routingTable.me.ID)
+	}
+}
+
+// BucketLastActivity returns when bucket i last saw a contact added/refreshed
+// or a lookup for an ID in its range. Zero time means never (or i out of range).
+func (routingTable *RoutingTable) BucketLastActivity(i int) time.Time {
+	if i < 0 || i >= IDLength*8 {
+		return time.Time{}
+	}
+	return routingTable.buckets[i].lastTouched()
+}
+
+// StaleBuckets returns the indices of buckets idle for longer than threshold,
+// including buckets that never saw any activity.
+func (routingTable *RoutingTable) StaleBuckets(threshold time.Duration) []int {
+	now := routingTable.now()
+	var stale []int
+	for i := range routingTable.buckets {
+		if now.Sub(routingTable.buckets[i].lastTouched()) > threshold {
+			stale = append(stale, i)
 		}
 	}
+	return stale
+}
 
-	return IDLength*8 - 1
+// RandomIDInBucket returns a random ID that falls in bucket i: it shares
+// our first i bits, differs at bit i, and the rest are random. A lookup for
+// it is how a stale bucket gets refreshed.
+func (routingTable *RoutingTable) RandomIDInBucket(i int) *KademliaID {
+	id := NewRandomKademliaID()
+	me := routingTable.me.ID
+	for bit := 0; bit <= i && bit < IDLength*8; bit++ {
+		mask := uint8(0x80) >> uint(bit%8)
+		want := me[bit/8] & mask
+		if bit == i {
+			want ^= mask
+		}
+		id[bit/8] = id[bit/8]&^mask | want
+	}
+	return id
+}
+
+// getBucketIndex get the correct Bucket index for the KademliaID
+// Our own ID (distance 0) maps to the last, closest bucket, so a search for
+// it fans out from our nearest neighbours, which is exactly XOR order.
+func (routingTable *RoutingTable) getBucketIndex(id *KademliaID) int {
+	return min(id.PrefixLen(routingTable.me.ID), IDLength*8-1)
 }
@@ -3,7 +3,10 @@ package kademlia
 import (
 	"encoding/hex"
 	"fmt"
+	"math/rand"
+	"sync"
 	"testing"
+	"time"
 )
 
 // FIXME: This test doesn't actually test anything. There is only one assertion
@@ -24,9 +27,12 @@ func TestRoutingTable(t *testing.T) {
 		fmt.Println(contacts[i].String())
 	}
 
-	// TODO: This is just an example. Make more meaningful assertions.
-	if len(contacts) != 6 {
-		t.Fatalf("Expected 6 contacts but instead got %d", len(contacts))
+	// The first contact carries our own ID; AddContact ignores self, so 5 remain.
+	if len(contacts) != 5 {
+		t.Fatalf("Expected 5 contacts but instead got %d", len(contacts))
+	}
+	if containsAddr(contacts, "localhost:8001") {
+		t.Fatalf("own ID must not be stored in the routing table")
 	}
 }
 
@@ -158,3 +164,369 @@ func TestRoutingTable_MoveToFrontOnSeenAgain(t *testing.T) {
 		t.Fatalf("expected existing contact %q to remain present", again.Address)
 	}
 }
+
+// idInBucket returns a unique ID that lands in bucket idx when me.ID == 0.
+func idInBucket(idx int, i byte) *KademliaID {
+	var id KademliaID
+	id[idx/8] = 0x80 >> uint(idx%8)
+	id[IDLength-1] ^= i + 1
+	return &id
+}
+
+func containsInt(xs []int, x int) bool {
+	for _, v := range xs {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
+
+// Advance a mock clock and check which buckets are reported stale.
+func TestRoutingTable_BucketActivityAndStaleness(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewKademliaID(zeroIDHex()), "127.0.0.1:9999"))
+	clock := time.Unix(1_000_000, 0)
+	rt.now = func() time.Time { return clock }
+
+	if !rt.BucketLastActivity(0).IsZero() {
+		t.Fatalf("fresh bucket should have zero activity time")
+	}
+
+	rt.AddContact(NewContact(idInBucket(0, 1), "127.0.0.1:10001"))
+	if got := rt.BucketLastActivity(0); !got.Equal(clock) {
+		t.Fatalf("bucket 0 activity = %v, want %v", got, clock)
+	}
+
+	clock = clock.Add(2 * time.Hour)
+	rt.AddContact(NewContact(idInBucket(5, 1), "127.0.0.1:10002"))
+
+	stale := rt.StaleBuckets(time.Hour)
+	if !containsInt(stale, 0) {
+		t.Fatalf("bucket 0 (idle 2h) should be stale; stale=%v", stale)
+	}
+	if containsInt(stale, 5) {
+		t.Fatalf("bucket 5 (just touched) should not be stale")
+	}
+	if !containsInt(stale, 100) {
+		t.Fatalf("never-touched bucket 100 should be stale")
+	}
+
+	// A lookup whose target falls in bucket 0 refreshes it.
+	rt.FindClosestContacts(idInBucket(0, 9), 1)
+	if containsInt(rt.StaleBuckets(time.Hour), 0) {
+		t.Fatalf("lookup into bucket 0 should have refreshed it")
+	}
+}
+
+// debugEvictLRU evicts the least-recently-seen contact of a bucket through the
+// regular removal path, so tests can drive eviction deterministically.
+func (routingTable *RoutingTable) debugEvictLRU(bucketIndex int) (Contact, bool) {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	b := routingTable.buckets[bucketIndex]
+	back := b.list.Back()
+	if back == nil {
+		return Contact{}, false
+	}
+	return b.remove(back.Value.(Contact).ID)
+}
+
+// Evicting the LRU of a full bucket promotes the newest replacement into it.
+func TestRoutingTable_EvictLRUPromotesReplacement(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewKademliaID(zeroIDHex()), "127.0.0.1:9999"))
+	rt.SetPingFunc(func(c Contact) bool { return true })
+
+	for i := 0; i < bucketSize; i++ {
+		rt.AddContact(makeContact(i))
+	}
+	// Two newcomers bounce off the full bucket into the replacement cache.
+	older, newer := makeContact(210), makeContact(211)
+	rt.AddContact(older)
+	rt.AddContact(newer)
+
+	// The liveness checks kept refreshing the LRU, so the tail is now contact 2.
+	evicted, ok := rt.debugEvictLRU(0)
+	if !ok || evicted.Address != makeContact(2).Address {
+		t.Fatalf("expected contact 2 to be evicted as LRU, got %v (ok=%v)", evicted.Address, ok)
+	}
+	got := rt.FindClosestContacts(targetID(), 100)
+	if len(got) != bucketSize {
+		t.Fatalf("bucket should be refilled to %d, got %d", bucketSize, len(got))
+	}
+	if containsAddr(got, evicted.Address) {
+		t.Fatalf("evicted LRU %q still present", evicted.Address)
+	}
+	if !containsAddr(got, newer.Address) {
+		t.Fatalf("most recent replacement %q should have been promoted", newer.Address)
+	}
+	if containsAddr(got, older.Address) {
+		t.Fatalf("only one replacement should be promoted per freed slot")
+	}
+
+	// Next eviction promotes the remaining replacement; a third finds none.
+	rt.debugEvictLRU(0)
+	if !containsAddr(rt.FindClosestContacts(targetID(), 100), older.Address) {
+		t.Fatalf("second eviction should promote the older replacement")
+	}
+	rt.debugEvictLRU(0)
+	if n := rt.Size(); n != bucketSize-1 {
+		t.Fatalf("with the cache drained an eviction shrinks the bucket; size=%d", n)
+	}
+}
+
+func TestRoutingTable_EvictLRUOnEmptyBucket(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewKademliaID(zeroIDHex()), "127.0.0.1:9999"))
+	if _, ok := rt.debugEvictLRU(7); ok {
+		t.Fatalf("evicting from an empty bucket should report false")
+	}
+}
+
+func TestRoutingTable_RemoveContact(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewKademliaID(zeroIDHex()), "127.0.0.1:9999"))
+	rt.SetPingFunc(func(c Contact) bool { return true })
+	for i := 0; i < bucketSize; i++ {
+		rt.AddContact(makeContact(i))
+	}
+	spare := makeContact(220)
+	rt.AddContact(spare) // lands in the replacement cache
+
+	victim := makeContact(5)
+	if !rt.RemoveContact(victim.ID) {
+		t.Fatalf("RemoveContact should report removal of a known contact")
+	}
+	got := rt.FindClosestContacts(targetID(), 100)
+	if containsAddr(got, victim.Address) || !containsAddr(got, spare.Address) {
+		t.Fatalf("removal should free a slot for the replacement")
+	}
+	if rt.RemoveContact(victim.ID) {
+		t.Fatalf("removing an absent contact should report false")
+	}
+}
+
+// Excluded contacts don't occupy result slots: the scan widens to other buckets
+// until count fresh contacts are found.
+func TestRoutingTable_FindClosestContactsExcluding(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewKademliaID(zeroIDHex()), "127.0.0.1:9999"))
+	for i := 0; i < 5; i++ {
+		rt.AddContact(makeContact(i)) // bucket 0, same as the target
+		rt.AddContact(NewContact(idInBucket(1, byte(i)), fmt.Sprintf("127.0.0.1:%d", 11000+i)))
+	}
+
+	near := rt.FindClosestContacts(targetID(), 5)
+	if same := rt.FindClosestContactsExcluding(targetID(), 5, nil); len(same) != len(near) {
+		t.Fatalf("nil exclusion should match FindClosestContacts")
+	}
+	blocked := make(map[string]bool)
+	for _, c := range near {
+		blocked[c.Address] = true
+	}
+
+	got := rt.FindClosestContactsExcluding(targetID(), 5, func(c Contact) bool { return blocked[c.Address] })
+	if len(got) != 5 {
+		t.Fatalf("expected 5 fresh contacts, got %d", len(got))
+	}
+	for _, c := range got {
+		if blocked[c.Address] {
+			t.Fatalf("excluded contact %s returned", c.Address)
+		}
+	}
+}
+
+// The scan returns the true closest: contacts in deeper buckets than the
+// target's are nearer to it than any in a shallower one, however the
+// buckets are ordered around the target's index.
+func TestRoutingTable_FindClosestIsExactAcrossBuckets(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewKademliaID(zeroIDHex()), "127.0.0.1:9999"))
+	far := NewContact(idInBucket(1, 0), "127.0.0.1:11001")
+	near := []Contact{
+		NewContact(idInBucket(3, 0), "127.0.0.1:11003"),
+		NewContact(idInBucket(6, 0), "127.0.0.1:11006"),
+	}
+	skipped := NewContact(idInBucket(3, 1), "127.0.0.1:11013")
+	for _, c := range append([]Contact{far, skipped}, near...) {
+		rt.AddContact(c)
+	}
+
+	target := idInBucket(2, 7) // home bucket 2 is empty
+	got := rt.FindClosestContactsExcluding(target, 2, func(c Contact) bool { return c.Address == skipped.Address })
+	if len(got) != 2 || containsAddr(got, far.Address) {
+		t.Fatalf("closest 2 = %v, want %s and %s", got, near[0].Address, near[1].Address)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].ID.CalcDistance(target).Less(got[i-1].ID.CalcDistance(target)) {
+			t.Fatalf("results not sorted nearest first: %v", got)
+		}
+	}
+}
+
+// Reset empties contacts and replacement caches for both layouts, keeps our
+// own contact, and the table fills up again normally afterwards.
+func TestRoutingTable_ResetForgetsEverything(t *testing.T) {
+	me := NewContact(NewKademliaID(zeroIDHex()), "127.0.0.1:9999")
+	for name, rt := range map[string]*RoutingTable{
+		"array": NewRoutingTable(me),
+		"trie":  NewTrieRoutingTable(me),
+	} {
+		rt.SetPingFunc(func(Contact) bool { return true }) // full bucket: newcomers go to replacements
+		for i := 0; i < bucketSize+5; i++ {
+			rt.AddContact(makeContact(i))
+		}
+		if rt.Size() == 0 || len(rt.bucketFor(sameBucketID(0)).repl) == 0 {
+			t.Fatalf("%s: setup should fill a bucket and its replacement cache", name)
+		}
+
+		rt.Reset()
+		if n := rt.Size(); n != 0 {
+			t.Fatalf("%s: Size after Reset = %d, want 0", name, n)
+		}
+		if r := rt.bucketFor(sameBucketID(0)).repl; len(r) != 0 {
+			t.Fatalf("%s: replacement cache survived Reset: %d entries", name, len(r))
+		}
+		if got := rt.FindClosestContacts(sameBucketID(1), bucketSize); len(got) != 0 {
+			t.Fatalf("%s: FindClosestContacts after Reset returned %d", name, len(got))
+		}
+		if !rt.me.ID.Equals(me.ID) {
+			t.Fatalf("%s: Reset lost our own contact", name)
+		}
+
+		rt.AddContact(makeContact(1))
+		if n := rt.Size(); n != 1 {
+			t.Fatalf("%s: Size after re-adding one contact = %d", name, n)
+		}
+	}
+}
+
+// Reset can run while contacts are added and bucket activity is read (the
+// race detector flags it if Reset swaps buckets those readers use).
+func TestRoutingTable_ResetConcurrentWithReaders(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewKademliaID(zeroIDHex()), "127.0.0.1:9999"))
+	var wg sync.WaitGroup
+	for g := 0; g < 3; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				switch g {
+				case 0:
+					rt.AddContact(makeContact(i))
+				case 1:
+					rt.BucketLastActivity(i % (IDLength * 8))
+				default:
+					rt.StaleBuckets(time.Hour)
+				}
+			}
+		}(g)
+	}
+	for i := 0; i < 50; i++ {
+		rt.Reset()
+	}
+	wg.Wait()
+}
+
+// Dump groups contacts by bucket: a full bucket 0 shows exactly bucketSize
+// entries (overflow stays in the replacement cache) and every other bucket is empty.
+func TestRoutingTable_DumpPerBucket(t *testing.T) {
+	me := NewContact(NewKademliaID(zeroIDHex()), "127.0.0.1:9999")
+	for name, rt := range map[string]*RoutingTable{
+		"array": NewRoutingTable(me),
+		"trie":  NewTrieRoutingTable(me),
+	} {
+		rt.SetPingFunc(func(Contact) bool { return true })
+		for i := 0; i < bucketSize+5; i++ {
+			rt.AddContact(makeContact(i))
+		}
+
+		dump := rt.Dump()
+		if len(dump) != IDLength*8 {
+			t.Fatalf("%s: Dump has %d buckets, want %d", name, len(dump), IDLength*8)
+		}
+		if n := len(dump[0]); n != bucketSize {
+			t.Fatalf("%s: bucket 0 has %d contacts, want %d", name, n, bucketSize)
+		}
+		for i, b := range dump[1:] {
+			if len(b) != 0 {
+				t.Fatalf("%s: bucket %d has %d contacts, want 0", name, i+1, len(b))
+			}
+		}
+	}
+}
+
+// Re-adding a contact restamps its LastSeen, and a full bucket offers the
+// contact seen longest ago for eviction.
+func TestRoutingTable_LastSeenDrivesEviction(t *testing.T) {
+	me := NewContact(NewKademliaID(zeroIDHex()), "127.0.0.1:9999")
+	rt := NewRoutingTable(me)
+	clock := time.Unix(1_000_000, 0)
+	rt.now = func() time.Time { return clock }
+	var pinged []string
+	rt.SetPingFunc(func(c Contact) bool { pinged = append(pinged, c.Address); return false })
+
+	for i := 0; i < bucketSize; i++ {
+		rt.AddContact(makeContact(i))
+		clock = clock.Add(time.Second)
+	}
+	rt.AddContact(makeContact(0))
+	if first := rt.Dump()[0][0]; first.Address != makeContact(0).Address || !first.LastSeen.Equal(clock) {
+		t.Fatalf("after re-add, front is %s seen %v; want %s seen %v",
+			first.Address, first.LastSeen, makeContact(0).Address, clock)
+	}
+
+	clock = clock.Add(time.Second)
+	newcomer := makeContact(bucketSize)
+	rt.AddContact(newcomer)
+	if want := makeContact(1).Address; len(pinged) != 1 || pinged[0] != want {
+		t.Fatalf("probed %v for eviction, want only %s (oldest LastSeen)", pinged, want)
+	}
+	dump := rt.Dump()[0]
+	if containsAddr(dump, makeContact(1).Address) || !containsAddr(dump, newcomer.Address) {
+		t.Fatalf("dead oldest contact should have been replaced by the newcomer")
+	}
+	for _, c := range dump {
+		if c.LastSeen.IsZero() {
+			t.Fatalf("%s has no LastSeen in Dump", c.Address)
+		}
+	}
+}
+
+// Equally seeded sources give the same IDs; the global source doesn't repeat.
+func TestNewRandomKademliaIDFrom_Deterministic(t *testing.T) {
+	a, b := rand.New(rand.NewSource(42)), rand.New(rand.NewSource(42))
+	for i := 0; i < 3; i++ {
+		if x, y := NewRandomKademliaIDFrom(a), NewRandomKademliaIDFrom(b); !x.Equals(y) {
+			t.Fatalf("draw %d: %s != %s from equal seeds", i, x, y)
+		}
+	}
+	if x, y := NewRandomKademliaIDFrom(rand.New(rand.NewSource(1))), NewRandomKademliaIDFrom(rand.New(rand.NewSource(2))); x.Equals(y) {
+		t.Fatalf("different seeds gave the same ID %s", x)
+	}
+	if NewRandomKademliaID().Equals(NewRandomKademliaID()) {
+		t.Fatalf("two global draws gave the same ID")
+	}
+}
+
+// PrefixLen counts shared leading bits, and bucket indices follow it.
+func TestKademliaID_PrefixLen(t *testing.T) {
+	me := NewKademliaID("00ff00ff00ff00ff00ff00ff00ff00ff00ff00ff")
+	cases := []struct {
+		other string
+		want  int
+	}{
+		{"80ff00ff00ff00ff00ff00ff00ff00ff00ff00ff", 0},   // first bit differs
+		{"00f700ff00ff00ff00ff00ff00ff00ff00ff00ff", 12},  // 0x08 bit of byte 1
+		{"00ff00ff00ff00ff00ff00ff00ff00ff00ff00fe", 159}, // only the last bit
+		{"00ff00ff00ff00ff00ff00ff00ff00ff00ff00ff", 160}, // equal
+	}
+	rt := NewRoutingTable(NewContact(me, "127.0.0.1:1"))
+	for _, tc := range cases {
+		other := NewKademliaID(tc.other)
+		if got := me.PrefixLen(other); got != tc.want {
+			t.Errorf("PrefixLen(%s) = %d, want %d", tc.other, got, tc.want)
+		}
+		if got := other.PrefixLen(me); got != tc.want {
+			t.Errorf("PrefixLen is not symmetric for %s: %d", tc.other, got)
+		}
+		if got, want := rt.getBucketIndex(other), min(tc.want, IDLength*8-1); got != want {
+			t.Errorf("getBucketIndex(%s) = %d, want %d", tc.other, got, want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package kademlia
+
+// routingtrie.go: optional prefix-tree bucket layout (see NewTrieRoutingTable).
+
+// trieNode is a node of the binary prefix tree. Leaves hold a k-bucket for
+// every ID whose first depth bits equal the path from the root; inner nodes
+// have both children set and no bucket.
+type trieNode struct {
+	depth       int
+	leaf        *bucket
+	zero, one   *trieNode
+	containsOwn bool // the node's own ID falls inside this subtree
+}
+
+// bitAt returns bit i (MSB first) of id.
+func bitAt(id *KademliaID, i int) int {
+	return int(id[i/8]>>uint(7-i%8)) & 1
+}
+
+func newTrieLeaf(depth int, containsOwn bool) *trieNode {
+	return &trieNode{depth: depth, leaf: newBucket(), containsOwn: containsOwn}
+}
+
+// trieTable is the table layout from the Kademlia paper: it starts as a single
+// bucket covering the whole ID space, and a full bucket is split in two only
+// if its range contains our own ID. Far-away ranges therefore stay one bucket
+// each, while the range around us is refined as contacts arrive.
+// Callers hold the RoutingTable's mutex.
+type trieTable struct {
+	me   *KademliaID
+	root *trieNode
+}
+
+func newTrieTable(me *KademliaID) *trieTable {
+	return &trieTable{me: me, root: newTrieLeaf(0, true)}
+}
+
+// leafFor descends to the leaf whose range covers id.
+func (t *trieTable) leafFor(id *KademliaID) *trieNode {
+	n := t.root
+	for n.leaf == nil {
+		if bitAt(id, n.depth) == 0 {
+			n = n.zero
+		} else {
+			n = n.one
+		}
+	}
+	return n
+}
+
+// bucketFor returns the bucket for id, splitting full leaves on our own path
+// until id's leaf either has room or may not be split further.
+func (t *trieTable) bucketFor(id *KademliaID) *bucket {
+	for {
+		n := t.leafFor(id)
+		if n.leaf.Len() < bucketSize || !n.containsOwn || n.depth >= IDLength*8 {
+			return n.leaf
+		}
+		t.split(n)
+	}
+}
+
+// split turns leaf n into an inner node with two half-range leaves, keeping
+// each contact's LRU position and each replacement's order.
+func (t *trieTable) split(n *trieNode) {
+	ownBit := bitAt(t.me, n.depth)
+	n.zero = newTrieLeaf(n.depth+1, ownBit == 0)
+	n.one = newTrieLeaf(n.depth+1, ownBit == 1)
+	child := func(id *KademliaID) *bucket {
+		if bitAt(id, n.depth) == 0 {
+			return n.zero.leaf
+		}
+		return n.one.leaf
+	}
+	for e := n.leaf.list.Front(); e != nil; e = e.Next() {
+		c := e.Value.(Contact)
+		child(c.ID).list.PushBack(c)
+	}
+	for _, r := range n.leaf.repl {
+		b := child(r.ID)
+		b.repl = append(b.repl, r)
+	}
+	n.leaf = nil
+}
+
+// leaves calls fn for every bucket in the tree.
+func (t *trieTable) leaves(fn func(*bucket)) {
+	var walk func(*trieNode)
+	walk = func(n *trieNode) {
+		if n.leaf != nil {
+			fn(n.leaf)
+			return
+		}
+		walk(n.zero)
+		walk(n.one)
+	}
+	walk(t.root)
+}
+
+// findClosest returns the count closest non-excluded contacts by exact XOR
+// order. Every leaf is considered, so unlike the array scan it never trades
+// accuracy for fewer buckets visited.
+func (t *trieTable) findClosest(target *KademliaID, count int, exclude func(Contact) bool) []Contact {
+	var candidates ContactCandidates
+	t.leaves(func(b *bucket) {
+		for _, c := range b.GetContactAndCalcDistance(target) {
+			if exclude == nil || !exclude(c) {
+				candidates.Append([]Contact{c})
+			}
+		}
+	})
+	candidates.Sort()
+	if count > candidates.Len() {
+		count = candidates.Len()
+	}
+	return candidates.GetContacts(count)
+}
+
+func (t *trieTable) size() int {
+	n := 0
+	t.leaves(func(b *bucket) { n += b.Len() })
+	return n
+}
@@ -0,0 +1,125 @@
+package kademlia
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// nearID returns a random ID sharing exactly the first `shared` bits with me.
+func nearID(me *KademliaID, shared int, rng *rand.Rand) *KademliaID {
+	var id KademliaID
+	rng.Read(id[:])
+	for i := 0; i < shared; i++ {
+		mask := byte(0x80 >> uint(i%8))
+		id[i/8] = id[i/8]&^mask | me[i/8]&mask
+	}
+	if shared < IDLength*8 {
+		id[shared/8] ^= (id[shared/8] ^ ^me[shared/8]) & (0x80 >> uint(shared%8))
+	}
+	return &id
+}
+
+// twinTables feeds the same random contacts (uniform plus a cluster near me,
+// to force splits) into an array table and a trie table.
+func twinTables(seed int64) (array, trie *RoutingTable, rng *rand.Rand) {
+	rng = rand.New(rand.NewSource(seed))
+	var meID KademliaID
+	rng.Read(meID[:])
+	me := NewContact(&meID, "127.0.0.1:9999")
+	array, trie = NewRoutingTable(me), NewTrieRoutingTable(me)
+	for i := 0; i < 400; i++ {
+		var id *KademliaID
+		if i%4 == 0 {
+			id = nearID(&meID, 1+rng.Intn(24), rng)
+		} else {
+			id = new(KademliaID)
+			rng.Read(id[:])
+		}
+		c := NewContact(id, fmt.Sprintf("10.0.%d.%d:4000", i/250, i%250))
+		array.AddContact(c)
+		trie.AddContact(c)
+	}
+	return array, trie, rng
+}
+
+func addrs(cs []Contact) []string {
+	out := make([]string, len(cs))
+	for i, c := range cs {
+		out[i] = c.Address
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Both layouts keep the same contacts, the trie returns the exact XOR-closest
+// set, and it is never farther than the array's nearest-bucket scan.
+func TestTrieRoutingTable_MatchesArrayOnRandomInputs(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		array, trie, rng := twinTables(seed)
+		if array.Size() != trie.Size() {
+			t.Fatalf("seed %d: sizes differ: array=%d trie=%d", seed, array.Size(), trie.Size())
+		}
+		for q := 0; q < 20; q++ {
+			var target KademliaID
+			rng.Read(target[:])
+
+			all := trie.FindClosestContacts(&target, trie.Size())
+			if fmt.Sprint(addrs(all)) != fmt.Sprint(addrs(array.FindClosestContacts(&target, array.Size()))) {
+				t.Fatalf("seed %d: tables hold different contacts", seed)
+			}
+
+			got := trie.FindClosestContacts(&target, bucketSize)
+			for i := range got {
+				if got[i].Address != all[i].Address {
+					t.Fatalf("seed %d: trie result %d is not the exact %d-th closest", seed, i, i)
+				}
+			}
+			arr := array.FindClosestContacts(&target, bucketSize)
+			for i := range got {
+				if arr[i].ID.CalcDistance(&target).Less(got[i].ID.CalcDistance(&target)) {
+					t.Fatalf("seed %d: array entry %d closer than trie's", seed, i)
+				}
+			}
+		}
+	}
+}
+
+// Removal and replacement promotion behave like the array layout.
+func TestTrieRoutingTable_RemoveContact(t *testing.T) {
+	rt := NewTrieRoutingTable(NewContact(NewKademliaID(zeroIDHex()), "127.0.0.1:9999"))
+	rt.SetPingFunc(func(c Contact) bool { return true })
+	for i := 0; i < bucketSize; i++ {
+		rt.AddContact(makeContact(i)) // all in the far half: never split
+	}
+	spare := makeContact(220)
+	rt.AddContact(spare)
+	if rt.Size() != bucketSize {
+		t.Fatalf("far bucket should stay capped at %d, got %d", bucketSize, rt.Size())
+	}
+	victim := makeContact(5)
+	if !rt.RemoveContact(victim.ID) {
+		t.Fatalf("RemoveContact should report removal")
+	}
+	got := rt.FindClosestContacts(targetID(), 100)
+	if containsAddr(got, victim.Address) || !containsAddr(got, spare.Address) {
+		t.Fatalf("removal should promote the replacement")
+	}
+}
+
+// A node built with the trie layout still joins and serves Put/Get.
+func TestTrieRoutingTable_NodePutGet(t *testing.T) {
+	nodes, contacts := m2Cluster(t, 4)
+	k, _ := m2NewNode(t, WithTrieRoutingTable())
+	if err := k.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	keyHex, err := k.Put([]byte("trie-backed"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if v, _, err := nodes[2].Get(keyHex); err != nil || string(v) != "trie-backed" {
+		t.Fatalf("Get = (%q, %v)", v, err)
+	}
+}
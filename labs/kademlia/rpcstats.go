@@ -0,0 +1,77 @@
+package kademlia
+
+// rpcstats.go: node-wide counters of RPCs sent, received and timed out.
+
+import "sync/atomic"
+
+// RPCStats is a snapshot of a node's RPC counters, which only ever grow.
+// Sent and Received count requests, not their replies; FIND_VALUE_META is
+// counted as FIND_VALUE. Timeouts counts requests of any kind we sent that
+// got no reply in time.
+type RPCStats struct {
+	PingsSent, PingsReceived         int64
+	FindNodeSent, FindNodeReceived   int64
+	FindValueSent, FindValueReceived int64
+	StoreSent, StoreReceived         int64
+	Timeouts                         int64
+}
+
+type rpcKind int
+
+const (
+	rpcPing rpcKind = iota
+	rpcFindNode
+	rpcFindValue
+	rpcStore
+	rpcKinds
+)
+
+// rpcKindOf maps a request type to its counter; replies have none.
+func rpcKindOf(t msgType) (rpcKind, bool) {
+	switch t {
+	case msgPing:
+		return rpcPing, true
+	case msgFindNode:
+		return rpcFindNode, true
+	case msgFindValue, msgFindMeta:
+		return rpcFindValue, true
+	case msgStore:
+		return rpcStore, true
+	}
+	return 0, false
+}
+
+// rpcCounters is the Network's counter set: sent is bumped by sendVia,
+// received by readLoop, timeouts by each client helper's timeout branch.
+type rpcCounters struct {
+	sent, received [rpcKinds]atomic.Int64
+	timeouts       atomic.Int64
+}
+
+func (c *rpcCounters) countSent(t msgType) {
+	if k, ok := rpcKindOf(t); ok {
+		c.sent[k].Add(1)
+	}
+}
+
+func (c *rpcCounters) countReceived(t msgType) {
+	if k, ok := rpcKindOf(t); ok {
+		c.received[k].Add(1)
+	}
+}
+
+// Stats returns the current RPC counters.
+func (network *Network) Stats() RPCStats {
+	c := &network.rpcs
+	return RPCStats{
+		PingsSent:         c.sent[rpcPing].Load(),
+		PingsReceived:     c.received[rpcPing].Load(),
+		FindNodeSent:      c.sent[rpcFindNode].Load(),
+		FindNodeReceived:  c.received[rpcFindNode].Load(),
+		FindValueSent:     c.sent[rpcFindValue].Load(),
+		FindValueReceived: c.received[rpcFindValue].Load(),
+		StoreSent:         c.sent[rpcStore].Load(),
+		StoreReceived:     c.received[rpcStore].Load(),
+		Timeouts:          c.timeouts.Load(),
+	}
+}
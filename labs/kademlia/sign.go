@@ -0,0 +1,107 @@
+package kademlia
+
+// sign.go: optional Ed25519 signatures on envelopes.
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha1"
+	"fmt"
+	"net"
+	"time"
+)
+
+// maxPeerKeys caps how many pinned keys a node remembers. Past it, the key
+// of the node ID heard from least recently is forgotten to make room.
+const maxPeerKeys = 4096
+
+// peerKey is a pinned key and when an envelope signed by it last arrived.
+type peerKey struct {
+	pub  ed25519.PublicKey
+	seen time.Time
+}
+
+// keyID is the node ID bound to pub under WithKeyDerivedID: its SHA-1, so
+// an ID next to a chosen key costs a brute-forced keypair, not a lie.
+func keyID(pub ed25519.PublicKey) KademliaID {
+	return KademliaID(sha1.Sum(pub))
+}
+
+// signedPayload is what a sender signs: the whole envelope but Sig, in
+// BinaryCodec form. Decoding either codec and re-encoding gives the same
+// bytes, so the receiver can rebuild it whatever the sender's codec, and no
+// field can be changed or a signature lifted onto another message.
+func signedPayload(env envelope) []byte {
+	env.Sig = nil
+	b, _ := BinaryCodec{}.marshal(env)
+	return b
+}
+
+// sign attaches our public key and signature to env, if signing is on.
+func (network *Network) sign(env *envelope) {
+	if network.kademlia == nil || network.kademlia.signKey == nil {
+		return
+	}
+	key := network.kademlia.signKey
+	env.From.PubKey = key.Public().(ed25519.PublicKey)
+	env.Sig = ed25519.Sign(key, signedPayload(*env))
+}
+
+// verify reports whether env, which arrived from src, carries a valid
+// signature and came from the address it claims. The first key seen for a
+// node ID is pinned: later envelopes claiming that ID must be signed by the
+// same key. Contacts a peer merely relays (in FIND_NODE_OK) aren't signed;
+// they're checked once they talk to us.
+func (network *Network) verify(env envelope, src *net.UDPAddr) error {
+	pub := ed25519.PublicKey(env.From.PubKey)
+	if len(pub) != ed25519.PublicKeySize || len(env.Sig) != ed25519.SignatureSize {
+		return fmt.Errorf("unsigned")
+	}
+	if !ed25519.Verify(pub, signedPayload(env), env.Sig) {
+		return fmt.Errorf("bad signature")
+	}
+	if !sameUDPAddr(env.From.Address, src) {
+		return fmt.Errorf("sent from %s, not its address %s", src, env.From.Address)
+	}
+	c, err := env.From.toContact()
+	if err != nil {
+		return err
+	}
+	if network.kademlia.keyDerivedID && *c.ID != keyID(pub) {
+		return fmt.Errorf("id %s is not the hash of its key", env.From.IDHex)
+	}
+	return network.pinKey(*c.ID, pub)
+}
+
+// pinKey binds id to pub unless id is already bound to another key, and
+// marks the binding as just used.
+func (network *Network) pinKey(id KademliaID, pub ed25519.PublicKey) error {
+	now := time.Now()
+	network.keysMu.Lock()
+	defer network.keysMu.Unlock()
+	if known, ok := network.peerKeys[id]; ok {
+		if !bytes.Equal(known.pub, pub) {
+			return fmt.Errorf("id %s is bound to another key", id.String())
+		}
+	} else if len(network.peerKeys) >= maxPeerKeys {
+		var oldest KademliaID
+		var oldestSeen time.Time
+		for k, v := range network.peerKeys {
+			if oldestSeen.IsZero() || v.seen.Before(oldestSeen) {
+				oldest, oldestSeen = k, v.seen
+			}
+		}
+		delete(network.peerKeys, oldest)
+	}
+	network.peerKeys[id] = peerKey{pub: pub, seen: now}
+	return nil
+}
+
+// sameUDPAddr reports whether the "host:port" address resolves to src.
+func sameUDPAddr(addr string, src *net.UDPAddr) bool {
+	if src == nil {
+		return false
+	}
+	a, err := net.ResolveUDPAddr("udp", addr)
+	return err == nil && a.Port == src.Port && a.IP.Equal(src.IP)
+}
@@ -1,7 +1,6 @@
 package kademlia
 
 import (
-	"crypto/rand"
 	"crypto/sha1"
 	"encoding/hex"
 	"flag"
@@ -18,12 +17,25 @@ import (
 //   -m4.nodes=N  (default 1000)
 //   -m4.drop=P   (percent 0..100; default 10)
 //   -m4.seed=S   (deterministic PRNG; default 1337)
+//
+// Loss-model knobs (only used by tests that call applyLossFlags):
+//   -m4.loss=M        (uniform | ge; default uniform)
+//   -m4.pairspread=P  (per-link drop% drawn from drop±P; default 0)
+//   -m4.ge.enter=P    (good->bad transition %; default 5)
+//   -m4.ge.exit=P     (bad->good transition %; default 30)
+//   -m4.ge.bad=P      (drop% while a link is bad; default 80)
 // -----------------------------
 
 var (
 	m4Nodes = flag.Int("m4.nodes", 1000, "number of nodes to emulate for M4")
 	m4Drop  = flag.Int("m4.drop", 10, "packet drop percentage 0..100")
 	m4Seed  = flag.Int64("m4.seed", 1337, "PRNG seed for deterministic simulation")
+
+	m4Loss       = flag.String("m4.loss", "uniform", "loss model: uniform or ge (Gilbert-Elliott)")
+	m4PairSpread = flag.Int("m4.pairspread", 0, "per-link drop% spread around -m4.drop (0 = same rate on every link)")
+	m4GEEnter    = flag.Int("m4.ge.enter", 5, "Gilbert-Elliott good->bad transition percentage")
+	m4GEExit     = flag.Int("m4.ge.exit", 30, "Gilbert-Elliott bad->good transition percentage")
+	m4GEBad      = flag.Int("m4.ge.bad", 80, "Gilbert-Elliott drop percentage while in the bad state")
 )
 
 // Ensure flags are parsed even if no TestMain is present.
@@ -40,6 +52,25 @@ type simCluster struct {
 	dropPct int         // 0..100
 	rng     *mrand.Rand // deterministic PRNG (seeded)
 	rngMu   sync.Mutex  // guard PRNG access
+
+	// Optional loss models; zero values keep the uniform IID behaviour.
+	pairSpread int             // per-link drop% is drawn from dropPct±pairSpread
+	pairDrop   map[simLink]int // lazily drawn per-link drop%
+	ge         *geParams       // non-nil enables Gilbert-Elliott bursty loss
+	geBad      map[simLink]bool
+}
+
+// simLink is a directed node pair; a->b and b->a are separate links so loss
+// can be asymmetric.
+type simLink struct{ from, to int }
+
+// geParams configures the two-state Gilbert-Elliott channel. Each link is
+// either "good" (drops at the cluster's normal rate) or "bad" (drops at
+// BadPct) and flips state before every packet with the given probabilities.
+type geParams struct {
+	EnterPct int // good -> bad, percent per packet
+	ExitPct  int // bad -> good, percent per packet
+	BadPct   int // drop% while bad
 }
 
 type simNode struct {
@@ -75,15 +106,7 @@ func newSimCluster(t *testing.T, n int, dropPct int, seed int64) *simCluster {
 
 // randomKID returns a random 160-bit KademliaID using math/rand (deterministic under seed).
 func randomKID(rng *mrand.Rand) KademliaID {
-	var id KademliaID
-	// Fill 20 bytes using crypto/rand mixed with math/rand for stability but uniqueness
-	// (test-only; determinism is from math/rand).
-	var tmp [20]byte
-	_, _ = rand.Read(tmp[:])
-	for i := 0; i < len(id); i++ {
-		id[i] = byte(rng.Intn(256)) ^ tmp[i]
-	}
-	return id
+	return *NewRandomKademliaIDFrom(rng) // same seed, same cluster
 }
 
 // itoa (fast small helper, no fmt)
@@ -141,17 +164,90 @@ func lessDist(x, y dist) bool {
 // Packet drop helper
 // -----------------------------
 
-func (c *simCluster) dropped() bool {
-	if c.dropPct <= 0 {
+// withPairDrops gives every directed link its own drop rate, drawn once from
+// [dropPct-spread, dropPct+spread] (clamped to 0..100) the first time the
+// link carries a packet.
+func (c *simCluster) withPairDrops(spread int) *simCluster {
+	c.pairSpread = spread
+	c.pairDrop = make(map[simLink]int)
+	return c
+}
+
+// withGilbertElliott switches the cluster to bursty loss. All links start in
+// the good state.
+func (c *simCluster) withGilbertElliott(p geParams) *simCluster {
+	c.ge = &p
+	c.geBad = make(map[simLink]bool)
+	return c
+}
+
+// applyLossFlags configures the loss model from the -m4.loss/-m4.pairspread/
+// -m4.ge.* flags.
+func (c *simCluster) applyLossFlags(t *testing.T) *simCluster {
+	if *m4PairSpread > 0 {
+		c.withPairDrops(*m4PairSpread)
+	}
+	switch *m4Loss {
+	case "", "uniform":
+	case "ge":
+		c.withGilbertElliott(geParams{EnterPct: *m4GEEnter, ExitPct: *m4GEExit, BadPct: *m4GEBad})
+	default:
+		t.Fatalf("unknown -m4.loss=%q (want uniform or ge)", *m4Loss)
+	}
+	return c
+}
+
+// dropped reports whether a packet from node index `from` to `to` is lost.
+// Caller must not hold rngMu.
+func (c *simCluster) dropped(from, to int) bool {
+	c.rngMu.Lock()
+	defer c.rngMu.Unlock()
+
+	link := simLink{from, to}
+	pct := c.dropPct
+	if c.pairDrop != nil {
+		pct = c.linkDropPct(link)
+	}
+	if c.ge != nil {
+		bad := c.geBad[link]
+		if bad {
+			bad = c.rng.Intn(100) >= c.ge.ExitPct
+		} else {
+			bad = c.rng.Intn(100) < c.ge.EnterPct
+		}
+		c.geBad[link] = bad
+		if bad {
+			pct = c.ge.BadPct
+		}
+	}
+
+	if pct <= 0 {
 		return false
 	}
-	if c.dropPct >= 100 {
+	if pct >= 100 {
 		return true
 	}
-	c.rngMu.Lock()
-	p := c.rng.Intn(100)
-	c.rngMu.Unlock()
-	return p < c.dropPct
+	return c.rng.Intn(100) < pct
+}
+
+// linkDropPct returns (drawing on first use) the drop rate of link.
+// Caller holds rngMu.
+func (c *simCluster) linkDropPct(link simLink) int {
+	if pct, ok := c.pairDrop[link]; ok {
+		return pct
+	}
+	pct := c.dropPct
+	if c.pairSpread > 0 {
+		pct += c.rng.Intn(2*c.pairSpread+1) - c.pairSpread
+	}
+	if pct < 0 {
+		pct = 0
+	}
+	if pct > 100 {
+		pct = 100
+	}
+	c.pairDrop[link] = pct
+	return pct
 }
 
 // -----------------------------
@@ -191,7 +287,7 @@ func (c *simCluster) simPut(origin int, value []byte) (keyHex string, keyID Kade
 			continue // already stored
 		}
 		// Network delivery for STORE may be dropped.
-		if c.dropped() {
+		if c.dropped(origin, idx) {
 			continue
 		}
 		c.nodes[idx].mu.Lock()
@@ -239,7 +335,7 @@ func (c *simCluster) simGet(start int, keyHex string) (val []byte, fromIdx int,
 	for i := 0; i < len(cands) && i < K; i++ {
 		idx := cands[i].idx
 		// Simulate request drop
-		if c.dropped() {
+		if c.dropped(start, idx) {
 			continue
 		}
 		// Check value
@@ -250,7 +346,7 @@ func (c *simCluster) simGet(start int, keyHex string) (val []byte, fromIdx int,
 			continue
 		}
 		// Simulate response drop
-		if c.dropped() {
+		if c.dropped(idx, start) {
 			continue
 		}
 		return append([]byte(nil), v...), idx, true
@@ -0,0 +1,115 @@
+package kademlia
+
+// state.go: whole-node snapshots (contacts + values) in a data directory.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// stateFile is the snapshot's name inside the data directory.
+const stateFile = "state.json"
+
+// nodeState is the JSON snapshot SaveState writes. Unlike a Store it also
+// keeps the routing table, so a restarted node can skip the full re-join.
+type nodeState struct {
+	ID       string            `json:"id"`
+	Contacts []wireContact     `json:"contacts"`
+	Values   map[string][]byte `json:"values"`  // keyHex -> value (base64)
+	Origins  []string          `json:"origins"` // keys we published
+	Fixed    []string          `json:"fixed,omitempty"`
+}
+
+// SaveState writes the node's contacts, values and origin keys to
+// dir/state.json, replacing any earlier snapshot. With WithDataDir this
+// happens on Close.
+func (kademlia *Kademlia) SaveState(dir string) error {
+	st := nodeState{
+		ID:     kademlia.me.ID.String(),
+		Values: make(map[string][]byte),
+	}
+	for _, c := range kademlia.routingTable.FindClosestContacts(kademlia.me.ID, kademlia.routingTable.Size()) {
+		st.Contacts = append(st.Contacts, fromContact(c))
+	}
+	kademlia.storeMu.RLock()
+	for k, v := range kademlia.valueStore {
+		st.Values[k] = v
+	}
+	kademlia.storeMu.RUnlock()
+	kademlia.originMu.RLock()
+	for k := range kademlia.originKeys {
+		st.Origins = append(st.Origins, k)
+	}
+	for k := range kademlia.fixedPlacement {
+		st.Fixed = append(st.Fixed, k)
+	}
+	kademlia.originMu.RUnlock()
+
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	// Write then rename, so a crash mid-save leaves the previous snapshot.
+	tmp := filepath.Join(dir, stateFile+".tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, stateFile))
+}
+
+// LoadState reads dir/state.json into the node: values and origin keys are
+// restored as saved, and each saved contact is PINGed (in parallel, for up
+// to one RPC timeout) and kept only if it answers. A missing snapshot is not
+// an error. NewKademlia calls this itself with WithDataDir.
+func (kademlia *Kademlia) LoadState(dir string) error {
+	b, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st nodeState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return fmt.Errorf("load state %s: %w", dir, err)
+	}
+	if st.ID != kademlia.me.ID.String() {
+		kademlia.infof("[STATE] %s was saved by node %s; loading it as %s", dir, st.ID, kademlia.me.ID.String())
+	}
+
+	kademlia.loadValues(st.Values, st.Origins)
+	if len(st.Fixed) > 0 {
+		kademlia.originMu.Lock()
+		if kademlia.fixedPlacement == nil {
+			kademlia.fixedPlacement = make(map[string]struct{})
+		}
+		for _, k := range st.Fixed {
+			kademlia.fixedPlacement[k] = struct{}{}
+		}
+		kademlia.originMu.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	for _, w := range st.Contacts {
+		c, err := w.toContact()
+		if err != nil || c.ID.Equals(kademlia.me.ID) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// PingWait adds c to the table on a PONG; silence drops it.
+			kademlia.network.PingWait(&c, kademlia.timeoutRPC)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
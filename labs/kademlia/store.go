@@ -0,0 +1,500 @@
+package kademlia
+
+// store.go: the node's local value store and its lifecycle hooks.
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalidKey is returned for keys that aren't 40 hex characters.
+var ErrInvalidKey = errors.New("invalid key: want 40 hex chars")
+
+// ErrStoreRejected is what every StoreRejectedError matches with errors.Is.
+var ErrStoreRejected = errors.New("store rejected")
+
+// Reasons a node gives in STORE_ERR.
+const (
+	rejectMismatch  = "value does not match key"
+	rejectFull      = "store full"
+	rejectTooLarge  = "value too large"
+	rejectAppendOff = "append keys disabled"
+	rejectClient    = "client mode: stores nothing"
+	rejectKeyedOff  = "keyed records disabled"
+	rejectBusy      = "busy checking manifests"
+)
+
+// StoreRejectedError is returned when a peer answers a STORE with STORE_ERR:
+// it was reached and refused, unlike a timeout. Reason is the peer's.
+type StoreRejectedError struct {
+	Peer   Contact
+	Reason string
+}
+
+func (e *StoreRejectedError) Error() string {
+	return fmt.Sprintf("%v by %s: %s", ErrStoreRejected, e.Peer.Address, e.Reason)
+}
+
+func (e *StoreRejectedError) Unwrap() error { return ErrStoreRejected }
+
+// ErrValueTooLarge is returned by Put for data over the SetMaxValueSize limit.
+var ErrValueTooLarge = errors.New("value too large")
+
+// defaultMaxValueBytes bounds what a peer can make us hold in one value.
+const defaultMaxValueBytes = 1 << 20
+
+// ErrUnsupportedScheme is returned for prefixed keys of a hash scheme this
+// node doesn't implement.
+var ErrUnsupportedScheme = errors.New("unsupported key scheme")
+
+// KeySchemeSHA1 is the multihash-style prefix for our keys: hash function
+// code 0x11 (SHA-1) and digest length 0x14 (20 bytes), in hex. Keys may carry
+// it in front of the 40-hex digest (see WithPrefixedKeys).
+const KeySchemeSHA1 = "1114"
+
+// canonicalKey strips an optional scheme prefix and lowercases the rest,
+// returning the bare 40-hex digest used on the wire and in the store, so
+// every spelling of a key names the same entry. An unknown prefix is an
+// error rather than a different key; anything no longer than a bare key is
+// passed through (lowercased) for the caller's usual validation.
+func canonicalKey(key string) (string, error) {
+	if len(key) <= 2*IDLength {
+		return strings.ToLower(key), nil
+	}
+	scheme, digest := key[:len(key)-2*IDLength], key[len(key)-2*IDLength:]
+	if !strings.EqualFold(scheme, KeySchemeSHA1) {
+		return "", fmt.Errorf("%w %q", ErrUnsupportedScheme, scheme)
+	}
+	return strings.ToLower(digest), nil
+}
+
+// displayKey is how keys are handed back to callers: bare, or with the
+// scheme prefix when WithPrefixedKeys is on.
+func (kademlia *Kademlia) displayKey(keyHex string) string {
+	if kademlia.prefixedKeys {
+		return KeySchemeSHA1 + keyHex
+	}
+	return keyHex
+}
+
+// ---- M2: local store helpers ----
+
+// parseKeyHex validates a 40-hex key (optionally scheme-prefixed) and
+// returns it as an ID.
+func parseKeyHex(keyHex string) (*KademliaID, error) {
+	keyHex, err := canonicalKey(keyHex)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyHex) != 2*IDLength {
+		return nil, ErrInvalidKey
+	}
+	b, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	var id KademliaID
+	copy(id[:], b)
+	return &id, nil
+}
+
+func (kademlia *Kademlia) keyFromData(data []byte) (keyHex string, id *KademliaID) {
+	sum := sha1.Sum(data) // 20 bytes
+	keyHex = hex.EncodeToString(sum[:])
+	var kid KademliaID
+	copy(kid[:], sum[:])
+	return keyHex, &kid
+}
+
+// storeLocal stores a value that never expires (our own, via Put).
+func (kademlia *Kademlia) storeLocal(keyHex string, value []byte) {
+	kademlia.storeLocalWithTTL(keyHex, value, 0)
+}
+
+// storeLocalWithTTL stores a value that loadLocal stops serving after ttl
+// and the sweeper then drops; ttl <= 0 means never. Storing again resets the
+// deadline, which is how republished replicas stay alive. Keys we originated
+// never get one: our own copy is authoritative. In client mode it does
+// nothing.
+func (kademlia *Kademlia) storeLocalWithTTL(keyHex string, value []byte, ttl time.Duration) {
+	kademlia.storeLocalFrom(keyHex, value, ttl, Provenance{})
+}
+
+// storeLocalFrom is storeLocalWithTTL that also records p as the value's
+// provenance, in the same step, so a record only exists for a value that
+// made it into the store. A zero p leaves the record alone, and so does a
+// non-Published p for a key we originated: our own values stay Published
+// whoever sends them back.
+func (kademlia *Kademlia) storeLocalFrom(keyHex string, value []byte, ttl time.Duration, p Provenance) {
+	if kademlia.clientMode {
+		return // a client holds nothing, cached or otherwise
+	}
+	origin := kademlia.isOrigin(keyHex)
+	if ttl > 0 && origin {
+		ttl = 0
+	}
+	kademlia.storeMu.Lock()
+	if kademlia.valueStore == nil { // lazy init to avoid nil map panics
+		kademlia.valueStore = make(map[string][]byte)
+	}
+	v := make([]byte, len(value)) // copy to avoid aliasing
+	copy(v, value)
+	kademlia.storedBytes += int64(len(v) - len(kademlia.valueStore[keyHex]))
+	kademlia.valueStore[keyHex] = v
+	kademlia.setExpiryLocked(keyHex, ttl)
+	if p.Source != 0 && (p.Source == Published || !origin) {
+		kademlia.setProvenanceLocked(keyHex, p)
+	}
+	kademlia.storeMu.Unlock()
+	kademlia.persistPut(keyHex, v)
+	kademlia.touch(keyHex)
+	kademlia.enforceCap(keyHex)
+}
+
+// contentMatchesKey reports whether value is the SHA-1 preimage of keyHex.
+func contentMatchesKey(keyHex string, value []byte) bool {
+	sum := sha1.Sum(value)
+	return hex.EncodeToString(sum[:]) == strings.ToLower(keyHex)
+}
+
+// valueTooLarge reports whether n bytes exceed the SetMaxValueSize limit.
+func (kademlia *Kademlia) valueTooLarge(n int) bool {
+	limit := kademlia.maxValueBytes.Load()
+	return limit > 0 && int64(n) > limit
+}
+
+// acceptValue applies the verifyContent policy to a value found for keyHex.
+// A keyed record is bound to its key by its name, so it passes too, as does
+// a chunk manifest: assemble checks the value it reassembles instead.
+func (kademlia *Kademlia) acceptValue(keyHex string, value []byte) bool {
+	if !kademlia.verifyContent || contentMatchesKey(keyHex, value) || keyedFitsKey(keyHex, value) {
+		return true
+	}
+	_, ok := decodeManifest(value)
+	return ok
+}
+
+// holdsValue reports whether the store holds exactly value under keyHex.
+func (kademlia *Kademlia) holdsValue(keyHex string, value []byte) bool {
+	kademlia.storeMu.RLock()
+	defer kademlia.storeMu.RUnlock()
+	held, ok := kademlia.valueStore[keyHex]
+	return ok && bytes.Equal(held, value)
+}
+
+func (kademlia *Kademlia) loadLocal(keyHex string) ([]byte, bool) {
+	kademlia.storeMu.RLock()
+	if kademlia.valueStore == nil { // handle nil map safely
+		kademlia.storeMu.RUnlock()
+		return nil, false
+	}
+	v, ok := kademlia.valueStore[keyHex]
+	deadline := kademlia.expires[keyHex]
+	kademlia.storeMu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	if !deadline.IsZero() && time.Now().After(deadline) && !kademlia.isPinned(keyHex) {
+		return nil, false // expired; the sweeper will drop it
+	}
+	kademlia.touch(keyHex)
+	out := make([]byte, len(v)) // return a copy
+	copy(out, v)
+	return out, true
+}
+
+// originLocal returns our own copy of a key this node originated via Put.
+// The origin is authoritative for its data, so every Get variant serves this
+// copy straight away instead of searching the network for a "better" one
+// (which would block until timeout if we're partitioned).
+func (kademlia *Kademlia) originLocal(keyHex string) ([]byte, bool) {
+	if !kademlia.isOrigin(keyHex) {
+		return nil, false
+	}
+	return kademlia.loadLocal(keyHex)
+}
+
+// isOrigin reports whether this node published keyHex itself.
+func (kademlia *Kademlia) isOrigin(keyHex string) bool {
+	kademlia.originMu.RLock()
+	defer kademlia.originMu.RUnlock()
+	_, mine := kademlia.originKeys[keyHex]
+	return mine
+}
+
+// storedCount is how many values the local store holds, ours and cached.
+func (kademlia *Kademlia) storedCount() int {
+	kademlia.storeMu.RLock()
+	defer kademlia.storeMu.RUnlock()
+	return len(kademlia.valueStore)
+}
+
+// HasLocal reports whether the local store holds an unexpired value for
+// keyHex. Unlike Get it never touches the network, caches anything or
+// refreshes the key's LRU recency, so scripts can check replication without
+// disturbing it. Malformed keys report false.
+func (kademlia *Kademlia) HasLocal(keyHex string) bool {
+	keyHex, err := canonicalKey(keyHex)
+	if err != nil {
+		return false
+	}
+	// Read the maps directly: loadLocal would bump the key's LRU recency.
+	kademlia.storeMu.RLock()
+	_, ok := kademlia.valueStore[keyHex]
+	deadline := kademlia.expires[keyHex]
+	kademlia.storeMu.RUnlock()
+	if !ok {
+		return false
+	}
+	return deadline.IsZero() || !time.Now().After(deadline) || kademlia.isPinned(keyHex)
+}
+
+// LocalKeys lists every key in the local store, ours and cached, sorted.
+func (kademlia *Kademlia) LocalKeys() []string {
+	kademlia.storeMu.RLock()
+	keys := make([]string, 0, len(kademlia.valueStore))
+	for k := range kademlia.valueStore {
+		keys = append(keys, k)
+	}
+	kademlia.storeMu.RUnlock()
+	sort.Strings(keys)
+	return keys
+}
+
+// CachedKeys lists stored keys this node did not originate: replicas pushed
+// to us and copies cached by Get. Order is unspecified.
+func (kademlia *Kademlia) CachedKeys() []string {
+	kademlia.originMu.RLock()
+	defer kademlia.originMu.RUnlock()
+	kademlia.storeMu.RLock()
+	defer kademlia.storeMu.RUnlock()
+	keys := make([]string, 0, len(kademlia.valueStore))
+	for k := range kademlia.valueStore {
+		if _, mine := kademlia.originKeys[k]; !mine {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// KeysInRange lists stored keys whose XOR distance to our own ID is at most
+// maxDistance, nearest first: the node's zone of responsibility as seen from
+// its local store.
+func (kademlia *Kademlia) KeysInRange(maxDistance *KademliaID) []string {
+	type entry struct {
+		key  string
+		dist *KademliaID
+	}
+	var in []entry
+	kademlia.storeMu.RLock()
+	for k := range kademlia.valueStore {
+		id, err := parseKeyHex(k)
+		if err != nil {
+			continue
+		}
+		d := id.CalcDistance(kademlia.me.ID)
+		if d.Less(maxDistance) || d.Equals(maxDistance) {
+			in = append(in, entry{k, d})
+		}
+	}
+	kademlia.storeMu.RUnlock()
+	sort.Slice(in, func(i, j int) bool { return in[i].dist.Less(in[j].dist) })
+	keys := make([]string, len(in))
+	for i, e := range in {
+		keys[i] = e.key
+	}
+	return keys
+}
+
+// ClearCache drops every cached (non-origin) value, keeping origin and pinned values.
+// Each drop is reported to OnEvict as Forgotten. Returns how many were dropped.
+func (kademlia *Kademlia) ClearCache() int {
+	n := 0
+	for _, k := range kademlia.CachedKeys() {
+		if kademlia.isPinned(k) {
+			continue
+		}
+		if kademlia.evictLocal(k, Forgotten) {
+			n++
+		}
+	}
+	return n
+}
+
+// Forget drops keyHex from the local store and, if we published it, stops
+// the republisher maintaining it. Copies already on other nodes are left to
+// expire. Forgetting a chunked value forgets its chunks too. The drop is
+// reported to OnEvict as Forgotten. Returns whether we held the value or
+// owned the key; malformed keys report false.
+func (kademlia *Kademlia) Forget(keyHex string) bool {
+	keyHex, err := canonicalKey(keyHex)
+	if err != nil {
+		return false
+	}
+	if _, err := parseKeyHex(keyHex); err != nil {
+		return false
+	}
+	if v, ok := kademlia.loadLocal(keyHex); ok {
+		if m, ok := decodeManifest(v); ok {
+			for _, c := range m.Chunks {
+				kademlia.Forget(c)
+			}
+		}
+	}
+	kademlia.originMu.Lock()
+	_, mine := kademlia.originKeys[keyHex]
+	delete(kademlia.originKeys, keyHex)
+	delete(kademlia.fixedPlacement, keyHex)
+	delete(kademlia.republished, keyHex)
+	kademlia.originMu.Unlock()
+	if mine {
+		kademlia.persistUnmarkOrigin(keyHex)
+	}
+	held := kademlia.evictLocal(keyHex, Forgotten)
+	return held || mine
+}
+
+// ---- eviction observability ----
+
+// EvictReason says why a value left the local store.
+type EvictReason int
+
+const (
+	TTLExpired      EvictReason = iota + 1 // entry outlived its expiry
+	CapacityEvicted                        // dropped to stay under the storage cap
+	Forgotten                              // explicitly removed by the user
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case TTLExpired:
+		return "ttl_expired"
+	case CapacityEvicted:
+		return "capacity_evicted"
+	case Forgotten:
+		return "forgotten"
+	default:
+		return "unknown"
+	}
+}
+
+// ProvenanceSource is how a value got into the local store.
+type ProvenanceSource int
+
+const (
+	Published ProvenanceSource = iota + 1 // Put/PutAt/PutTo on this node
+	Stored                                // a peer sent it in a STORE
+	Fetched                               // our own Get cached it
+	Restored                              // loaded from a Store or state snapshot
+)
+
+// Provenance records where the local copy of a value came from and when.
+// Peer is set for Stored and Fetched.
+type Provenance struct {
+	Source ProvenanceSource
+	Peer   Contact
+	At     time.Time
+}
+
+func (p Provenance) String() string {
+	switch p.Source {
+	case Published:
+		return "published here"
+	case Stored:
+		return "stored by " + p.Peer.Address
+	case Fetched:
+		return "fetched via Get from " + p.Peer.Address
+	case Restored:
+		return "restored from disk"
+	default:
+		return "unknown"
+	}
+}
+
+// setProvenanceLocked records how keyHex's current copy arrived, stamped
+// now. Callers hold storeMu for writing.
+func (kademlia *Kademlia) setProvenanceLocked(keyHex string, p Provenance) {
+	p.At = time.Now()
+	if kademlia.provenance == nil {
+		kademlia.provenance = make(map[string]Provenance)
+	}
+	kademlia.provenance[keyHex] = p
+}
+
+// ValueProvenance reports where this node obtained the copy of keyHex it
+// holds: published here, stored by a peer, or fetched by a Get, and when.
+// Storing the value again (a republish, say) replaces the record. ok is
+// false if we don't hold the value or don't know how it arrived.
+func (kademlia *Kademlia) ValueProvenance(keyHex string) (p Provenance, ok bool) {
+	keyHex, err := canonicalKey(keyHex)
+	if err != nil {
+		return Provenance{}, false
+	}
+	kademlia.storeMu.RLock()
+	defer kademlia.storeMu.RUnlock()
+	if _, held := kademlia.valueStore[keyHex]; !held {
+		return Provenance{}, false
+	}
+	p, ok = kademlia.provenance[keyHex]
+	return p, ok
+}
+
+// OnEvict registers fn to be called (outside store locks) whenever a value is
+// dropped from the local store. Passing nil removes the observer.
+func (kademlia *Kademlia) OnEvict(fn func(key string, reason EvictReason)) {
+	kademlia.storeMu.Lock()
+	kademlia.onEvict = fn
+	kademlia.storeMu.Unlock()
+}
+
+// Evictions returns how many values have been dropped for reason so far.
+func (kademlia *Kademlia) Evictions(reason EvictReason) int64 {
+	if reason < TTLExpired || reason > Forgotten {
+		return 0
+	}
+	return kademlia.evictions[reason-1].Load()
+}
+
+// evictLocal removes keyHex from the local store, counts it, and notifies the
+// OnEvict observer. Every path that drops values (TTL sweep, capacity limit,
+// forget) goes through here. Returns false if the key wasn't stored.
+func (kademlia *Kademlia) evictLocal(keyHex string, reason EvictReason) bool {
+	return kademlia.evictLocalIfExpired(keyHex, reason, time.Time{})
+}
+
+// evictLocalIfExpired is evictLocal that, for a non-zero now, only drops the
+// value if its deadline has passed by then. The check happens under the
+// store lock, so a value refreshed after the sweeper's scan survives.
+func (kademlia *Kademlia) evictLocalIfExpired(keyHex string, reason EvictReason, now time.Time) bool {
+	kademlia.storeMu.Lock()
+	v, ok := kademlia.valueStore[keyHex]
+	if deadline := kademlia.expires[keyHex]; !now.IsZero() && (deadline.IsZero() || !now.After(deadline)) {
+		ok = false
+	}
+	if ok {
+		kademlia.storedBytes -= int64(len(v))
+		delete(kademlia.valueStore, keyHex)
+		delete(kademlia.expires, keyHex)
+		delete(kademlia.provenance, keyHex)
+	}
+	fn := kademlia.onEvict
+	kademlia.storeMu.Unlock()
+	if !ok {
+		return false
+	}
+	kademlia.persistDelete(keyHex)
+	kademlia.forget(keyHex)
+	if reason >= TTLExpired && reason <= Forgotten {
+		kademlia.evictions[reason-1].Add(1)
+	}
+	if fn != nil {
+		fn(keyHex, reason)
+	}
+	return true
+}
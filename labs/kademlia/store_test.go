@@ -0,0 +1,406 @@
+package kademlia
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type evictEvent struct {
+	key    string
+	reason EvictReason
+}
+
+// captureEvictions records OnEvict notifications for k.
+func captureEvictions(k *Kademlia) *[]evictEvent {
+	var got []evictEvent
+	k.OnEvict(func(key string, reason EvictReason) {
+		got = append(got, evictEvent{key, reason})
+	})
+	return &got
+}
+
+// Dropping a value reports the key and reason once and bumps that reason's counter.
+func TestStore_EvictLocalNotifiesObserver(t *testing.T) {
+	k, _ := m2NewNode(t)
+	got := captureEvictions(k)
+
+	key := m2KeyHex([]byte("soon gone"))
+	k.storeLocal(key, []byte("soon gone"))
+
+	if !k.evictLocal(key, CapacityEvicted) {
+		t.Fatalf("evictLocal should report removal of a stored key")
+	}
+	if _, ok := k.loadLocal(key); ok {
+		t.Fatalf("value still present after eviction")
+	}
+	if len(*got) != 1 || (*got)[0] != (evictEvent{key, CapacityEvicted}) {
+		t.Fatalf("unexpected notifications: %+v", *got)
+	}
+	if n := k.Evictions(CapacityEvicted); n != 1 {
+		t.Fatalf("CapacityEvicted counter = %d, want 1", n)
+	}
+	if n := k.Evictions(TTLExpired) + k.Evictions(Forgotten); n != 0 {
+		t.Fatalf("other reasons should stay at zero, got %d", n)
+	}
+
+	// Evicting a missing key is a no-op: no callback, no count.
+	if k.evictLocal(key, Forgotten) {
+		t.Fatalf("evicting an absent key should report false")
+	}
+	if len(*got) != 1 || k.Evictions(Forgotten) != 0 {
+		t.Fatalf("absent-key eviction must not notify or count")
+	}
+}
+
+func TestStore_EvictReasonString(t *testing.T) {
+	for r, want := range map[EvictReason]string{
+		TTLExpired: "ttl_expired", CapacityEvicted: "capacity_evicted", Forgotten: "forgotten",
+	} {
+		if r.String() != want {
+			t.Errorf("%d.String() = %q, want %q", r, r.String(), want)
+		}
+	}
+}
+
+// ClearCache drops cached values only; origin values survive.
+func TestStore_ClearCacheKeepsOriginValues(t *testing.T) {
+	k, _ := m2NewNode(t, WithRepublish(false))
+	got := captureEvictions(k)
+
+	owned, err := k.Put([]byte("mine")) // no peers: stored locally as origin
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	cached := m2KeyHex([]byte("someone else's"))
+	k.storeLocal(cached, []byte("someone else's"))
+
+	if keys := k.CachedKeys(); len(keys) != 1 || keys[0] != cached {
+		t.Fatalf("CachedKeys = %v, want [%s]", keys, cached)
+	}
+	if n := k.ClearCache(); n != 1 {
+		t.Fatalf("ClearCache dropped %d, want 1", n)
+	}
+	if _, ok := k.loadLocal(cached); ok {
+		t.Fatalf("cached value survived ClearCache")
+	}
+	if v, ok := k.loadLocal(owned); !ok || string(v) != "mine" {
+		t.Fatalf("origin value lost by ClearCache")
+	}
+	if len(*got) != 1 || (*got)[0] != (evictEvent{cached, Forgotten}) {
+		t.Fatalf("unexpected notifications: %+v", *got)
+	}
+	if len(k.CachedKeys()) != 0 {
+		t.Fatalf("CachedKeys should be empty after ClearCache")
+	}
+}
+
+// A node restarted with the same ID and Store reloads its values and keeps
+// republishing the keys it originated before the restart.
+func TestStore_RestartResumesRepublish(t *testing.T) {
+	st := NewMemoryStore()
+	idHex := m2RandIDHex(t)
+	data := []byte("survives restart")
+
+	first, _ := m2NewNodeWithID(t, idHex, WithStore(st))
+	key, err := first.Put(data) // alone: stored locally only
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_ = first.Close()
+
+	peer, peerMe := m2NewNode(t)
+	second, _ := m2NewNodeWithID(t, idHex, WithStore(st), WithRepublishInterval(30*time.Millisecond))
+	if v, ok := second.originLocal(key); !ok || string(v) != string(data) {
+		t.Fatalf("restarted node lost its origin value: %q ok=%v", v, ok)
+	}
+	if err := second.Join(&peerMe); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	if !m2WaitUntil(t, 2*time.Second, func() bool { _, ok := peer.loadLocal(key); return ok }) {
+		t.Fatalf("restarted node never republished its origin key to the peer")
+	}
+}
+
+// KeysInRange keeps keys within the XOR radius (inclusive), nearest first.
+func TestStore_KeysInRange(t *testing.T) {
+	var zero KademliaID
+	k, _ := m2NewNodeWithID(t, zero.String())
+
+	at := func(lastByte byte) string {
+		var id KademliaID
+		id[IDLength-1] = lastByte
+		return id.String()
+	}
+	far := "ff" + at(0)[2:]
+	for _, key := range []string{at(0x40), at(0x01), at(0x10), far} {
+		k.storeLocal(key, []byte(key))
+	}
+
+	var radius KademliaID
+	radius[IDLength-1] = 0x10
+	got := k.KeysInRange(&radius)
+	want := []string{at(0x01), at(0x10)}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("KeysInRange(0x10) = %v, want %v", got, want)
+	}
+
+	var max KademliaID
+	for i := range max {
+		max[i] = 0xff
+	}
+	if got := k.KeysInRange(&max); len(got) != 4 || got[3] != far {
+		t.Fatalf("KeysInRange(max) = %v, want all 4 with the far key last", got)
+	}
+	if got := k.KeysInRange(&zero); len(got) != 0 {
+		t.Fatalf("KeysInRange(0) = %v, want none", got)
+	}
+}
+
+// Past the capacity the least recently used cached value goes first; pinned
+// and origin values survive, and a pinned key also survives ClearCache.
+func TestStore_CapacityEvictsLRUButKeepsPinned(t *testing.T) {
+	k, _ := m2NewNode(t, WithStoreCapacity(3))
+	got := captureEvictions(k)
+
+	mine, err := k.Put([]byte("origin"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	pinned := m2KeyHex([]byte("pinned config"))
+	if err := k.Pin(pinned); err != nil {
+		t.Fatalf("Pin: %v", err)
+	}
+	k.storeLocal(pinned, []byte("pinned config")) // oldest cached value
+	var cached []string
+	for _, s := range []string{"c1", "c2", "c3"} {
+		key := m2KeyHex([]byte(s))
+		k.storeLocal(key, []byte(s))
+		cached = append(cached, key)
+	}
+
+	for _, key := range []string{mine, pinned, cached[2]} {
+		if _, ok := k.loadLocal(key); !ok {
+			t.Fatalf("%s evicted; want origin, pinned and newest kept", key)
+		}
+	}
+	for _, key := range cached[:2] {
+		if _, ok := k.loadLocal(key); ok {
+			t.Fatalf("unpinned cached %s survived past the cap", key)
+		}
+	}
+	if len(*got) != 2 || (*got)[0].key != cached[0] || (*got)[0].reason != CapacityEvicted {
+		t.Fatalf("evictions = %+v, want c1 then c2 as capacity_evicted", *got)
+	}
+
+	if n := k.ClearCache(); n != 1 {
+		t.Fatalf("ClearCache dropped %d, want only the unpinned one", n)
+	}
+	if _, ok := k.loadLocal(pinned); !ok {
+		t.Fatalf("ClearCache dropped a pinned value")
+	}
+	if err := k.Unpin(pinned); err != nil {
+		t.Fatalf("Unpin: %v", err)
+	}
+	if n := k.ClearCache(); n != 1 {
+		t.Fatalf("after Unpin ClearCache dropped %d, want 1", n)
+	}
+	if err := k.Pin("not-a-key"); err == nil {
+		t.Fatalf("Pin accepted a malformed key")
+	}
+}
+
+// Past the byte quota the least recently accessed cached values go first
+// (a read counts as access); origin values survive even a quota they exceed.
+func TestStore_StorageQuotaEvictsLRUCached(t *testing.T) {
+	k, _ := m2NewNode(t)
+	got := captureEvictions(k)
+	mine, err := k.Put(bytes.Repeat([]byte("o"), 300))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	k.SetStorageQuota(1000)
+
+	cached := make([]string, 3)
+	for i := range cached {
+		v := bytes.Repeat([]byte{byte('a' + i)}, 250)
+		cached[i] = m2KeyHex(v)
+		k.storeLocalWithTTL(cached[i], v, time.Hour)
+		if i == 1 {
+			k.loadLocal(cached[0]) // c0 read after c1 was stored: c1 is now the oldest
+		}
+	}
+
+	if _, ok := k.loadLocal(cached[1]); ok {
+		t.Fatalf("least recently accessed cached value survived past the quota")
+	}
+	for _, key := range []string{mine, cached[0], cached[2]} {
+		if _, ok := k.loadLocal(key); !ok {
+			t.Fatalf("%s evicted; want origin and recently used values kept", key)
+		}
+	}
+	if len(*got) != 1 || (*got)[0].key != cached[1] || (*got)[0].reason != CapacityEvicted {
+		t.Fatalf("evictions = %+v, want only c1 as capacity_evicted", *got)
+	}
+
+	k.SetStorageQuota(100) // below the origin value alone
+	if _, ok := k.loadLocal(mine); !ok {
+		t.Fatalf("origin value evicted by the quota")
+	}
+	if n := k.Evictions(CapacityEvicted); n != 3 {
+		t.Fatalf("CapacityEvicted = %d, want all 3 cached values", n)
+	}
+}
+
+// HasLocal is a pure check: polling a key doesn't save it from LRU eviction.
+func TestStore_HasLocalLeavesLRUOrder(t *testing.T) {
+	k, _ := m2NewNode(t, WithStoreCapacity(2))
+	a, b, c := m2KeyHex([]byte("a")), m2KeyHex([]byte("b")), m2KeyHex([]byte("c"))
+	k.storeLocal(a, []byte("a"))
+	k.storeLocal(b, []byte("b"))
+	if !k.HasLocal(a) {
+		t.Fatalf("HasLocal(a) = false right after storing it")
+	}
+	k.storeLocal(c, []byte("c")) // a is still the least recently used
+	if k.HasLocal(a) {
+		t.Fatalf("a survived past the cap; HasLocal refreshed its recency")
+	}
+	if !k.HasLocal(b) || !k.HasLocal(c) {
+		t.Fatalf("b or c evicted instead of a")
+	}
+}
+
+// Append sets are filed under the canonical key, bounded by the storage
+// quota and the append-key cap, and expire storeTTL after their last append.
+func TestStore_AppendSetsBoundedAndExpire(t *testing.T) {
+	k, _ := m2NewNode(t, WithAppendKeys(true), WithStoreTTL(time.Hour))
+	key := m2KeyHex([]byte("topic"))
+	if err := k.PutAppend(KeySchemeSHA1+strings.ToUpper(key), []byte("one")); err != nil {
+		t.Fatalf("PutAppend prefixed: %v", err)
+	}
+	if err := k.PutAppend(key, []byte("two")); err != nil {
+		t.Fatalf("PutAppend bare: %v", err)
+	}
+	if set := k.loadAppended(key); len(set) != 2 || len(k.appendStore) != 1 {
+		t.Fatalf("set = %q over %d keys, want both values under one key", set, len(k.appendStore))
+	}
+
+	k.SetStorageQuota(10)
+	if _, reason := k.appendLocal(key, []byte("over the quota")); reason != rejectFull {
+		t.Fatalf("append past the quota: reason %q, want %q", reason, rejectFull)
+	}
+	k.SetStorageQuota(0)
+	for i := len(k.appendStore); i < maxAppendKeys; i++ {
+		if _, reason := k.appendLocal(m2KeyHex([]byte(strconv.Itoa(i))), []byte("v")); reason != "" {
+			t.Fatalf("append key %d refused: %s", i, reason)
+		}
+	}
+	if _, reason := k.appendLocal(m2KeyHex([]byte("one too many")), []byte("v")); reason != rejectFull {
+		t.Fatalf("append key past the cap: reason %q, want %q", reason, rejectFull)
+	}
+
+	k.storeMu.Lock()
+	for _, set := range k.appendStore {
+		set.expires = time.Now().Add(-time.Second)
+	}
+	k.storeMu.Unlock()
+	if set := k.loadAppended(key); set != nil {
+		t.Fatalf("expired set still served: %q", set)
+	}
+	if n := k.sweepAppendSets(); n != maxAppendKeys || k.appendBytes != 0 {
+		t.Fatalf("sweep dropped %d sets leaving %d bytes, want all %d and 0", n, k.appendBytes, maxAppendKeys)
+	}
+}
+
+// A value stored with a TTL stops being served once it expires. Our own
+// values ignore a TTL from STORE, and a pinned value is still served.
+func TestStore_TTLExpiry(t *testing.T) {
+	k, _ := m2NewNode(t)
+
+	key := m2KeyHex([]byte("short lived"))
+	k.storeLocalWithTTL(key, []byte("short lived"), 50*time.Millisecond)
+	if _, ok := k.loadLocal(key); !ok {
+		t.Fatalf("value missing before its TTL")
+	}
+	mine, err := k.Put([]byte("my own"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	k.storeLocalWithTTL(mine, []byte("my own"), 50*time.Millisecond) // a peer's STORE
+	pinned := m2KeyHex([]byte("pinned"))
+	if err := k.Pin(pinned); err != nil {
+		t.Fatalf("Pin: %v", err)
+	}
+	k.storeLocalWithTTL(pinned, []byte("pinned"), 50*time.Millisecond)
+
+	time.Sleep(80 * time.Millisecond)
+	if _, ok := k.loadLocal(key); ok {
+		t.Fatalf("loadLocal served a value past its TTL")
+	}
+	if _, ok := k.loadLocal(mine); !ok {
+		t.Fatalf("origin value expired")
+	}
+	if _, ok := k.loadLocal(pinned); !ok {
+		t.Fatalf("pinned value expired")
+	}
+	if n := k.sweepExpired(); n != 1 || k.Evictions(TTLExpired) != 1 {
+		t.Fatalf("sweep dropped %d (counter %d), want 1", n, k.Evictions(TTLExpired))
+	}
+}
+
+// The background sweeper drops expired values by itself.
+func TestStore_SweeperEvictsExpired(t *testing.T) {
+	fastSweep := func(k *Kademlia) { k.sweepInterval = 10 * time.Millisecond }
+	k, _ := m2NewNode(t, fastSweep)
+
+	key := m2KeyHex([]byte("sweep me"))
+	k.storeLocalWithTTL(key, []byte("sweep me"), 20*time.Millisecond)
+	if !m2WaitUntil(t, time.Second, func() bool { return k.Evictions(TTLExpired) == 1 }) {
+		t.Fatalf("sweeper never evicted the expired value")
+	}
+	if keys := k.CachedKeys(); len(keys) != 0 {
+		t.Fatalf("store still holds %v", keys)
+	}
+}
+
+// Close writes the data directory and a node started on it comes back with
+// its values and the contacts that still answer, without joining.
+func TestState_SaveOnCloseReloadOnStart(t *testing.T) {
+	_, contacts := m2Cluster(t, 3)
+	dir := t.TempDir()
+	idHex := m2RandIDHex(t)
+
+	first, _ := m2NewNodeWithID(t, idHex, WithDataDir(dir))
+	if err := first.Join(&contacts[0]); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	key, err := first.Put([]byte("kept on disk"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	replica := m2KeyHex([]byte("someone else's"))
+	first.storeLocalWithTTL(replica, []byte("someone else's"), time.Hour)
+	gone := NewContact(NewKademliaID(m2RandIDHex(t)), "127.0.0.1:"+strconv.Itoa(m2FreeUDPPort(t)))
+	first.routingTable.AddContact(gone)
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	second, _ := m2NewNodeWithID(t, idHex, WithDataDir(dir))
+	for _, c := range contacts {
+		if !hasContactWithAddress(second, c.Address) {
+			t.Fatalf("reloaded table is missing live contact %s", c.Address)
+		}
+	}
+	if hasContactWithAddress(second, gone.Address) {
+		t.Fatalf("reloaded table kept a contact that no longer answers")
+	}
+	if v, ok := second.originLocal(key); !ok || string(v) != "kept on disk" {
+		t.Fatalf("origin value not restored: %q ok=%v", v, ok)
+	}
+	if _, ok := second.loadLocal(replica); !ok {
+		t.Fatalf("replica value not restored")
+	}
+}
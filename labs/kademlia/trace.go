@@ -0,0 +1,146 @@
+package kademlia
+
+// trace.go: lookup diagnostics: the optional per-round convergence trace
+// (WithLookupTrace), per-lookup stats for OnLookup, and the per-peer hop
+// list of GetVerbose.
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Why a lookup loop ended.
+const (
+	stopEmptyBatch = "empty batch"      // no unvisited live candidates left
+	stopNoLive     = "no live contacts" // every known contact failed this lookup
+	stopConverged  = "converged"        // the best live contact didn't get closer
+	stopFound      = "value found"      // Get only
+)
+
+// LookupStats describes one finished iterative lookup, for OnLookup.
+type LookupStats struct {
+	Kind    string // "lookup" (LookupContact, Join, placement) or "get"
+	Target  KademliaID
+	Rounds  int    // α-wide rounds until the lookup converged or stopped
+	Queried int    // peers sent a request, confirmation of the k closest included
+	Stop    string // why the rounds ended, e.g. "converged" or "value found"; "" if cancelled
+}
+
+// OnLookup registers fn to be called with the stats of every iterative
+// lookup once it finishes, on the goroutine that ran it. Lookups answered
+// from the local store don't count. Passing nil removes the hook.
+func (kademlia *Kademlia) OnLookup(fn func(LookupStats)) {
+	if fn == nil {
+		kademlia.onLookup.Store(nil)
+		return
+	}
+	kademlia.onLookup.Store(&fn)
+}
+
+// lookupTrace follows one lookup's rounds: it writes them to the trace
+// writer, if any, and hands the totals to the OnLookup hook, if any. Methods
+// on a nil *lookupTrace (neither is set) do nothing, so the loops call them
+// unconditionally.
+type lookupTrace struct {
+	w       io.Writer   // nil: count only
+	mu      *sync.Mutex // shared per node: concurrent lookups write whole lines
+	hook    func(LookupStats)
+	kind    string // "lookup" or "get"
+	target  *KademliaID
+	round   int
+	queried int
+	reason  string
+}
+
+func (kademlia *Kademlia) newTrace(kind string, target *KademliaID) *lookupTrace {
+	var hook func(LookupStats)
+	if fn := kademlia.onLookup.Load(); fn != nil {
+		hook = *fn
+	}
+	if kademlia.traceOut == nil && hook == nil {
+		return nil
+	}
+	return &lookupTrace{w: kademlia.traceOut, mu: &kademlia.traceMu, hook: hook, kind: kind, target: target}
+}
+
+func (t *lookupTrace) printf(format string, args ...any) {
+	if t.w == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "[TRACE %s %s] "+format+"\n", append([]any{t.kind, t.target.String()[:8]}, args...)...)
+}
+
+// batch starts a round and lists who it queries, with their distances.
+func (t *lookupTrace) batch(batch []Contact) {
+	if t == nil {
+		return
+	}
+	t.round++
+	t.queried += len(batch)
+	if t.w == nil {
+		return
+	}
+	peers := make([]string, len(batch))
+	for i, c := range batch {
+		peers[i] = c.Address + " dist=" + c.ID.CalcDistance(t.target).String()
+	}
+	t.printf("round %d batch %d: %s", t.round, len(batch), strings.Join(peers, ", "))
+}
+
+// best records the closest live distance before and after the round.
+func (t *lookupTrace) best(before, after *KademliaID) {
+	if t == nil || t.w == nil {
+		return
+	}
+	was := "none"
+	if before != nil {
+		was = before.CalcDistance(t.target).String()
+	}
+	t.printf("round %d best before=%s after=%s", t.round, was, after.CalcDistance(t.target).String())
+}
+
+func (t *lookupTrace) stop(reason string) {
+	if t == nil {
+		return
+	}
+	t.reason = reason
+	t.printf("stop after %d round(s): %s", t.round, reason)
+}
+
+// confirm counts queries sent after the rounds, confirming the k closest.
+func (t *lookupTrace) confirm(batch []Contact) {
+	if t != nil {
+		t.queried += len(batch)
+	}
+}
+
+// done reports the finished lookup to the OnLookup hook.
+func (t *lookupTrace) done() {
+	if t == nil || t.hook == nil {
+		return
+	}
+	t.hook(LookupStats{Kind: t.kind, Target: *t.target, Rounds: t.round, Queried: t.queried, Stop: t.reason})
+}
+
+// GetHop is one peer's answer during a GetVerbose lookup.
+type GetHop struct {
+	Peer     Contact
+	HadValue bool  // it returned the value we accepted
+	Rejected bool  // it returned a value that doesn't hash to the key
+	Contacts int   // contacts it returned
+	Err      error // no answer: timeout, send failure or cancellation
+}
+
+// hopList collects a lookup's hops in the order answers arrive. Adding to a
+// nil *hopList does nothing, so getValue records unconditionally.
+type hopList []GetHop
+
+func (h *hopList) add(hop GetHop) {
+	if h != nil {
+		*h = append(*h, hop)
+	}
+}
@@ -0,0 +1,166 @@
+package kademlia
+
+// transport.go: what Network sends and receives datagrams through (UDP, or
+// an in-memory network for tests).
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Transport carries datagrams for one listen address. Network runs a read
+// loop per transport and replies through the one a request arrived on.
+type Transport interface {
+	// Send transmits b to to. Like UDP, delivery is not guaranteed.
+	Send(b []byte, to *net.UDPAddr) error
+	// Receive blocks for the next datagram and copies it into buf. It
+	// returns an error once the transport is closed.
+	Receive(buf []byte) (n int, from *net.UDPAddr, err error)
+	Close() error
+	// LocalAddr is the address the transport is bound to.
+	LocalAddr() *net.UDPAddr
+}
+
+// udpTransport is the default Transport: a bound UDP socket.
+type udpTransport struct {
+	conn *net.UDPConn
+}
+
+func listenUDP(addr string) (Transport, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	return udpTransport{conn: conn}, nil
+}
+
+func (t udpTransport) Send(b []byte, to *net.UDPAddr) error {
+	_, err := t.conn.WriteToUDP(b, to)
+	return err
+}
+
+func (t udpTransport) Receive(buf []byte) (int, *net.UDPAddr, error) {
+	return t.conn.ReadFromUDP(buf)
+}
+
+func (t udpTransport) Close() error { return t.conn.Close() }
+
+func (t udpTransport) LocalAddr() *net.UDPAddr {
+	addr, _ := t.conn.LocalAddr().(*net.UDPAddr)
+	return addr
+}
+
+// MemNet is an in-memory datagram network: transports from the same MemNet
+// hand datagrams to each other directly, with no sockets. Pass its Listen to
+// WithTransport on every node of a test cluster; the contact addresses are
+// ordinary "host:port" strings that only need to be unique within the MemNet.
+type MemNet struct {
+	mu    sync.RWMutex
+	ports map[string]*memTransport
+	drop  func(from, to string) bool
+}
+
+// memQueue is how many datagrams a memTransport holds; more are lost, like
+// a full socket buffer.
+const memQueue = 1024
+
+// NewMemNet returns an empty in-memory network.
+func NewMemNet() *MemNet {
+	return &MemNet{ports: make(map[string]*memTransport)}
+}
+
+// SetDrop installs fn to decide, per datagram, whether it is lost between
+// the from and to addresses; nil (the default) delivers everything. fn is
+// called from the sender's goroutine, so a seeded PRNG behind it (such as
+// the M4 simulator's) keeps loss deterministic.
+func (m *MemNet) SetDrop(fn func(from, to string) bool) {
+	m.mu.Lock()
+	m.drop = fn
+	m.mu.Unlock()
+}
+
+// Listen binds a transport to addr, which must not already be in use on m.
+func (m *MemNet) Listen(addr string) (Transport, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, taken := m.ports[udpAddr.String()]; taken {
+		return nil, fmt.Errorf("memnet: %s already in use", udpAddr)
+	}
+	t := &memTransport{
+		net:    m,
+		addr:   udpAddr,
+		inbox:  make(chan memDatagram, memQueue),
+		closed: make(chan struct{}),
+	}
+	m.ports[udpAddr.String()] = t
+	return t, nil
+}
+
+type memDatagram struct {
+	b    []byte
+	from *net.UDPAddr
+}
+
+type memTransport struct {
+	net       *MemNet
+	addr      *net.UDPAddr
+	inbox     chan memDatagram
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var errMemClosed = errors.New("memnet: transport closed")
+
+func (t *memTransport) Send(b []byte, to *net.UDPAddr) error {
+	select {
+	case <-t.closed:
+		return errMemClosed
+	default:
+	}
+	t.net.mu.RLock()
+	dst := t.net.ports[to.String()]
+	drop := t.net.drop
+	t.net.mu.RUnlock()
+	if dst == nil || (drop != nil && drop(t.addr.String(), to.String())) {
+		return nil // nobody listening, or lost: the sender can't tell
+	}
+	select {
+	case dst.inbox <- memDatagram{b: append([]byte(nil), b...), from: t.addr}:
+	default: // receiver's queue is full
+	}
+	return nil
+}
+
+func (t *memTransport) Receive(buf []byte) (int, *net.UDPAddr, error) {
+	select {
+	case d := <-t.inbox:
+		return copy(buf, d.b), d.from, nil
+	case <-t.closed:
+		return 0, nil, errMemClosed
+	}
+}
+
+// Close unbinds the address, so a restarted node can listen on it again.
+func (t *memTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		t.net.mu.Lock()
+		if t.net.ports[t.addr.String()] == t {
+			delete(t.net.ports, t.addr.String())
+		}
+		t.net.mu.Unlock()
+	})
+	return nil
+}
+
+func (t *memTransport) LocalAddr() *net.UDPAddr { return t.addr }
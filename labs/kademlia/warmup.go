@@ -0,0 +1,64 @@
+package kademlia
+
+// warmup.go: optional post-join grace period for replica STOREs (see WithJoinWarmup).
+
+import "time"
+
+// beginWarmup starts the grace period, if one is configured. Called when
+// Join starts so STOREs that race the join itself are covered too.
+func (kademlia *Kademlia) beginWarmup() {
+	if kademlia.warmupFor <= 0 {
+		return
+	}
+	kademlia.warmupUntil.Store(time.Now().Add(kademlia.warmupFor).UnixNano())
+	// Make sure held values are promoted even if no further traffic arrives.
+	time.AfterFunc(kademlia.warmupFor, func() { kademlia.warmingUp() })
+}
+
+// warmingUp reports whether replica STOREs should still be held back: the
+// routing table has fewer than warmupMinPeers contacts and the warmup
+// duration hasn't elapsed. The first call that sees warmup over promotes
+// everything held so far into the store.
+func (kademlia *Kademlia) warmingUp() bool {
+	until := kademlia.warmupUntil.Load()
+	if until == 0 {
+		return false
+	}
+	if time.Now().UnixNano() < until && kademlia.routingTable.Size() < kademlia.warmupMinPeers {
+		return true
+	}
+	if kademlia.warmupUntil.CompareAndSwap(until, 0) {
+		kademlia.promoteProvisional()
+	}
+	return false
+}
+
+// provisionalReplica is a replica held during warmup and how it arrived.
+type provisionalReplica struct {
+	value []byte
+	from  Provenance
+}
+
+// holdProvisional parks a replica received during warmup. It is not served,
+// and p not recorded, until promoteProvisional runs; storeFull counts it
+// against the store's limits meanwhile.
+func (kademlia *Kademlia) holdProvisional(keyHex string, value []byte, p Provenance) {
+	v := append([]byte(nil), value...)
+	kademlia.provMu.Lock()
+	if kademlia.provisional == nil {
+		kademlia.provisional = make(map[string]provisionalReplica)
+	}
+	kademlia.provBytes += int64(len(v) - len(kademlia.provisional[keyHex].value))
+	kademlia.provisional[keyHex] = provisionalReplica{value: v, from: p}
+	kademlia.provMu.Unlock()
+}
+
+func (kademlia *Kademlia) promoteProvisional() {
+	kademlia.provMu.Lock()
+	held := kademlia.provisional
+	kademlia.provisional, kademlia.provBytes = nil, 0
+	kademlia.provMu.Unlock()
+	for k, r := range held {
+		kademlia.storeLocalFrom(k, r.value, kademlia.storeTTL, r.from)
+	}
+}
@@ -4,10 +4,13 @@ package kademlia
 
 import (
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 )
 
+// protocolVersion is the envelope format this build speaks. Bump it for any
+// change an older node would misread; envelopes of other versions are dropped.
+const protocolVersion = 1
+
 // Message types (M1 only)
 type msgType string
 
@@ -23,18 +26,43 @@ const (
 const (
 	msgStore       msgType = "STORE"
 	msgStoreOK     msgType = "STORE_OK"
+	msgStoreErr    msgType = "STORE_ERR" // store refused, nothing stored; Reason says why
 	msgFindValue   msgType = "FIND_VALUE"
 	msgFindValueOK msgType = "FIND_VALUE_OK"
+
+	// FIND_VALUE_META is FIND_VALUE without the bytes: the FIND_VALUE_OK reply
+	// carries Found/Size on a hit, contacts otherwise.
+	msgFindMeta msgType = "FIND_VALUE_META"
 )
 
+// Capabilities is the feature bitmask a node advertises in its PONG so peers
+// can adapt what they send it.
+type Capabilities uint32
+
+const (
+	// CapFindValueMeta: answers FIND_VALUE_META (size/existence probes).
+	CapFindValueMeta Capabilities = 1 << iota
+)
+
+// localCapabilities is what this build supports.
+const localCapabilities = CapFindValueMeta
+
+// Has reports whether every bit of want is set.
+func (c Capabilities) Has(want Capabilities) bool { return c&want == want }
+
 // Minimal serializable contact for the wire. We do NOT serialize the in-memory
 // distance field; this avoids changing your Contact struct.
 type wireContact struct {
 	IDHex   string `json:"id"`
 	Address string `json:"address"`
+	PubKey  []byte `json:"pub,omitempty"` // sender's Ed25519 key, on signed envelopes
 }
 
+// toContact parses a wire contact; an empty, short, or non-hex ID is an error.
 func (w wireContact) toContact() (Contact, error) {
+	if len(w.IDHex) != 2*IDLength {
+		return Contact{}, fmt.Errorf("invalid id hex length: got %d want %d", len(w.IDHex), 2*IDLength)
+	}
 	idBytes, err := hex.DecodeString(w.IDHex)
 	if err != nil {
 		return Contact{}, err
@@ -47,25 +75,74 @@ func (w wireContact) toContact() (Contact, error) {
 	return Contact{ID: &id, Address: w.Address}, nil
 }
 
+// fromContact converts a Contact for the wire. A nil ID becomes an empty hex
+// string (which toContact rejects) instead of panicking.
 func fromContact(c Contact) wireContact {
-	return wireContact{
-		IDHex:   c.ID.String(),
-		Address: c.Address,
+	w := wireContact{Address: c.Address}
+	if c.ID != nil {
+		w.IDHex = c.ID.String()
 	}
+	return w
 }
 
 // Common envelope for all M1 messages.
 type envelope struct {
+	Version  int           `json:"v,omitempty"` // protocolVersion; absent (older nodes) = 1
 	Type     msgType       `json:"type"`
 	From     wireContact   `json:"from"`
 	MsgID    string        `json:"msg_id"`
+	Seq      uint64        `json:"seq,omitempty"`       // sender's request number; replies echo it
 	TargetID string        `json:"target_id,omitempty"` // hex string
 	Contacts []wireContact `json:"contacts,omitempty"`  // for FIND_NODE_OK
+	// FIND_NODE: how many contacts the requester wants; 0 (older nodes) means
+	// bucketSize, and more than maxFindNodeCount gets maxFindNodeCount.
+	Count int `json:"count,omitempty"`
 
 	// M2 fields:
 	KeyHex string `json:"key,omitempty"`   // 40-char hex (SHA-1)
 	Value  []byte `json:"value,omitempty"` // raw bytes (base64 on wire)
+	// Value is gzipped (see compress.go); receivers inflate it on arrival.
+	Compressed bool `json:"z,omitempty"`
+	// STORE: Value is a keyed record (PutAt) stored under its name's key, not
+	// a value hashed to KeyHex.
+	Keyed bool `json:"keyed,omitempty"`
+	// FIND_VALUE: on a hit, send the closest contacts along with the value.
+	WithContacts bool `json:"with_contacts,omitempty"`
+	// STORE: add Value to KeyHex's append set (PutAppend). FIND_VALUE: answer
+	// with that set in Values.
+	Append bool     `json:"append,omitempty"`
+	Values [][]byte `json:"values,omitempty"`
+
+	// Sender is in client mode (WithClientMode): don't add it to routing
+	// tables, so it is never asked to hold a value.
+	Client bool `json:"client,omitempty"`
+
+	// PONG: the responder's capabilities (absent from older nodes => 0).
+	Caps Capabilities `json:"caps,omitempty"`
+
+	// FIND_VALUE_META reply fields:
+	Found bool `json:"found,omitempty"` // responder holds the key
+	Size  int  `json:"size,omitempty"`  // value length in bytes
+
+	// STORE_ERR: why the store was refused (see StoreRejectedError).
+	Reason string `json:"reason,omitempty"`
+
+	// Ed25519 signature over the rest of the envelope (see sign.go), when the
+	// sender signs.
+	Sig []byte `json:"sig,omitempty"`
+
+	// Local only (never marshaled): the operation a request is charged to.
+	op *opAccount
+}
+
+// version is the envelope's protocol version, reading a missing one as 1.
+func (e envelope) version() int {
+	if e.Version == 0 {
+		return 1
+	}
+	return e.Version
 }
 
-func (e envelope) marshal() ([]byte, error)  { return json.Marshal(e) }
-func (e *envelope) unmarshal(b []byte) error { return json.Unmarshal(b, e) }
+// marshal encodes e as JSON; unmarshal accepts any codec (see codec.go).
+func (e envelope) marshal() ([]byte, error)  { return JSONCodec{}.marshal(e) }
+func (e *envelope) unmarshal(b []byte) error { return decodeEnvelope(b, e) }
@@ -0,0 +1,139 @@
+package kademlia
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Contacts survive fromContact -> JSON envelope -> toContact unchanged.
+func TestWireContact_RoundTrip(t *testing.T) {
+	c := NewContact(NewKademliaID("00112233445566778899aabbccddeeff00112233"), "127.0.0.1:9001")
+
+	env := envelope{Type: msgPing, From: fromContact(c), MsgID: "m1",
+		Contacts: []wireContact{fromContact(c)}}
+	b, err := env.marshal()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var got envelope
+	if err := got.unmarshal(b); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	for _, w := range []wireContact{got.From, got.Contacts[0]} {
+		back, err := w.toContact()
+		if err != nil {
+			t.Fatalf("toContact: %v", err)
+		}
+		if !back.ID.Equals(c.ID) || back.Address != c.Address {
+			t.Fatalf("round trip changed contact: got %v want %v", back.String(), c.String())
+		}
+	}
+}
+
+// A contact with a nil ID must not panic on the way out and must not parse back in.
+func TestWireContact_NilIDDoesNotPanic(t *testing.T) {
+	w := fromContact(Contact{Address: "127.0.0.1:9001"})
+	if w.IDHex != "" {
+		t.Fatalf("nil ID should encode as empty hex, got %q", w.IDHex)
+	}
+	if _, err := w.toContact(); err == nil {
+		t.Fatalf("empty ID hex should be rejected")
+	}
+}
+
+func TestWireContact_RejectsMalformedIDs(t *testing.T) {
+	cases := map[string]string{
+		"empty":   "",
+		"short":   "0011223344",
+		"long":    strings.Repeat("ab", IDLength+1),
+		"odd":     strings.Repeat("a", 2*IDLength-1),
+		"non-hex": strings.Repeat("zz", IDLength),
+	}
+	for name, idHex := range cases {
+		if _, err := (wireContact{IDHex: idHex, Address: "127.0.0.1:1"}).toContact(); err == nil {
+			t.Errorf("%s: expected toContact to reject %q", name, idHex)
+		}
+	}
+}
+
+// NewContactValidated accepts host:port forms and rejects everything else early.
+func TestNewContactValidated(t *testing.T) {
+	id := NewKademliaID("00112233445566778899aabbccddeeff00112233")
+	for _, addr := range []string{"127.0.0.1:9001", "[::1]:9001", "node-a.local:4000"} {
+		c, err := NewContactValidated(id, addr)
+		if err != nil || c.Address != addr || !c.ID.Equals(id) {
+			t.Fatalf("NewContactValidated(%q) = %v, %v; want the contact", addr, c, err)
+		}
+	}
+	for _, addr := range []string{"127.0.0.1;9001", "127.0.0.1", ":9001", "127.0.0.1:port", "127.0.0.1:0", "127.0.0.1:70000", ""} {
+		if _, err := NewContactValidated(id, addr); !errors.Is(err, ErrInvalidAddress) {
+			t.Fatalf("NewContactValidated(%q): err = %v, want ErrInvalidAddress", addr, err)
+		}
+	}
+	if _, err := NewContactValidated(nil, "127.0.0.1:9001"); err == nil {
+		t.Fatalf("NewContactValidated accepted a nil id")
+	}
+}
+
+// A STORE survives both codecs unchanged, and the binary form is the smaller.
+func TestCodecs_StoreRoundTrip(t *testing.T) {
+	value := bytes.Repeat([]byte("payload-"), 512)
+	c := NewContact(NewKademliaID("00112233445566778899aabbccddeeff00112233"), "127.0.0.1:9001")
+	env := envelope{
+		Version: protocolVersion,
+		Type:    msgStore,
+		From:    fromContact(c),
+		MsgID:   "store-1",
+		Seq:     42,
+		KeyHex:  m2KeyHex(value),
+		Value:   value,
+	}
+
+	sizes := map[string]int{}
+	for name, codec := range map[string]Codec{"json": JSONCodec{}, "binary": BinaryCodec{}} {
+		b, err := codec.marshal(env)
+		if err != nil {
+			t.Fatalf("%s marshal: %v", name, err)
+		}
+		sizes[name] = len(b)
+		var got envelope
+		if err := got.unmarshal(b); err != nil { // sniffs the codec
+			t.Fatalf("%s unmarshal: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, env) {
+			t.Fatalf("%s round trip changed the envelope:\n got %+v\nwant %+v", name, got, env)
+		}
+	}
+	if sizes["binary"] >= sizes["json"] {
+		t.Fatalf("binary STORE is %d bytes, JSON %d; want binary smaller", sizes["binary"], sizes["json"])
+	}
+	t.Logf("STORE of %d bytes: json=%d binary=%d", len(value), sizes["json"], sizes["binary"])
+
+	if err := (BinaryCodec{}).unmarshal([]byte{binaryMagic, 1, 5, 'S'}, &envelope{}); err == nil {
+		t.Fatalf("truncated binary envelope was accepted")
+	}
+}
+
+// Nodes sending different codecs still understand each other.
+func TestCodecs_MixedNodesPutGet(t *testing.T) {
+	a, _ := m2NewNode(t, WithCodec(BinaryCodec{}))
+	b, bMe := m2NewNode(t)
+	if err := a.Join(&bMe); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	for _, tc := range []struct {
+		from, to *Kademlia
+		data     string
+	}{{a, b, "sent in binary"}, {b, a, "sent as JSON"}} {
+		key, err := tc.from.Put([]byte(tc.data))
+		if err != nil {
+			t.Fatalf("Put %q: %v", tc.data, err)
+		}
+		if v, ok := tc.to.loadLocal(key); !ok || string(v) != tc.data {
+			t.Fatalf("%q not replicated across codecs: got %q, %v", tc.data, v, ok)
+		}
+	}
+}
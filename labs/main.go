@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"time"
 
 	"d7024e/kademlia"
 )
@@ -17,11 +16,11 @@ func main() {
 	defer anode.Close()
 	defer bnode.Close()
 
-	// Join via bootstrap (PING + iterative FIND_NODE on self)
-	_ = anode.Join(&b)
-
-	// Give the async responses a moment (UDP over localhost is fast, but be safe)
-	time.Sleep(300 * time.Millisecond)
+	// Join via bootstrap (PING + iterative FIND_NODE on self, retried until B answers)
+	if err := anode.Join(&b); err != nil {
+		fmt.Println("join failed:", err)
+		return
+	}
 
 	// Now a can lookup b (or any target ID)
 	target := kademlia.NewContact(b.ID, "")